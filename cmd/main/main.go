@@ -18,6 +18,21 @@ func main() {
 		fmt.Println("    status   Show CAS status and analytics")
 		fmt.Println("    verify   Verify all the contents of the storage")
 		fmt.Println("    serve    Start HTTP API server")
+		fmt.Println("    repack   Pack small loose objects and consolidate packs")
+		fmt.Println("    gc       Delete objects no longer referenced by the catalog")
+		fmt.Println("    pin      Pin blobs so cache eviction never removes them")
+		fmt.Println("    fsck     Check stored objects, quarantine corrupt ones and repair the layout")
+		fmt.Println("    tier     Move blobs that have not been read recently to the cold tier")
+		fmt.Println("    checkout Materialize catalog files into a directory using links where possible")
+		fmt.Println("    migrate  Convert the storage layout: shard depth, packs or compression")
+		fmt.Println("    hold     Place or release retention and legal holds on blobs and paths")
+		fmt.Println("    holds    List holds in force or the log of hold changes")
+		fmt.Println("    commit   Record the catalog as an immutable snapshot")
+		fmt.Println("    log      List committed snapshots, newest first")
+		fmt.Println("    history  List the recorded versions of a catalog path")
+		fmt.Println("    rm       Remove files or directories from the catalog")
+		fmt.Println("    mv       Rename a file or directory in the catalog")
+		fmt.Println("    doctor   Report the catalog schema version and migrate it")
 		os.Exit(1)
 	}
 
@@ -26,7 +41,7 @@ func main() {
 
 	switch command {
 	case "init":
-		commands.Init()
+		commands.Init(args)
 	case "add":
 		commands.Add(args)
 	case "ls":
@@ -41,6 +56,36 @@ func main() {
 		commands.Verify()
 	case "serve":
 		commands.Serve(args)
+	case "repack":
+		commands.Repack(args)
+	case "gc":
+		commands.GC(args)
+	case "pin":
+		commands.Pin(args)
+	case "fsck":
+		commands.Fsck(args)
+	case "tier":
+		commands.Tier(args)
+	case "checkout":
+		commands.Checkout(args)
+	case "migrate":
+		commands.Migrate(args)
+	case "hold":
+		commands.Hold(args)
+	case "holds":
+		commands.Holds(args)
+	case "commit":
+		commands.Commit(args)
+	case "log":
+		commands.Log()
+	case "history":
+		commands.History(args)
+	case "rm":
+		commands.Remove(args)
+	case "mv":
+		commands.Move(args)
+	case "doctor":
+		commands.Doctor(args)
 	default:
 		fmt.Println("Not a valid command")
 		os.Exit(1)
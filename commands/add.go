@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
 	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/fsmeta"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 )
 
 func Add(args []string) {
@@ -26,7 +31,8 @@ func Add(args []string) {
 	}
 	defer c.Close()
 
-	info, err := os.Stat(targetPath)
+	// Lstat, so that a symlink is recorded as a link rather than followed.
+	info, err := os.Lstat(targetPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to access %s: %v\n", targetPath, err)
 		os.Exit(1)
@@ -40,7 +46,7 @@ func Add(args []string) {
 			os.Exit(1)
 		}
 	} else {
-		if err := addFile(ctx, c, targetPath); err != nil {
+		if err := addPath(ctx, c, targetPath, info); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to add file: %v\n", err)
 			os.Exit(1)
 		}
@@ -58,56 +64,83 @@ func Add(args []string) {
 	fmt.Printf("Successfully added %s\n", targetPath)
 }
 
+// addDirectory adds the directory, everything under it, and the directories
+// themselves, so that empty ones and their permissions survive a checkout.
+// Symlinks are recorded, not followed.
 func addDirectory(ctx context.Context, c client.Client, dirPath string) error {
-	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+	return filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("failed to walk the new directory: %w", err)
 		}
 
-		if info.IsDir() {
-			if filepath.Base(path) == ".cas" {
-				return filepath.SkipDir
-			}
+		if d.IsDir() && filepath.Base(path) == ".cas" {
+			return filepath.SkipDir
+		}
 
+		// "." would name the catalog root, which has no entry of its own.
+		if filepath.Clean(path) == "." {
 			return nil
 		}
 
-		return addFile(ctx, c, path)
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		return addPath(ctx, c, path, info)
 	})
 }
 
-func addFile(ctx context.Context, c client.Client, filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+// addPath records a file, directory or symlink with its metadata. The blob
+// of a symlink holds its target and a directory points at the empty blob.
+func addPath(ctx context.Context, c client.Client, filePath string, info fs.FileInfo) error {
+	if info.Mode().Type()&(fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeCharDevice|fs.ModeIrregular) != 0 {
+		fmt.Printf("     %s skipped (special file)\n", filePath)
+		return nil
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
+	entry, err := fsmeta.Capture(filePath, info)
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %v\n", err)
+		return fmt.Errorf("failed to read metadata of %s: %w", filePath, err)
 	}
 
-	hash, err := c.Upload(ctx, file)
+	var content io.Reader
+	switch entry.Type {
+	case catalog.TypeSymlink:
+		content = strings.NewReader(entry.Target)
+	case catalog.TypeDir:
+		content = strings.NewReader("")
+	default:
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		content = file
+	}
+
+	hash, err := c.Upload(ctx, content)
 	if err != nil {
 		return fmt.Errorf("failed to upload blob: %w", err)
 	}
-
-	entry := catalog.Entry{
-		Filepath: filePath,
-		Hash:     hash,
-		Filesize: uint64(info.Size()),
-		ModTime:  info.ModTime(),
+	entry.Hash = hash
+
+	label := objects.ShortDigest(hash)
+	switch entry.Type {
+	case catalog.TypeSymlink:
+		label += " (symlink to " + entry.Target + ")"
+	case catalog.TypeDir:
+		label = "(directory)"
 	}
 
 	if err := c.AddEntry(ctx, entry); err != nil {
 		if errors.Is(err, client.ErrCatalogNotSupported) {
-			fmt.Printf("     %s -> %s (uploaded)\n", filePath, hash[:8])
+			fmt.Printf("     %s -> %s (uploaded)\n", filePath, label)
 			return nil
 		}
 		return fmt.Errorf("failed to add catalog entry: %w", err)
 	}
 
-	fmt.Printf("     %s -> %s\n", filePath, hash[:8])
+	fmt.Printf("     %s -> %s\n", filePath, label)
 	return nil
 }
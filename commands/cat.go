@@ -3,20 +3,42 @@ package commands
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
 	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
 func Cat(args []string) {
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "Usage: ./cas cat <filepath>\n")
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+
+	at := fs.String("at", "", "Read the file as it was in this snapshot (HEAD, HEAD~n or a hash)")
+	version := fs.Int("version", 0, "Read this version of the file, as numbered by cas history")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas cat [--at <snapshot> | --version <n>] <filepath>\n")
 		os.Exit(1)
 	}
 
-	filePath := args[0]
+	filePath := fs.Arg(0)
+
+	if *at != "" && *version > 0 {
+		fmt.Fprintf(os.Stderr, "--at and --version cannot be combined\n")
+		os.Exit(1)
+	}
+
+	if *at != "" {
+		catAt(*at, filePath)
+		return
+	}
 
 	c, err := client.NewClientFromEnv()
 	if err != nil {
@@ -27,10 +49,19 @@ func Cat(args []string) {
 
 	ctx := context.Background()
 
-	entry, err := c.GetEntry(ctx, filePath)
+	var entry catalog.Entry
+	if *version > 0 {
+		var v catalog.Version
+		v, err = c.GetEntryVersion(ctx, filePath, *version)
+		entry = v.Entry
+	} else {
+		entry, err = c.GetEntry(ctx, filePath)
+	}
 	if err != nil {
 		if errors.Is(err, client.ErrEntryNotFound) {
 			fmt.Fprintf(os.Stderr, "This file doesn't exist in the catalog: %s\n", filePath)
+		} else if errors.Is(err, client.ErrVersionNotFound) {
+			fmt.Fprintf(os.Stderr, "Version %d of %s is not in the catalog\n", *version, filePath)
 		} else {
 			fmt.Fprintf(os.Stderr, "Failed to get entry: %v\n", err)
 		}
@@ -50,3 +81,48 @@ func Cat(args []string) {
 
 	io.Copy(os.Stdout, reader)
 }
+
+// catAt reads a file from a snapshot. Snapshots live in the local
+// repository, so this never goes through a remote server.
+func catAt(ref, filePath string) {
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	snap, err := snapshot.Resolve(cat, store, ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	entry, ok := snap.Entry(filePath)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "This file doesn't exist in snapshot %s: %s\n", ref, filePath)
+		os.Exit(1)
+	}
+
+	reader, err := store.OpenBlob(entry.Hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "Blob not found in storage: %s\n", entry.Hash)
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to open blob: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	defer reader.Close()
+
+	io.Copy(os.Stdout, reader)
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/checkout"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Checkout(args []string) {
+	fs := flag.NewFlagSet("checkout", flag.ExitOnError)
+
+	mode := fs.String("mode", checkout.ModeAuto, "How to materialize files: auto, hardlink, reflink or copy")
+	force := fs.Bool("force", false, "Replace files that already exist in the destination")
+
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas checkout [--mode auto|hardlink|reflink|copy] [--force] <dest> [path-prefix...]\n")
+		os.Exit(1)
+	}
+
+	if !checkout.ValidMode(*mode) {
+		fmt.Fprintf(os.Stderr, "Unknown checkout mode: %s\n", *mode)
+		os.Exit(1)
+	}
+
+	dest := fs.Arg(0)
+	prefixes := fs.Args()[1:]
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockShared).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	entries, err := cat.ListEntries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load catalog: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(prefixes) > 0 {
+		var selected []catalog.Entry
+		for _, entry := range entries {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(entry.Filepath, prefix) {
+					selected = append(selected, entry)
+					break
+				}
+			}
+		}
+		entries = selected
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprint(os.Stderr, "No matching files in catalog\n")
+		os.Exit(1)
+	}
+
+	result, err := checkout.Run(store, entries, dest, checkout.Options{Mode: *mode, Overwrite: *force})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Checkout failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Checkout Results:")
+	fmt.Printf("  Hardlinked: %d\n", result.Hardlinked)
+	fmt.Printf("  Reflinked: %d\n", result.Reflinked)
+	fmt.Printf("  Copied: %d\n", result.Copied)
+	fmt.Printf("  Directories: %d\n", result.Dirs)
+	fmt.Printf("  Symlinks: %d\n", result.Symlinks)
+	fmt.Printf("  Skipped (already exist): %d\n", result.Skipped)
+	fmt.Printf("  Total Size: %s\n", catalog.FormatSize(uint64(result.Bytes)))
+
+	if len(result.Unrestored) > 0 {
+		fmt.Printf("\n%d hardlinked files share their stored object's modification time and owner instead of the recorded ones:\n", len(result.Unrestored))
+		for _, p := range result.Unrestored {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+}
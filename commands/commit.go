@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Commit(args []string) {
+	fs := flag.NewFlagSet("commit", flag.ExitOnError)
+
+	message := fs.String("m", "", "Message describing the snapshot")
+	author := fs.String("author", defaultAuthor(), "Author recorded in the snapshot")
+
+	fs.Parse(args)
+
+	if *message == "" {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas commit -m <message> [--author name]\n")
+		os.Exit(1)
+	}
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockShared).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	snap, err := snapshot.Commit(cat, store, *author, *message)
+	if err != nil {
+		if errors.Is(err, snapshot.ErrNothingToCommit) {
+			fmt.Println("Nothing to commit")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Failed to commit: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("[%s] %s (%d files)\n", objects.ShortDigest(snap.Hash), snap.Message, len(snap.Entries))
+}
+
+// defaultAuthor names the person committing, preferring CAS_AUTHOR over the
+// login name.
+func defaultAuthor() string {
+	if author := os.Getenv("CAS_AUTHOR"); author != "" {
+		return author
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
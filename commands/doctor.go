@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+)
+
+func Doctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+
+	schema := fs.Bool("schema", false, "Report the catalog schema version and its migrations")
+	migrateTo := fs.Int("migrate-to", -1, "Migrate the catalog schema up or down to this version (1 or later)")
+	yes := fs.Bool("yes", false, "Confirm a downgrade, which drops what the reverted migrations added")
+
+	fs.Parse(args)
+
+	if !*schema && *migrateTo < 0 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas doctor --schema [--migrate-to <version> [--yes]]\n")
+		os.Exit(1)
+	}
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Reporting only reads; a migration rewrites tables others are using.
+	// A running server keeps the catalog open between requests, which the
+	// repository lock does not cover, so it has to be stopped first.
+	lockMode := path.LockShared
+	if *migrateTo >= 0 {
+		lockMode = path.LockExclusive
+
+		serveLock, err := path.AcquireServeLock(repo.RootDir, path.LockExclusive)
+		if errors.Is(err, path.ErrServerRunning) {
+			fmt.Fprintf(os.Stderr, "cas serve is running on this repository; stop it before migrating the catalog schema\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to lock repository: %v\n", err)
+			os.Exit(1)
+		}
+		defer serveLock.Unlock()
+	}
+	defer lockRepo(repo, lockMode).Unlock()
+
+	if *migrateTo >= 0 {
+		before, err := catalog.ReadSchema(repo.RootDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read catalog schema: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Going back loses data, so say exactly what goes and only go on
+		// once that has been confirmed.
+		if *migrateTo < before.Version && *migrateTo >= catalog.OldestSchema {
+			fmt.Println("Reverting these migrations drops what they added:")
+			for v := min(before.Version, len(before.Migrations)); v > *migrateTo; v-- {
+				fmt.Printf("  %3d  %s\n", v, before.Migrations[v-1].Description)
+			}
+			if !*yes {
+				fmt.Fprintf(os.Stderr, "Nothing was changed; run again with --yes to revert them\n")
+				os.Exit(1)
+			}
+		}
+
+		if err := catalog.MigrateSchema(repo.RootDir, *migrateTo); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate catalog schema: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Migrated catalog schema from version %d to %d\n", before.Version, *migrateTo)
+
+		if *migrateTo < catalog.LatestSchema() {
+			fmt.Println("Any other command upgrades it again; run it only before handing the repository to an older cas.")
+		}
+		if !*schema {
+			return
+		}
+		fmt.Println()
+	}
+
+	state, err := catalog.ReadSchema(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read catalog schema: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Catalog schema: version %d (this binary: %d)\n", state.Version, state.Latest)
+	for _, m := range state.Migrations {
+		applied := "pending"
+		if !m.Applied.IsZero() {
+			applied = "applied " + m.Applied.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  %3d  %-46s %s\n", m.Version, m.Description, applied)
+	}
+
+	switch {
+	case state.TooNew():
+		fmt.Fprintf(os.Stderr, "\nThe catalog was written by a newer cas; upgrade cas to use this repository.\n")
+		os.Exit(1)
+	case state.Unversioned:
+		fmt.Printf("\nThe catalog predates schema versions; %d migrations will run the next time it is opened.\n", state.Pending())
+	case state.Pending() > 0:
+		fmt.Printf("\n%d migrations will run the next time the catalog is opened.\n", state.Pending())
+	default:
+		fmt.Println("\nThe catalog schema is up to date.")
+	}
+}
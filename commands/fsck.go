@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/fsck"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Fsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+
+	dryRun := fs.Bool("dry-run", false, "Report problems without repairing or quarantining anything")
+	remote := fs.String("remote", "", "CAS server to re-fetch corrupt or missing blobs from (default: remote from config)")
+	authToken := fs.String("auth-token", getEnv("CAS_AUTH_TOKEN", ""), "Bearer token for the remote server")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Repairs move and delete objects; a dry run only reads.
+	lockMode := path.LockExclusive
+	if *dryRun {
+		lockMode = path.LockShared
+	}
+	defer lockRepo(repo, lockMode).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	store.SetHolds(cat)
+
+	opts := fsck.Options{
+		QuarantineDir: filepath.Join(repo.RootDir, fsck.QuarantineDir),
+		DryRun:        *dryRun,
+	}
+
+	if *remote == "" {
+		if cfg, err := path.LoadConfig(repo.RootDir); err == nil {
+			*remote = cfg.Remote
+		}
+	}
+	if *remote != "" {
+		opts.Remote = client.NewHTTPClient(*remote, *authToken)
+	}
+
+	result, err := fsck.Run(context.Background(), store, cat, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fsck failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, p := range result.Problems {
+		status := ""
+		if p.Fixed {
+			status = " [fixed]"
+		}
+		fmt.Printf("%s: %s: %s%s\n", p.Kind, p.Key, p.Detail, status)
+	}
+
+	unfixed := result.Unfixed()
+
+	fmt.Println("Fsck Results:")
+	fmt.Printf("  Objects Checked: %d\n", result.Checked)
+	fmt.Printf("  Problems Found: %d\n", len(result.Problems))
+	fmt.Printf("  Repaired: %d\n", len(result.Problems)-unfixed)
+	if opts.Remote != nil {
+		fmt.Printf("  Re-fetched: %d\n", result.Refetched)
+	}
+
+	if unfixed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/gc"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func GC(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+
+	gracePeriod := fs.Duration("grace-period", gc.DefaultGracePeriod, "Keep unreferenced objects modified more recently than this")
+	dryRun := fs.Bool("dry-run", false, "Report unreferenced objects without deleting them")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockExclusive).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	store.SetHolds(cat)
+
+	result, err := gc.Run(cat, store, gc.Options{GracePeriod: *gracePeriod, DryRun: *dryRun})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Garbage collection failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+
+	for _, info := range result.Orphans {
+		fmt.Printf("%s: %s (%s)\n", verb, info.Key, catalog.FormatSize(uint64(info.Size)))
+	}
+
+	fmt.Println("Garbage Collection Results:")
+	fmt.Printf("  Objects Scanned: %d\n", result.Scanned)
+	fmt.Printf("  Referenced: %d\n", result.Live)
+	fmt.Printf("  Held: %d\n", result.Held)
+	fmt.Printf("  Within Grace Period: %d\n", result.Young)
+	fmt.Printf("  %s: %d\n", verb, len(result.Orphans))
+	fmt.Printf("  Space Reclaimed: %s\n", catalog.FormatSize(uint64(result.ReclaimedBytes)))
+}
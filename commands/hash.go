@@ -1,19 +1,40 @@
 package commands
 
 import (
-	"crypto/sha256"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
 )
 
 func HashFile(args []string) {
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "Usage: ./cas <filename>\n")
+	fs := flag.NewFlagSet("hash", flag.ExitOnError)
+
+	algo := fs.String("algo", "", "Hash algorithm: sha256, sha512-256 or sha1 (default: the repository's, else sha256)")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas hash [--algo name] <filename>\n")
+		os.Exit(1)
+	}
+
+	if *algo == "" {
+		if repo, err := path.Open(""); err == nil {
+			*algo = repo.Config.HashAlgorithm
+		}
+	}
+
+	hasher, err := objects.NewHasher(*algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	fileName := args[0]
+	fileName := fs.Arg(0)
 
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -22,8 +43,7 @@ func HashFile(args []string) {
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
 	io.Copy(hasher, file)
 
-	fmt.Printf("%x\n", hasher.Sum(nil))
+	fmt.Println(objects.FormatDigest(*algo, hasher.Sum(nil)))
 }
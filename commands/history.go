@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+func History(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas history <filepath>\n")
+		os.Exit(1)
+	}
+
+	filePath := args[0]
+
+	c, err := client.NewClientFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	versions, err := c.History(context.Background(), filePath)
+	if err != nil {
+		if errors.Is(err, client.ErrEntryNotFound) {
+			fmt.Fprintf(os.Stderr, "This file doesn't exist in the catalog: %s\n", filePath)
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-8s %-10s %-12s %-17s %s\n", "VERSION", "HASH", "SIZE", "RECORDED", "MODIFIED")
+	fmt.Println("==================================================================================")
+
+	for _, v := range versions {
+		fmt.Printf("%-8d %-10s %-12s %-17s %s\n",
+			v.Version,
+			objects.ShortDigest(v.Hash),
+			catalog.FormatSize(v.Filesize),
+			v.Recorded.Format("2006-01-02 15:04"),
+			v.ModTime.Format("2006-01-02 15:04"),
+		)
+	}
+
+	fmt.Printf("\nTotal versions: %d\n", len(versions))
+}
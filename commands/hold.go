@@ -0,0 +1,184 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Hold(args []string) {
+	fs := flag.NewFlagSet("hold", flag.ExitOnError)
+
+	until := fs.String("until", "", "Retain until this date (2006-01-02 or RFC 3339)")
+	days := fs.Int("days", 0, "Retain for this many days from now")
+	legal := fs.Bool("legal", false, "Place a legal hold, which lasts until released")
+	release := fs.Bool("release", false, "Release the legal hold instead")
+	reason := fs.String("reason", "", "Why the hold is being changed, kept in the hold log")
+
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: cas hold [--until date | --days n] [--legal] [--release] [--reason text] <hash-or-path>...")
+		os.Exit(1)
+	}
+
+	var retainUntil time.Time
+	switch {
+	case *until != "" && *days != 0:
+		fmt.Fprintln(os.Stderr, "Use either --until or --days")
+		os.Exit(1)
+	case *until != "":
+		t, err := parseHoldDate(*until)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --until: %v\n", err)
+			os.Exit(1)
+		}
+		retainUntil = t
+	case *days != 0:
+		retainUntil = time.Now().AddDate(0, 0, *days)
+	}
+
+	if *release && (*legal || !retainUntil.IsZero()) {
+		fmt.Fprintln(os.Stderr, "--release cannot be combined with placing a hold")
+		os.Exit(1)
+	}
+	if !*release && !*legal && retainUntil.IsZero() {
+		fmt.Fprintln(os.Stderr, "Specify --until, --days, --legal or --release")
+		os.Exit(1)
+	}
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockShared).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	failed := false
+	for _, arg := range fs.Args() {
+		targetType, label := catalog.HoldPath, arg
+		if objects.ValidDigest(arg) {
+			targetType, label = catalog.HoldBlob, objects.ShortDigest(arg)
+
+			if exists, err := store.Exists(arg); err != nil || !exists {
+				fmt.Fprintf(os.Stderr, "%s: blob not found\n", arg)
+				failed = true
+				continue
+			}
+		}
+
+		if err := applyHold(cat, targetType, arg, retainUntil, *legal, *release, *reason); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			failed = true
+			continue
+		}
+
+		switch {
+		case *release:
+			fmt.Printf("Released legal hold on %s\n", label)
+		case *legal && !retainUntil.IsZero():
+			fmt.Printf("Held %s until %s and under legal hold\n", label, retainUntil.Format(time.RFC3339))
+		case *legal:
+			fmt.Printf("Placed legal hold on %s\n", label)
+		default:
+			fmt.Printf("Held %s until %s\n", label, retainUntil.Format(time.RFC3339))
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func applyHold(cat *catalog.Catalog, targetType, target string, until time.Time, legal, release bool, reason string) error {
+	if release {
+		return cat.SetLegalHold(targetType, target, false, reason)
+	}
+
+	return cat.PlaceHold(targetType, target, until, legal, reason)
+}
+
+func parseHoldDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func Holds(args []string) {
+	fs := flag.NewFlagSet("holds", flag.ExitOnError)
+
+	log := fs.Bool("log", false, "Show every recorded hold change instead")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	if *log {
+		events, err := cat.HoldEvents()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read hold log: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, event := range events {
+			line := fmt.Sprintf("%s  %-13s %s %s", event.Time.Format(time.RFC3339), event.Action, event.TargetType, event.Target)
+			if !event.RetainUntil.IsZero() {
+				line += " until " + event.RetainUntil.Format(time.RFC3339)
+			}
+			if event.Reason != "" {
+				line += fmt.Sprintf(" (%s)", event.Reason)
+			}
+			fmt.Println(line)
+		}
+		return
+	}
+
+	holds, err := cat.Holds()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list holds: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(holds) == 0 {
+		fmt.Println("No holds in force")
+		return
+	}
+
+	now := time.Now()
+	for _, hold := range holds {
+		var kinds []string
+		if hold.RetainUntil.After(now) {
+			kinds = append(kinds, "retained until "+hold.RetainUntil.Format(time.RFC3339))
+		}
+		if hold.Legal {
+			kinds = append(kinds, "legal hold")
+		}
+		fmt.Printf("%s %s: %s\n", hold.TargetType, hold.Target, strings.Join(kinds, ", "))
+	}
+}
@@ -1,18 +1,188 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
-func Init() {
-	repo, err := path.Init("")
+func Init(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+
+	backend := fs.String("backend", "fs", "Storage backend: fs, erasure or s3")
+	storageDir := fs.String("storage-dir", "", "Blob directory for the fs backend (default .cas/storage)")
+	s3Endpoint := fs.String("s3-endpoint", "", "S3-compatible endpoint URL")
+	s3Bucket := fs.String("s3-bucket", "", "S3 bucket name")
+	s3Region := fs.String("s3-region", "", "S3 region (default us-east-1)")
+	s3Prefix := fs.String("s3-prefix", "", "Key prefix for objects in the bucket")
+	roots := fs.String("roots", "", "Comma-separated directories, one per disk, for the erasure backend")
+	parity := fs.Int("parity", 1, "How many of the erasure roots hold parity, i.e. how many disks may fail")
+	compression := fs.String("compression", "none", "Compress blobs at rest: none, gzip or flate")
+	chunking := fs.Bool("chunking", false, "Split blobs into content-defined chunks")
+	chunkSize := fs.Int("chunk-size", 0, "Average chunk size in bytes, a power of two (default 1 MiB)")
+	hashAlgo := fs.String("hash", objects.DefaultAlgorithm, "Hash algorithm for new blobs: sha256, sha512-256 or sha1")
+	encrypt := fs.Bool("encrypt", false, "Encrypt blobs at rest with a key from "+storage.EncryptionKeyEnv+" or a generated .cas/keyfile")
+	maxSize := fs.String("max-size", "", "Size limit for a cache repository, e.g. 512M or 20G; unreferenced blobs are evicted LRU-first")
+	coldDir := fs.String("cold-dir", "", "Slower directory that blobs not read recently are demoted to (fs backend only)")
+	demoteAfter := fs.Int("demote-after", 0, "Days without a read before cas tier demotes a blob (default 30)")
+	inlineThreshold := fs.String("inline-threshold", "", "Keep blobs smaller than this, e.g. 512 or 4K, inside the catalog database")
+	remote := fs.String("remote", "", "CAS server that fsck re-fetches damaged blobs from")
+	maxVersions := fs.Int("max-versions", 0, "How many versions of each catalog path to keep (default all)")
+	durability := fs.String("durability", storage.DurabilityFull, "Crash safety of fs writes: none, file (fsync objects) or full (fsync objects and directories)")
+
+	fs.Parse(args)
+
+	cfg := path.DefaultConfig()
+	cfg.HashAlgorithm = *hashAlgo
+	cfg.Remote = *remote
+	cfg.MaxVersions = *maxVersions
+	cfg.Storage.Backend = *backend
+	cfg.Storage.Dir = *storageDir
+	cfg.Storage.S3 = path.S3Config{
+		Endpoint: *s3Endpoint,
+		Bucket:   *s3Bucket,
+		Region:   *s3Region,
+		Prefix:   *s3Prefix,
+	}
+
+	// A memory store lives only as long as one command, so every blob
+	// recorded in the catalog would be gone by the next one.
+	if cfg.Storage.Backend == "memory" {
+		fmt.Fprintf(os.Stderr, "The memory backend does not persist between commands; it is only for tests and library use\n")
+		os.Exit(1)
+	}
+
+	if cfg.Storage.Backend == "erasure" {
+		for _, root := range strings.Split(*roots, ",") {
+			if root = strings.TrimSpace(root); root != "" {
+				cfg.Storage.Roots = append(cfg.Storage.Roots, root)
+			}
+		}
+		cfg.Storage.ParityShards = *parity
+
+		if len(cfg.Storage.Roots) <= cfg.Storage.ParityShards || cfg.Storage.ParityShards < 1 {
+			fmt.Fprintf(os.Stderr, "The erasure backend needs --roots with more directories than --parity, and --parity of at least 1\n")
+			os.Exit(1)
+		}
+	}
+
+	cfg.Storage.Compression = *compression
+	cfg.Storage.Chunking = *chunking
+	cfg.Storage.ChunkAvgSize = *chunkSize
+	cfg.Storage.Durability = *durability
+	cfg.Storage.ColdDir = *coldDir
+	cfg.Storage.DemoteAfter = *demoteAfter
+
+	if *maxSize != "" {
+		size, err := parseSize(*maxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --max-size: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Storage.MaxSize = size
+	}
+
+	if *inlineThreshold != "" {
+		size, err := parseSize(*inlineThreshold)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --inline-threshold: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Storage.InlineThreshold = size
+	}
+
+	if !storage.ValidCompression(cfg.Storage.Compression) {
+		fmt.Fprintf(os.Stderr, "Unknown compression: %s\n", cfg.Storage.Compression)
+		os.Exit(1)
+	}
+
+	if !objects.ValidAlgorithm(cfg.HashAlgorithm) {
+		fmt.Fprintf(os.Stderr, "Unknown hash algorithm: %s\n", cfg.HashAlgorithm)
+		os.Exit(1)
+	}
+
+	if cfg.Storage.ColdDir != "" && cfg.Storage.Backend != "fs" {
+		fmt.Fprintf(os.Stderr, "--cold-dir requires the fs backend\n")
+		os.Exit(1)
+	}
+
+	if !storage.ValidDurability(cfg.Storage.Durability) {
+		fmt.Fprintf(os.Stderr, "Unknown durability: %s\n", cfg.Storage.Durability)
+		os.Exit(1)
+	}
+
+	if cfg.MaxVersions < 0 {
+		fmt.Fprintf(os.Stderr, "--max-versions cannot be negative\n")
+		os.Exit(1)
+	}
+
+	var key []byte
+	generatedKey := false
+
+	if *encrypt {
+		var err error
+		if env := os.Getenv(storage.EncryptionKeyEnv); env != "" {
+			key, err = storage.ParseKey(env)
+		} else {
+			key, err = storage.GenerateKey()
+			generatedKey = true
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set up encryption: %v\n", err)
+			os.Exit(1)
+		}
+
+		cfg.Storage.Encryption = true
+		cfg.Storage.KeyFile = storage.DefaultKeyFile
+		cfg.Storage.KeyID = storage.KeyID(key)
+	}
+
+	repo, err := path.InitWithConfig("", cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to initialize CAS: %v\n", err)
 		os.Exit(1)
 	}
 
+	if generatedKey {
+		keyFile := filepath.Join(repo.RootDir, cfg.Storage.KeyFile)
+		if err := storage.WriteKeyFile(keyFile, key); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save encryption key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generated encryption key in %s; back it up, blobs cannot be read without it\n", keyFile)
+	}
+
 	fmt.Printf("Initialized empty CAS in %s\n", repo.RootDir)
 }
+
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a positive size such as 512M, got %q", s)
+	}
+
+	return n * multiplier, nil
+}
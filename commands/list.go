@@ -37,7 +37,15 @@ func List() {
 		sizeStr := catalog.FormatSize(entry.Filesize)
 		modTime := entry.ModTime.Format("2006-01-02 15:04")
 
-		fmt.Printf("%-50s %-10s %-12s  %s\n", entry.Filepath, hashShort, sizeStr, modTime)
+		name := entry.Filepath
+		switch entry.Type {
+		case catalog.TypeDir:
+			name += "/"
+		case catalog.TypeSymlink:
+			name += " -> " + entry.Target
+		}
+
+		fmt.Printf("%-50s %-10s %-12s  %s\n", name, hashShort, sizeStr, modTime)
 	}
 
 	fmt.Printf("\nTotal files: %d\n", len(entries))
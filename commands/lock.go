@@ -0,0 +1,20 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+)
+
+// lockRepo takes the repository lock, exiting if it is not released within
+// the configured timeout. A command that exits while holding it leaves
+// nothing behind: the lock dies with the process.
+func lockRepo(repo *path.Repository, mode path.LockMode) *path.Lock {
+	lock, err := repo.Lock(mode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to lock repository: %v\n", err)
+		os.Exit(1)
+	}
+	return lock
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Log() {
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	history, err := snapshot.Log(cat, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No snapshots committed")
+		return
+	}
+
+	for i, snap := range history {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("snapshot %s\n", snap.Hash)
+		fmt.Printf("Author: %s\n", snap.Author)
+		fmt.Printf("Date:   %s\n", snap.Time.Local().Format(time.RFC1123Z))
+		fmt.Printf("Files:  %d\n", len(snap.Entries))
+		fmt.Printf("\n    %s\n", snap.Message)
+	}
+}
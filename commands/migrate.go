@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/migrate"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+)
+
+func Migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+
+	shardDepth := fs.Int("shard-depth", 0, fmt.Sprintf("Move objects to this many shard directory levels (1-%d)", path.MaxShardDepth))
+	compression := fs.String("compression", "", "Rewrite stored objects with this compression: none, gzip or flate")
+	pack := fs.Bool("pack", false, "Move every loose object into a pack")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockExclusive).Unlock()
+
+	opts := migrate.Options{ShardDepth: *shardDepth, Compression: *compression, Pack: *pack}
+
+	result, err := migrate.Run(repo.RootDir, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		fmt.Fprintln(os.Stderr, "The repository remains readable; run cas migrate again to resume.")
+		os.Exit(1)
+	}
+
+	if !result.Resumed && result.Moved == 0 && result.Reencoded == 0 && result.Packed == 0 && result.Format.Migration == nil {
+		fmt.Printf("Repository format %d, shard depth %d: nothing to migrate\n", result.Format.Version, result.Format.ShardDepth)
+		return
+	}
+
+	for _, failure := range result.Failed {
+		fmt.Printf("Failed: %s\n", failure)
+	}
+
+	fmt.Println("Migration Results:")
+	if result.Resumed {
+		fmt.Println("  Resumed: yes")
+	}
+	fmt.Printf("  Objects Moved: %d\n", result.Moved)
+	fmt.Printf("  Objects Re-encoded: %d\n", result.Reencoded)
+	fmt.Printf("  Objects Packed: %d\n", result.Packed)
+	fmt.Printf("  Shard Depth: %d\n", result.Format.ShardDepth)
+
+	if result.Format.Migration != nil {
+		fmt.Printf("  %d objects could not be migrated; run cas fsck, then cas migrate to finish\n", len(result.Failed))
+		os.Exit(1)
+	}
+}
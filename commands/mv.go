@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+)
+
+func Move(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas mv <from> <to>\n")
+		os.Exit(1)
+	}
+
+	from, to := args[0], args[1]
+
+	c, err := client.NewClientFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	n, err := c.RenameEntry(context.Background(), from, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, client.ErrEntryNotFound):
+			fmt.Fprintf(os.Stderr, "This file doesn't exist in the catalog: %s\n", from)
+		case errors.Is(err, client.ErrEntryExists):
+			fmt.Fprintf(os.Stderr, "Refusing to overwrite: %v\n", err)
+		default:
+			fmt.Fprintf(os.Stderr, "Failed to move %s: %v\n", from, err)
+		}
+		os.Exit(1)
+	}
+
+	if n == 1 {
+		fmt.Printf("Moved %s -> %s\n", from, to)
+	} else {
+		fmt.Printf("Moved %s -> %s (%d files)\n", from, to, n)
+	}
+}
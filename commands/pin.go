@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Pin(args []string) {
+	fs := flag.NewFlagSet("pin", flag.ExitOnError)
+
+	remove := fs.Bool("remove", false, "Unpin the given blobs instead")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockShared).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	if fs.NArg() == 0 {
+		pinned, err := store.Pinned()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list pins: %v\n", err)
+			os.Exit(1)
+		}
+		for _, hash := range pinned {
+			fmt.Println(hash)
+		}
+		return
+	}
+
+	cat := catalog.NewCatalog(repo.RootDir)
+	defer cat.Close()
+
+	failed := false
+	for _, arg := range fs.Args() {
+		hash := arg
+		if !objects.ValidDigest(arg) {
+			entry, err := cat.GetEntry(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: not a hash or tracked path\n", arg)
+				failed = true
+				continue
+			}
+			hash = entry.Hash
+		}
+
+		if *remove {
+			err = store.Unpin(hash)
+		} else {
+			err = store.Pin(hash)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			failed = true
+			continue
+		}
+
+		if *remove {
+			fmt.Printf("Unpinned %s\n", objects.ShortDigest(hash))
+		} else {
+			fmt.Printf("Pinned %s\n", objects.ShortDigest(hash))
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
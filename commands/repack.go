@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Repack(args []string) {
+	fs := flag.NewFlagSet("repack", flag.ExitOnError)
+
+	maxSize := fs.Int64("max-size", storage.DefaultPackMaxObjectSize, "Largest loose object, in bytes, to move into a pack")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockExclusive).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	result, err := store.Repack(*maxSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Repack failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if result.PackedObjects == 0 && result.RemovedPacks == 0 {
+		fmt.Println("Nothing to repack")
+		return
+	}
+
+	fmt.Println("Repack Results:")
+	fmt.Printf("  Objects Packed: %d\n", result.PackedObjects)
+	fmt.Printf("  Loose Objects Removed: %d\n", result.RemovedObjects)
+	fmt.Printf("  Old Packs Removed: %d\n", result.RemovedPacks)
+}
@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+)
+
+func Remove(args []string) {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+
+	recursive := fs.Bool("r", false, "Remove every entry under a directory")
+
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: ./cas rm [-r] <filepath>...\n")
+		os.Exit(1)
+	}
+
+	c, err := client.NewClientFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	failed := false
+	for _, filePath := range fs.Args() {
+		n, err := c.DeleteEntry(ctx, filePath, *recursive)
+		if err != nil {
+			switch {
+			case errors.Is(err, client.ErrEntryNotFound):
+				fmt.Fprintf(os.Stderr, "This file doesn't exist in the catalog: %s\n", filePath)
+			case errors.Is(err, client.ErrIsDirectory):
+				fmt.Fprintf(os.Stderr, "%s is a directory; use -r to remove it\n", filePath)
+			default:
+				fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", filePath, err)
+			}
+			failed = true
+			continue
+		}
+
+		if n == 1 {
+			fmt.Printf("Removed %s\n", filePath)
+		} else {
+			fmt.Printf("Removed %s (%d files)\n", filePath, n)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
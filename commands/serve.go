@@ -23,6 +23,7 @@ func Serve(args []string) {
 	corsOrigins := fs.String("cors-origins", getEnv("CAS_CORS_ORIGINS", "*"), "Comma-seperated CORS origins")
 	tlsCert := fs.String("tls-cert", getEnv("CAS_TLS_CERT", ""), "Path to TLS certificate file")
 	tlsKey := fs.String("tls-key", getEnv("CAS_TLS_KEY", ""), "Path to TLS private key file")
+	tierInterval := fs.Duration("tier-interval", 0, "Demote idle blobs to the cold tier this often, e.g. 1h (default: off)")
 
 	fs.Parse(args)
 
@@ -36,6 +37,7 @@ func Serve(args []string) {
 		RepoPath:     ".",
 		TLSCert:      *tlsCert,
 		TLSKey:       *tlsKey,
+		TierInterval: *tierInterval,
 	}
 
 	srv, err := server.NewServer(config)
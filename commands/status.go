@@ -2,11 +2,13 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
 	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
 func Status() {
@@ -17,46 +19,59 @@ func Status() {
 	}
 	defer c.Close()
 
-	entries, err := c.GetCatalog(context.Background())
+	ctx := context.Background()
+
+	stats, err := c.Stats(ctx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "No files tracked in catalog\n")
+		fmt.Fprintf(os.Stderr, "Failed to read catalog stats: %v\n", err)
 		os.Exit(1)
 	}
 
-	totalEntries := len(entries)
-
-	uniqueHashes := make(map[string]bool)
-	totalSize := uint64(0)
-
-	for _, entry := range entries {
-		uniqueHashes[entry.Hash] = true
-		totalSize += entry.Filesize
-	}
-
-	uniqueBlobs := len(uniqueHashes)
-
-	actualStorage := uint64(0)
-	seenHashes := make(map[string]bool)
-
-	for _, entry := range entries {
-		if !seenHashes[entry.Hash] {
-			actualStorage += entry.Filesize
-			seenHashes[entry.Hash] = true
-		}
+	if stats.Files == 0 {
+		fmt.Fprintf(os.Stderr, "No files tracked in catalog\n")
+		os.Exit(1)
 	}
 
-	spaceSaved := totalSize - actualStorage
+	spaceSaved := stats.TotalSize - stats.UniqueSize
 	percentageSaved := 0.0
-	if totalSize > 0 {
-		percentageSaved = float64(spaceSaved) / float64(totalSize) * 100
+	if stats.TotalSize > 0 {
+		percentageSaved = float64(spaceSaved) / float64(stats.TotalSize) * 100
 	}
 
 	fmt.Println("Repository Statistics:")
 	fmt.Println("======================")
-	fmt.Printf("Files Tracked: %d\n", totalEntries)
-	fmt.Printf("Unique Blobs: %d\n", uniqueBlobs)
-	fmt.Printf("Total File Size: %s\n", catalog.FormatSize(totalSize))
-	fmt.Printf("Actual Storage: %s\n", catalog.FormatSize(actualStorage))
+	fmt.Printf("Files Tracked: %d\n", stats.Files)
+	fmt.Printf("Unique Blobs: %d\n", stats.UniqueBlobs)
+	fmt.Printf("Total File Size: %s\n", catalog.FormatSize(stats.TotalSize))
+	fmt.Printf("Actual Storage: %s\n", catalog.FormatSize(stats.UniqueSize))
+
+	local, isLocal := c.(*client.LocalClient)
+	if isLocal {
+		if onDisk, err := local.StoredSize(ctx); err == nil {
+			fmt.Printf("On-Disk Storage: %s\n", catalog.FormatSize(uint64(onDisk)))
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to read on-disk size: %v\n", err)
+		}
+	}
+
 	fmt.Printf("Space Saved: %s (%.1f%%)\n", catalog.FormatSize(spaceSaved), percentageSaved)
 
+	if isLocal {
+		usage, err := local.CacheUsage()
+		if err == nil {
+			percentageUsed := float64(usage.Used) / float64(usage.MaxSize) * 100
+			fmt.Printf("Cache Quota: %s / %s (%.1f%%)\n", catalog.FormatSize(uint64(usage.Used)), catalog.FormatSize(uint64(usage.MaxSize)), percentageUsed)
+			fmt.Printf("Cached Objects: %d\n", usage.Objects)
+			fmt.Printf("Pinned Blobs: %d (%s)\n", usage.Pinned, catalog.FormatSize(uint64(usage.PinnedSize)))
+		} else if !errors.Is(err, storage.ErrNoQuota) {
+			fmt.Fprintf(os.Stderr, "Failed to read cache usage: %v\n", err)
+		}
+
+		inline, err := local.InlineUsage()
+		if err == nil {
+			fmt.Printf("Inlined Objects: %d (%s, below %s)\n", inline.Objects, catalog.FormatSize(uint64(inline.Bytes)), catalog.FormatSize(uint64(inline.Threshold)))
+		} else if !errors.Is(err, storage.ErrNoInlining) {
+			fmt.Fprintf(os.Stderr, "Failed to read inline usage: %v\n", err)
+		}
+	}
 }
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func Tier(args []string) {
+	fs := flag.NewFlagSet("tier", flag.ExitOnError)
+
+	days := fs.Int("days", 0, "Demote blobs not read for this many days (default: demote_after_days from config, or 30)")
+	dryRun := fs.Bool("dry-run", false, "Report blobs that would be demoted without moving them")
+
+	fs.Parse(args)
+
+	repo, err := path.Open("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer lockRepo(repo, path.LockShared).Unlock()
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	idle := store.DemoteAfter()
+	if *days > 0 {
+		idle = time.Duration(*days) * 24 * time.Hour
+	}
+
+	result, err := store.DemoteIdle(idle, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Tiering failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	verb := "Demoted"
+	if *dryRun {
+		verb = "Would demote"
+	}
+
+	for _, hash := range result.Demoted {
+		fmt.Printf("%s: %s\n", verb, hash)
+	}
+
+	fmt.Println("Tiering Results:")
+	fmt.Printf("  %s: %d (%s)\n", verb, len(result.Demoted), catalog.FormatSize(uint64(result.DemotedBytes)))
+	fmt.Printf("  Hot Objects: %d\n", result.Hot)
+	fmt.Printf("  Cold Objects: %d\n", result.Cold)
+}
@@ -2,13 +2,13 @@ package commands
 
 import (
 	"context"
-	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 )
 
 func Verify() {
@@ -45,7 +45,21 @@ func Verify() {
 			continue
 		}
 
-		hasher := sha256.New()
+		algo, _, err := objects.ParseDigest(entry.Hash)
+		if err != nil {
+			fmt.Printf("ERROR: %s - %v\n", entry.Filepath, err)
+			reader.Close()
+			corrupted++
+			continue
+		}
+
+		hasher, err := objects.NewHasher(algo)
+		if err != nil {
+			fmt.Printf("ERROR: %s - %v\n", entry.Filepath, err)
+			reader.Close()
+			corrupted++
+			continue
+		}
 
 		if _, err := io.Copy(hasher, reader); err != nil {
 			fmt.Printf("ERROR: %s - failed to read %v\n", entry.Filepath, err)
@@ -55,12 +69,12 @@ func Verify() {
 		}
 		reader.Close()
 
-		computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
+		computedHash := objects.FormatDigest(algo, hasher.Sum(nil))
 
-		if computedHash != entry.Hash {
+		if computedHash != objects.CanonicalDigest(entry.Hash) {
 			fmt.Printf("CORRUPT: %s\n", entry.Filepath)
-			fmt.Printf(" Expected: %s\n", entry.Hash[:8])
-			fmt.Printf(" Got:      %s\n", entry.Hash[:8])
+			fmt.Printf(" Expected: %s\n", objects.ShortDigest(entry.Hash))
+			fmt.Printf(" Got:      %s\n", objects.ShortDigest(computedHash))
 			corrupted++
 		} else {
 			fmt.Printf("OK: %s\n", entry.Filepath)
@@ -6,21 +6,118 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 	_ "modernc.org/sqlite"
 )
 
+// Entry types. Entries recorded before types existed are files.
+const (
+	TypeFile    = "file"
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+)
+
+// Entry is one tracked path. Every entry points at a blob: a file's content,
+// a symlink's target, or the empty blob for a directory.
 type Entry struct {
 	Filepath string    `json:"filepath"`
 	Hash     string    `json:"hash"`
 	Filesize uint64    `json:"file_size"`
 	ModTime  time.Time `json:"modification_time"`
+	// Mode holds the permission, setuid, setgid and sticky bits as in a
+	// Unix mode; zero means they were not recorded.
+	Mode   uint32 `json:"mode,omitempty"`
+	Type   string `json:"type,omitempty"`
+	UID    uint32 `json:"uid,omitempty"`
+	GID    uint32 `json:"gid,omitempty"`
+	Target string `json:"target,omitempty"`
+	// Xattrs holds extended attributes by name.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+}
+
+// entryColumns are the entries columns that make up an Entry, in the order
+// scanEntry reads them.
+const entryColumns = "filepath, hash, filesize, modtime, mode, type, uid, gid, target, xattrs"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanEntry reads entryColumns, followed by any extra columns into extra.
+func scanEntry(row scanner, extra ...any) (Entry, error) {
+	var entry Entry
+	var modtime int64
+	var xattrs string
+
+	dest := []any{&entry.Filepath, &entry.Hash, &entry.Filesize, &modtime, &entry.Mode, &entry.Type, &entry.UID, &entry.GID, &entry.Target, &xattrs}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Entry{}, err
+	}
+
+	entry.ModTime = time.Unix(0, modtime)
+	if xattrs != "" {
+		if err := json.Unmarshal([]byte(xattrs), &entry.Xattrs); err != nil {
+			return Entry{}, fmt.Errorf("failed to decode xattrs of %s: %w", entry.Filepath, err)
+		}
+	}
+	return entry, nil
+}
+
+// entryValues are the values of entryColumns for entry, with hash in its
+// canonical form.
+func entryValues(entry Entry, hash string) ([]any, error) {
+	var xattrs string
+	if len(entry.Xattrs) > 0 {
+		data, err := json.Marshal(entry.Xattrs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode xattrs: %w", err)
+		}
+		xattrs = string(data)
+	}
+
+	entryType := entry.Type
+	if entryType == "" {
+		entryType = TypeFile
+	}
+
+	return []any{entry.Filepath, hash, entry.Filesize, entry.ModTime.UnixNano(), entry.Mode, entryType, entry.UID, entry.GID, entry.Target, xattrs}, nil
+}
+
+func validType(entryType string) bool {
+	switch entryType {
+	case "", TypeFile, TypeDir, TypeSymlink:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stats summarizes the catalog from the blobs table, without reading every
+// entry. Files counts regular files only, not directories or symlinks.
+type Stats struct {
+	Files       int    `json:"total_files"`
+	UniqueBlobs int    `json:"unique_blobs"`
+	TotalSize   uint64 `json:"total_size"`
+	UniqueSize  uint64 `json:"unique_size"`
+}
+
+// Blob is a catalog-referenced blob with the number of entries pointing at it.
+type Blob struct {
+	Hash      string
+	Refcount  int
+	Size      uint64
+	FirstSeen time.Time
 }
 
 type Catalog struct {
-	db     *sql.DB
-	casDir string
+	db          *sql.DB
+	casDir      string
+	hooks       *hooks.Hooks
+	maxVersions int
 }
 
 func NewCatalog(casDir string) *Catalog {
@@ -29,16 +126,50 @@ func NewCatalog(casDir string) *Catalog {
 	}
 }
 
+// SetHooks sends entry events to h.
+func (c *Catalog) SetHooks(h *hooks.Hooks) {
+	c.hooks = h
+}
+
 func (c *Catalog) init() error {
 	if c.db != nil {
 		return nil
 	}
 
-	dbPath := filepath.Join(c.casDir, "catalog.db")
+	db, err := OpenDatabase(c.casDir)
+	if err != nil {
+		return err
+	}
+
+	c.db = db
+	return nil
+}
 
-	db, err := sql.Open("sqlite", dbPath)
+// OpenDatabase opens catalog.db in casDir at the latest schema, for packages
+// that keep their own tables in it, such as the inline object store. Those
+// tables are created by the catalog's migrations like any other.
+func OpenDatabase(casDir string) (*sql.DB, error) {
+	db, err := openDB(casDir)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, err
+	}
+
+	if err := upgrade(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	return db, nil
+}
+
+func openDB(casDir string) (*sql.DB, error) {
+	dbPath := filepath.Join(casDir, "catalog.db")
+
+	// Writes read the entry they replace before updating refcounts, so take
+	// the write lock when the transaction begins rather than on first write.
+	db, err := sql.Open("sqlite", dbPath+"?_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	pragmas := []string{
@@ -51,23 +182,7 @@ func (c *Catalog) init() error {
 		db.Exec(p)
 	}
 
-	schema := `
-			CREATE TABLE IF NOT EXISTS entries (
-					filepath TEXT PRIMARY KEY NOT NULL,
-					hash TEXT NOT NULL,
-					filesize INTEGER NOT NULL,
-					modtime INTEGER NOT NULL
-			);
-			CREATE INDEX IF NOT EXISTS idx_hash ON entries(hash);
-	`
-
-	if _, err := db.Exec(schema); err != nil {
-		db.Close()
-		return fmt.Errorf("failed to create schema: %w", err)
-	}
-
-	c.db = db
-	return nil
+	return db, nil
 }
 
 func (c *Catalog) AddEntry(entry Entry) error {
@@ -75,17 +190,134 @@ func (c *Catalog) AddEntry(entry Entry) error {
 		return err
 	}
 
+	if !validType(entry.Type) {
+		return fmt.Errorf("unknown entry type: %s", entry.Type)
+	}
+
 	query := `
-			INSERT INTO entries (filepath, hash, filesize, modTime)
-			VALUES (?, ?, ?, ?)
+			INSERT INTO entries (` + entryColumns + `)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON CONFLICT(filepath) DO UPDATE SET
 					hash = excluded.hash,
 					filesize = excluded.filesize,
-					modTime = excluded.modTime
+					modTime = excluded.modTime,
+					mode = excluded.mode,
+					type = excluded.type,
+					uid = excluded.uid,
+					gid = excluded.gid,
+					target = excluded.target,
+					xattrs = excluded.xattrs
 	`
 
-	_, err := c.db.Exec(query, entry.Filepath, entry.Hash, entry.Filesize, entry.ModTime.UnixNano())
-	return err
+	hash := objects.CanonicalDigest(entry.Hash)
+
+	values, err := entryValues(entry, hash)
+	if err != nil {
+		return err
+	}
+
+	// Pre hooks run before the transaction so that a slow one does not
+	// hold the database's write lock.
+	event := hooks.Event{Kind: hooks.EntryAdded, Hash: hash, Size: int64(entry.Filesize), Path: entry.Filepath}
+	if err := c.db.QueryRow("SELECT hash FROM entries WHERE filepath = ?", entry.Filepath).Scan(&event.PreviousHash); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if event.PreviousHash != "" {
+		event.Kind = hooks.EntryReplaced
+	}
+	if err := c.hooks.Pre(event); err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+	var changed bool
+	current, err := scanEntry(tx.QueryRow("SELECT "+entryColumns+" FROM entries WHERE filepath = ?", entry.Filepath))
+	switch {
+	case err == sql.ErrNoRows:
+		changed = true
+	case err != nil:
+		return err
+	default:
+		previous = current.Hash
+		// Metadata is part of a version too, so a chmod or a touch is
+		// recorded even though the content stayed the same.
+		currentValues, err := entryValues(current, previous)
+		if err != nil {
+			return err
+		}
+		changed = !slices.Equal(currentValues, values)
+	}
+
+	// Pointing a held path at other content would orphan what it holds.
+	if previous != "" && previous != hash {
+		if err := checkPathHold(tx, entry.Filepath); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(query, values...); err != nil {
+		return err
+	}
+
+	if previous != hash {
+		if previous != "" {
+			if err := release(tx, previous); err != nil {
+				return err
+			}
+		}
+		if err := retain(tx, hash, entry.Filesize); err != nil {
+			return err
+		}
+	}
+	if changed {
+		if err := recordVersion(tx, entry, hash, c.maxVersions); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	event.PreviousHash = previous
+	event.Kind = hooks.EntryAdded
+	if previous != "" {
+		event.Kind = hooks.EntryReplaced
+	}
+	c.hooks.Post(event)
+
+	return nil
+}
+
+// retain counts one more entry referencing hash.
+func retain(tx *sql.Tx, hash string, size uint64) error {
+	_, err := tx.Exec(`
+			INSERT INTO blobs (hash, refcount, size, first_seen)
+			VALUES (?, 1, ?, ?)
+			ON CONFLICT(hash) DO UPDATE SET refcount = refcount + 1
+	`, hash, size, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to update refcount: %w", err)
+	}
+	return nil
+}
+
+// release drops one reference to hash, forgetting the blob once nothing
+// refers to it.
+func release(tx *sql.Tx, hash string) error {
+	if _, err := tx.Exec("UPDATE blobs SET refcount = refcount - 1 WHERE hash = ?", hash); err != nil {
+		return fmt.Errorf("failed to update refcount: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM blobs WHERE hash = ? AND refcount <= 0", hash); err != nil {
+		return fmt.Errorf("failed to update refcount: %w", err)
+	}
+	return nil
 }
 
 func (c *Catalog) GetEntry(path string) (Entry, error) {
@@ -93,23 +325,16 @@ func (c *Catalog) GetEntry(path string) (Entry, error) {
 		return Entry{}, err
 	}
 
-	var entry Entry
-	var modtime int64
-
-	err := c.db.QueryRow(
-		"SELECT filepath, hash, filesize, modtime FROM entries WHERE filepath = ?",
-		path,
-	).Scan(&entry.Filepath, &entry.Hash, &entry.Filesize, &modtime)
+	entry, err := scanEntry(c.db.QueryRow("SELECT "+entryColumns+" FROM entries WHERE filepath = ?", path))
 
 	if err == sql.ErrNoRows {
-		return Entry{}, fmt.Errorf("path not found in catalog: %s", path)
+		return Entry{}, fmt.Errorf("%w: %s", ErrEntryNotFound, path)
 	}
 
 	if err != nil {
 		return Entry{}, err
 	}
 
-	entry.ModTime = time.Unix(0, modtime)
 	return entry, nil
 }
 
@@ -118,7 +343,7 @@ func (c *Catalog) ListEntries() ([]Entry, error) {
 		return nil, err
 	}
 
-	rows, err := c.db.Query("SELECT filepath, hash, filesize, modtime FROM entries ORDER BY filepath")
+	rows, err := c.db.Query("SELECT " + entryColumns + " FROM entries ORDER BY filepath")
 	if err != nil {
 		return nil, err
 	}
@@ -126,20 +351,102 @@ func (c *Catalog) ListEntries() ([]Entry, error) {
 
 	var entries []Entry
 	for rows.Next() {
-		var entry Entry
-		var modtime int64
-
-		if err := rows.Scan(&entry.Filepath, &entry.Hash, &entry.Filesize, &modtime); err != nil {
+		entry, err := scanEntry(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		entry.ModTime = time.Unix(0, modtime)
 		entries = append(entries, entry)
 	}
 
 	return entries, rows.Err()
 }
 
+func (c *Catalog) HasHash(hash string) (bool, error) {
+	if err := c.init(); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err := c.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM blobs WHERE hash = ?1) OR EXISTS(SELECT 1 FROM entry_versions WHERE hash = ?1)",
+		objects.CanonicalDigest(hash),
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Hashes lists every blob the catalog references, whether from a current
+// entry or a kept older version.
+func (c *Catalog) Hashes() ([]string, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query("SELECT hash FROM blobs UNION SELECT hash FROM entry_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
+
+// Blob returns the refcount record of hash, or sql.ErrNoRows if no entry
+// references it.
+func (c *Catalog) Blob(hash string) (Blob, error) {
+	if err := c.init(); err != nil {
+		return Blob{}, err
+	}
+
+	blob := Blob{Hash: objects.CanonicalDigest(hash)}
+	var firstSeen int64
+
+	err := c.db.QueryRow(
+		"SELECT refcount, size, first_seen FROM blobs WHERE hash = ?",
+		blob.Hash,
+	).Scan(&blob.Refcount, &blob.Size, &firstSeen)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	blob.FirstSeen = time.Unix(0, firstSeen)
+	return blob, nil
+}
+
+func (c *Catalog) Stats() (Stats, error) {
+	if err := c.init(); err != nil {
+		return Stats{}, err
+	}
+
+	var stats Stats
+	err := c.db.QueryRow(`
+			SELECT COUNT(*), COALESCE(SUM(size * refcount), 0), COALESCE(SUM(size), 0)
+			FROM blobs
+	`).Scan(&stats.UniqueBlobs, &stats.TotalSize, &stats.UniqueSize)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	err = c.db.QueryRow("SELECT COUNT(*) FROM entries WHERE type = ?", TypeFile).Scan(&stats.Files)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return stats, nil
+}
+
 func (c *Catalog) Save() error {
 	return c.init()
 }
@@ -2,9 +2,14 @@ package catalog
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
 )
 
 func TestAddEntry_GetEntry(t *testing.T) {
@@ -233,3 +238,601 @@ func TestWriteTo_ReadFrom_RoundTrip(t *testing.T) {
 		t.Errorf("Filesize = %q, want %q", got.Filesize, entry.Filesize)
 	}
 }
+
+func TestInit_UpgradesOldSchema(t *testing.T) {
+	casDir := t.TempDir()
+
+	db, err := sql.Open("sqlite", filepath.Join(casDir, "catalog.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE entries (
+		filepath TEXT PRIMARY KEY NOT NULL,
+		hash TEXT NOT NULL,
+		filesize INTEGER NOT NULL,
+		modtime INTEGER NOT NULL
+	);
+	INSERT INTO entries VALUES ('old.txt', 'abc', 3, 0);`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("creating old schema: %v", err)
+	}
+
+	if state, err := ReadSchema(casDir); err != nil || !state.Unversioned || state.Pending() != LatestSchema() {
+		t.Errorf("ReadSchema() before upgrade = %+v, %v, want unversioned", state, err)
+	}
+
+	cat := NewCatalog(casDir)
+	defer cat.Close()
+
+	old, err := cat.GetEntry("old.txt")
+	if err != nil {
+		t.Fatalf("GetEntry() error: %v", err)
+	}
+	if old.Mode != 0 || old.Type != TypeFile {
+		t.Errorf("old entry = %+v, want mode 0 and type file", old)
+	}
+
+	if err := cat.AddEntry(Entry{Filepath: "new.txt", Hash: "def", Filesize: 1, Mode: 0640}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+	if got, _ := cat.GetEntry("new.txt"); got.Mode != 0640 {
+		t.Errorf("Mode = %o, want 0640", got.Mode)
+	}
+
+	if blob, err := cat.Blob("abc"); err != nil || blob.Refcount != 1 || blob.Size != 3 {
+		t.Errorf("Blob(abc) after upgrade = %+v, %v, want refcount 1", blob, err)
+	}
+
+	if history, err := cat.History("old.txt"); err != nil || len(history) != 1 || history[0].Hash != "abc" {
+		t.Errorf("History(old.txt) after upgrade = %+v, %v, want one version", history, err)
+	}
+}
+
+func TestAddEntry_Metadata(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	entries := []Entry{
+		{Filepath: "bin", Hash: "e3b0", Type: TypeDir, Mode: 01755, UID: 1000, GID: 100},
+		{Filepath: "bin/run", Hash: "aaaa", Filesize: 4, Type: TypeFile, Mode: 04755,
+			Xattrs: map[string][]byte{"user.origin": []byte("build\x00")}},
+		{Filepath: "bin/latest", Hash: "bbbb", Filesize: 3, Type: TypeSymlink, Target: "run"},
+	}
+	for _, entry := range entries {
+		if err := cat.AddEntry(entry); err != nil {
+			t.Fatalf("AddEntry(%s) error: %v", entry.Filepath, err)
+		}
+	}
+
+	for _, want := range entries {
+		got, err := cat.GetEntry(want.Filepath)
+		if err != nil {
+			t.Fatalf("GetEntry(%s) error: %v", want.Filepath, err)
+		}
+		if got.Type != want.Type || got.Mode != want.Mode || got.UID != want.UID ||
+			got.GID != want.GID || got.Target != want.Target ||
+			!bytes.Equal(got.Xattrs["user.origin"], want.Xattrs["user.origin"]) ||
+			len(got.Xattrs) != len(want.Xattrs) {
+			t.Errorf("GetEntry(%s) = %+v, want %+v", want.Filepath, got, want)
+		}
+	}
+
+	history, err := cat.History("bin/latest")
+	if err != nil || len(history) != 1 || history[0].Target != "run" {
+		t.Errorf("History(bin/latest) = %+v, %v, want the symlink target kept", history, err)
+	}
+
+	// A metadata change is a new version even though the content is the same.
+	chmod := entries[1]
+	chmod.Mode = 0755
+	if err := cat.AddEntry(chmod); err != nil {
+		t.Fatalf("AddEntry(chmod) error: %v", err)
+	}
+	if err := cat.AddEntry(chmod); err != nil {
+		t.Fatalf("AddEntry(unchanged) error: %v", err)
+	}
+	history, err = cat.History("bin/run")
+	if err != nil || len(history) != 2 || history[0].Mode != 0755 || history[1].Mode != 04755 {
+		t.Errorf("History(bin/run) = %+v, %v, want the chmod recorded once", history, err)
+	}
+
+	stats, err := cat.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+	if stats.Files != 1 {
+		t.Errorf("Stats().Files = %d, want only the regular file counted", stats.Files)
+	}
+
+	if err := cat.AddEntry(Entry{Filepath: "dev", Hash: "cccc", Type: "fifo"}); err == nil {
+		t.Error("AddEntry() accepted an unknown type")
+	}
+}
+
+func TestRefcounts(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	add := func(path, hash string, size uint64) {
+		t.Helper()
+		if err := cat.AddEntry(Entry{Filepath: path, Hash: hash, Filesize: size}); err != nil {
+			t.Fatalf("AddEntry(%s) error: %v", path, err)
+		}
+	}
+	refcount := func(hash string) int {
+		t.Helper()
+		blob, err := cat.Blob(hash)
+		if err == sql.ErrNoRows {
+			return 0
+		}
+		if err != nil {
+			t.Fatalf("Blob(%s) error: %v", hash, err)
+		}
+		return blob.Refcount
+	}
+
+	add("a.txt", "aaa", 10)
+	add("b.txt", "aaa", 10)
+	add("c.txt", "ccc", 5)
+
+	if got := refcount("aaa"); got != 2 {
+		t.Errorf("refcount(aaa) = %d, want 2", got)
+	}
+
+	// Rewriting an entry with the same content must not count it twice.
+	add("a.txt", "aaa", 10)
+	if got := refcount("aaa"); got != 2 {
+		t.Errorf("refcount(aaa) after rewrite = %d, want 2", got)
+	}
+
+	add("c.txt", "ddd", 7)
+	if got := refcount("ccc"); got != 0 {
+		t.Errorf("refcount(ccc) after overwrite = %d, want 0", got)
+	}
+	// The overwritten content is still referenced by the older version.
+	if ok, _ := cat.HasHash("ccc"); !ok {
+		t.Error("HasHash(ccc) = false while an older version of c.txt points at it")
+	}
+
+	stats, err := cat.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+	want := Stats{Files: 3, UniqueBlobs: 2, TotalSize: 27, UniqueSize: 17}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	blob := "abc123def456abc123def456abc123def456abc123def456abc123def456abc1"
+	other := "def456abc123def456abc123def456abc123def456abc123def456abc123def4"
+
+	if err := cat.AddEntry(Entry{Filepath: "ledger.csv", Hash: other, ModTime: time.Now()}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := cat.SetRetention(HoldBlob, blob, until, "audit"); err != nil {
+		t.Fatalf("SetRetention() error: %v", err)
+	}
+	if err := cat.SetLegalHold(HoldPath, "ledger.csv", true, "case 42"); err != nil {
+		t.Fatalf("SetLegalHold() error: %v", err)
+	}
+
+	if err := cat.SetRetention(HoldBlob, blob, until.Add(-time.Minute), ""); !errors.Is(err, ErrRetentionActive) {
+		t.Errorf("shortening retention error = %v, want ErrRetentionActive", err)
+	}
+	if err := cat.SetRetention(HoldPath, "missing.txt", until, ""); err == nil {
+		t.Error("SetRetention() on an unknown path succeeded")
+	}
+
+	// A hold that fails in part is not placed at all.
+	before, _ := cat.HoldEvents()
+	if err := cat.PlaceHold(HoldBlob, blob, until.Add(-time.Minute), true, ""); !errors.Is(err, ErrRetentionActive) {
+		t.Errorf("PlaceHold() shortening retention error = %v, want ErrRetentionActive", err)
+	}
+	if after, _ := cat.HoldEvents(); len(after) != len(before) {
+		t.Errorf("failed PlaceHold() recorded %d events", len(after)-len(before))
+	}
+
+	held, err := cat.HeldHashes()
+	if err != nil {
+		t.Fatalf("HeldHashes() error: %v", err)
+	}
+	if len(held) != 2 {
+		t.Errorf("HeldHashes() = %v, want the held blob and the path's blob", held)
+	}
+
+	// A held path cannot be pointed at other content.
+	err = cat.AddEntry(Entry{Filepath: "ledger.csv", Hash: blob, ModTime: time.Now()})
+	if !errors.Is(err, ErrHeld) {
+		t.Errorf("AddEntry() over held path error = %v, want ErrHeld", err)
+	}
+
+	if err := cat.SetLegalHold(HoldPath, "ledger.csv", false, "case closed"); err != nil {
+		t.Fatalf("SetLegalHold() release error: %v", err)
+	}
+	if err := cat.AddEntry(Entry{Filepath: "ledger.csv", Hash: blob, ModTime: time.Now()}); err != nil {
+		t.Errorf("AddEntry() after release error: %v", err)
+	}
+
+	holds, err := cat.Holds()
+	if err != nil {
+		t.Fatalf("Holds() error: %v", err)
+	}
+	if len(holds) != 1 || holds[0].Target != blob || !holds[0].RetainUntil.After(time.Now()) {
+		t.Errorf("Holds() = %+v, want only the blob retention", holds)
+	}
+
+	events, err := cat.HoldEvents()
+	if err != nil {
+		t.Fatalf("HoldEvents() error: %v", err)
+	}
+	actions := []string{ActionRetain, ActionLegalHold, ActionLegalRelease}
+	if len(events) != len(actions) {
+		t.Fatalf("HoldEvents() returned %d events, want %d", len(events), len(actions))
+	}
+	for i, event := range events {
+		if event.Action != actions[i] {
+			t.Errorf("event %d action = %q, want %q", i, event.Action, actions[i])
+		}
+	}
+	if events[1].Reason != "case 42" {
+		t.Errorf("event reason = %q, want %q", events[1].Reason, "case 42")
+	}
+}
+
+func TestAddEntry_Hooks(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	h := hooks.New()
+	var kinds []hooks.Kind
+	h.Register(hooks.Post, hooks.EntryAdded, func(e hooks.Event) error {
+		kinds = append(kinds, e.Kind)
+		return nil
+	})
+	h.Register(hooks.Post, hooks.EntryReplaced, func(e hooks.Event) error {
+		kinds = append(kinds, e.Kind)
+		if e.PreviousHash == "" {
+			t.Error("replaced event has no previous hash")
+		}
+		return nil
+	})
+	h.Register(hooks.Pre, hooks.EntryAdded, func(e hooks.Event) error {
+		if e.Path == "secret.key" {
+			return errors.New("keys are not tracked")
+		}
+		return nil
+	})
+	cat.SetHooks(h)
+
+	first := "abc123def456abc123def456abc123def456abc123def456abc123def456abc1"
+	second := "def456abc123def456abc123def456abc123def456abc123def456abc123def4"
+
+	for _, entry := range []Entry{
+		{Filepath: "notes.txt", Hash: first, ModTime: time.Now()},
+		{Filepath: "notes.txt", Hash: second, ModTime: time.Now()},
+	} {
+		if err := cat.AddEntry(entry); err != nil {
+			t.Fatalf("AddEntry() error: %v", err)
+		}
+	}
+
+	if len(kinds) != 2 || kinds[0] != hooks.EntryAdded || kinds[1] != hooks.EntryReplaced {
+		t.Errorf("events = %v, want added then replaced", kinds)
+	}
+
+	err := cat.AddEntry(Entry{Filepath: "secret.key", Hash: first, ModTime: time.Now()})
+	if !errors.Is(err, hooks.ErrVetoed) {
+		t.Errorf("AddEntry() error = %v, want ErrVetoed", err)
+	}
+	if _, err := cat.GetEntry("secret.key"); err == nil {
+		t.Error("vetoed entry was added")
+	}
+}
+
+func TestVersions(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	add := func(hash string) time.Time {
+		t.Helper()
+		if err := cat.AddEntry(Entry{Filepath: "a.txt", Hash: hash, Filesize: 3}); err != nil {
+			t.Fatalf("AddEntry(%s) error: %v", hash, err)
+		}
+		time.Sleep(time.Millisecond)
+		return time.Now()
+	}
+
+	afterFirst := add("v1")
+	add("v1") // same content, no new version
+	add("v2")
+	add("v3")
+
+	history, err := cat.History("a.txt")
+	if err != nil {
+		t.Fatalf("History() error: %v", err)
+	}
+	if len(history) != 3 || history[0].Version != 3 || history[0].Hash != "v3" || history[2].Hash != "v1" {
+		t.Fatalf("History() = %+v, want v3, v2, v1", history)
+	}
+
+	if v, err := cat.GetVersion("a.txt", 2); err != nil || v.Hash != "v2" {
+		t.Errorf("GetVersion(2) = %+v, %v, want v2", v, err)
+	}
+	if _, err := cat.GetVersion("a.txt", 9); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("GetVersion(9) error = %v, want ErrVersionNotFound", err)
+	}
+
+	if v, err := cat.GetEntryAt("a.txt", afterFirst); err != nil || v.Hash != "v1" {
+		t.Errorf("GetEntryAt(after first add) = %+v, %v, want v1", v, err)
+	}
+	if _, err := cat.GetEntryAt("a.txt", afterFirst.Add(-time.Hour)); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("GetEntryAt(before first add) error = %v, want ErrVersionNotFound", err)
+	}
+
+	cat.SetMaxVersions(2)
+	add("v4")
+
+	history, err = cat.History("a.txt")
+	if err != nil {
+		t.Fatalf("History() error: %v", err)
+	}
+	if len(history) != 2 || history[0].Version != 4 || history[1].Version != 3 {
+		t.Errorf("History() with 2 kept = %+v, want versions 4 and 3", history)
+	}
+
+	for hash, want := range map[string]bool{"v1": false, "v2": false, "v3": true, "v4": true} {
+		if ok, _ := cat.HasHash(hash); ok != want {
+			t.Errorf("HasHash(%s) = %v, want %v", hash, ok, want)
+		}
+	}
+}
+
+func TestDeleteEntry(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	for _, path := range []string{"docs/a.txt", "docs/sub/b.txt", "docsx.txt", "top.txt"} {
+		if err := cat.AddEntry(Entry{Filepath: path, Hash: "h-" + path, Filesize: 1}); err != nil {
+			t.Fatalf("AddEntry(%s) error: %v", path, err)
+		}
+	}
+
+	if _, err := cat.DeleteEntry("missing.txt", false); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("DeleteEntry(missing) error = %v, want ErrEntryNotFound", err)
+	}
+	if _, err := cat.DeleteEntry("docs", false); !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("DeleteEntry(docs) error = %v, want ErrIsDirectory", err)
+	}
+
+	if n, err := cat.DeleteEntry("top.txt", false); err != nil || n != 1 {
+		t.Errorf("DeleteEntry(top.txt) = %d, %v, want 1", n, err)
+	}
+	if n, err := cat.DeleteEntry("docs/", true); err != nil || n != 2 {
+		t.Errorf("DeleteEntry(docs/, recursive) = %d, %v, want 2", n, err)
+	}
+
+	entries, err := cat.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries() error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Filepath != "docsx.txt" {
+		t.Errorf("entries after delete = %+v, want only docsx.txt", entries)
+	}
+
+	if blob, err := cat.Blob("h-top.txt"); err != sql.ErrNoRows {
+		t.Errorf("Blob() of deleted entry = %+v, %v, want no refcount row", blob, err)
+	}
+	if history, err := cat.History("top.txt"); err != nil || len(history) != 1 {
+		t.Errorf("History() of deleted entry = %+v, %v, want its version kept", history, err)
+	}
+
+	if err := cat.SetLegalHold(HoldPath, "docsx.txt", true, ""); err != nil {
+		t.Fatalf("SetLegalHold() error: %v", err)
+	}
+	if _, err := cat.DeleteEntry("docsx.txt", false); !errors.Is(err, ErrHeld) {
+		t.Errorf("DeleteEntry() of held path error = %v, want ErrHeld", err)
+	}
+}
+
+func TestRenameEntry(t *testing.T) {
+	cat := NewCatalog(t.TempDir())
+	defer cat.Close()
+
+	for _, path := range []string{"src/a.go", "src/pkg/b.go", "other.txt", "held.txt"} {
+		if err := cat.AddEntry(Entry{Filepath: path, Hash: "h-" + path, Filesize: 1}); err != nil {
+			t.Fatalf("AddEntry(%s) error: %v", path, err)
+		}
+	}
+
+	if n, err := cat.RenameEntry("other.txt", "renamed.txt"); err != nil || n != 1 {
+		t.Fatalf("RenameEntry(other.txt) = %d, %v, want 1", n, err)
+	}
+	if got, err := cat.GetEntry("renamed.txt"); err != nil || got.Hash != "h-other.txt" {
+		t.Errorf("GetEntry(renamed.txt) = %+v, %v", got, err)
+	}
+	if _, err := cat.GetEntry("other.txt"); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("GetEntry(other.txt) after rename error = %v, want ErrEntryNotFound", err)
+	}
+
+	if n, err := cat.RenameEntry("src", "lib"); err != nil || n != 2 {
+		t.Fatalf("RenameEntry(src, lib) = %d, %v, want 2", n, err)
+	}
+	for _, path := range []string{"lib/a.go", "lib/pkg/b.go"} {
+		if _, err := cat.GetEntry(path); err != nil {
+			t.Errorf("GetEntry(%s) after directory rename error: %v", path, err)
+		}
+	}
+
+	// A conflict on any entry leaves the whole directory where it was.
+	if err := cat.AddEntry(Entry{Filepath: "dest/pkg/b.go", Hash: "taken", Filesize: 1}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+	if _, err := cat.RenameEntry("lib", "dest"); !errors.Is(err, ErrEntryExists) {
+		t.Errorf("RenameEntry() onto an existing entry error = %v, want ErrEntryExists", err)
+	}
+	if _, err := cat.GetEntry("lib/a.go"); err != nil {
+		t.Errorf("GetEntry(lib/a.go) after failed rename error: %v", err)
+	}
+	if _, err := cat.GetEntry("dest/a.go"); err == nil {
+		t.Error("dest/a.go exists after a rename that failed")
+	}
+
+	if _, err := cat.RenameEntry("lib", "lib/inner"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("RenameEntry() into itself error = %v, want ErrInvalidPath", err)
+	}
+
+	if err := cat.SetRetention(HoldPath, "held.txt", time.Now().Add(time.Hour), ""); err != nil {
+		t.Fatalf("SetRetention() error: %v", err)
+	}
+	if _, err := cat.RenameEntry("held.txt", "free.txt"); !errors.Is(err, ErrHeld) {
+		t.Errorf("RenameEntry() of held path error = %v, want ErrHeld", err)
+	}
+
+	stats, err := cat.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+	if stats.Files != 5 {
+		t.Errorf("Stats().Files = %d, want 5", stats.Files)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	casDir := t.TempDir()
+
+	state, err := ReadSchema(casDir)
+	if err != nil || state.Version != 0 || state.Unversioned || len(state.Migrations) != LatestSchema() {
+		t.Fatalf("ReadSchema() of missing catalog = %+v, %v", state, err)
+	}
+
+	cat := NewCatalog(casDir)
+	if err := cat.AddEntry(Entry{Filepath: "a.txt", Hash: "aaaa", Filesize: 4, Type: TypeFile}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+	cat.Close()
+
+	state, err = ReadSchema(casDir)
+	if err != nil {
+		t.Fatalf("ReadSchema() error: %v", err)
+	}
+	if state.Version != LatestSchema() || state.Pending() != 0 || state.TooNew() {
+		t.Errorf("ReadSchema() = %+v, want the latest version", state)
+	}
+	for _, m := range state.Migrations {
+		if m.Applied.IsZero() {
+			t.Errorf("migration %d (%s) not recorded as applied", m.Version, m.Description)
+		}
+	}
+
+	if err := MigrateSchema(casDir, 0); err == nil {
+		t.Fatal("MigrateSchema(0) dropped the entries table")
+	}
+
+	// Every down migration must undo its up migration.
+	if err := MigrateSchema(casDir, OldestSchema); err != nil {
+		t.Fatalf("MigrateSchema(%d) error: %v", OldestSchema, err)
+	}
+	if state, _ := ReadSchema(casDir); state.Version != OldestSchema || state.Pending() != LatestSchema()-OldestSchema {
+		t.Errorf("ReadSchema() after migrating down = %+v, want version %d", state, OldestSchema)
+	}
+	db, err := openDB(casDir)
+	if err != nil {
+		t.Fatalf("openDB() error: %v", err)
+	}
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+	db.Close()
+	if err != nil || count != 1 {
+		t.Errorf("entries at version %d = %d, %v; want 1", OldestSchema, count, err)
+	}
+	if err := MigrateSchema(casDir, LatestSchema()); err != nil {
+		t.Fatalf("MigrateSchema(latest) error: %v", err)
+	}
+	if err := MigrateSchema(casDir, LatestSchema()+1); err == nil {
+		t.Error("MigrateSchema() accepted an unknown version")
+	}
+
+	cat = NewCatalog(casDir)
+	defer cat.Close()
+	if err := cat.AddEntry(Entry{Filepath: "b", Hash: "bbbb", Type: TypeSymlink, Target: "a.txt"}); err != nil {
+		t.Fatalf("AddEntry() after migrating back up error: %v", err)
+	}
+}
+
+func TestSchema_KeepsRowsRecordedNowhereElse(t *testing.T) {
+	blob := "abc123def456abc123def456abc123def456abc123def456abc123def456abc1"
+
+	tests := []struct {
+		name  string
+		setup func(cat *Catalog) error
+	}{
+		{"inline objects", func(cat *Catalog) error {
+			_, err := cat.db.Exec("INSERT INTO inline_objects (key, data, mtime) VALUES ('aaaa', 'tiny', 0)")
+			return err
+		}},
+		{"holds", func(cat *Catalog) error {
+			return cat.SetLegalHold(HoldBlob, blob, true, "case 42")
+		}},
+		{"refs", func(cat *Catalog) error {
+			return cat.UpdateRef("HEAD", "", blob)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			casDir := t.TempDir()
+
+			cat := NewCatalog(casDir)
+			if err := cat.Load(); err != nil {
+				t.Fatalf("Load() error: %v", err)
+			}
+			err := tt.setup(cat)
+			cat.Close()
+			if err != nil {
+				t.Fatalf("setup error: %v", err)
+			}
+
+			if err := MigrateSchema(casDir, OldestSchema); err == nil {
+				t.Fatalf("MigrateSchema() dropped the %s", tt.name)
+			}
+			if state, _ := ReadSchema(casDir); state.Version != LatestSchema() {
+				t.Errorf("schema version after refused migration = %d, want %d", state.Version, LatestSchema())
+			}
+		})
+	}
+}
+
+func TestSchema_RefusesNewerCatalog(t *testing.T) {
+	casDir := t.TempDir()
+
+	cat := NewCatalog(casDir)
+	if err := cat.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	_, err := cat.db.Exec("INSERT INTO schema_version (version, description, applied) VALUES (?, 'from the future', 0)", LatestSchema()+1)
+	cat.Close()
+	if err != nil {
+		t.Fatalf("recording a newer version: %v", err)
+	}
+
+	if state, err := ReadSchema(casDir); err != nil || !state.TooNew() {
+		t.Errorf("ReadSchema() = %+v, %v, want too new", state, err)
+	}
+
+	cat = NewCatalog(casDir)
+	defer cat.Close()
+	if _, err := cat.ListEntries(); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("ListEntries() error = %v, want ErrSchemaTooNew", err)
+	}
+	if err := MigrateSchema(casDir, OldestSchema); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("MigrateSchema() error = %v, want ErrSchemaTooNew", err)
+	}
+}
@@ -0,0 +1,337 @@
+package catalog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+const (
+	HoldBlob = "blob"
+	HoldPath = "path"
+)
+
+const (
+	ActionRetain       = "retain"
+	ActionLegalHold    = "legal-hold"
+	ActionLegalRelease = "legal-release"
+)
+
+var (
+	ErrHeld = errors.New("held by a retention or legal hold")
+	// ErrRetentionActive is returned for attempts to shorten a retention
+	// period that has not yet run out; it can only be extended.
+	ErrRetentionActive = errors.New("retention period can only be extended")
+)
+
+// Hold keeps a blob, or the content a catalog path points at, from being
+// deleted. A retention hold lapses at RetainUntil; a legal hold lasts until
+// it is released.
+type Hold struct {
+	TargetType  string    `json:"target_type"`
+	Target      string    `json:"target"`
+	RetainUntil time.Time `json:"retain_until"`
+	Legal       bool      `json:"legal"`
+}
+
+func (h Hold) Active(now time.Time) bool {
+	return h.Legal || h.RetainUntil.After(now)
+}
+
+// HoldEvent records one change to a hold. Events are never updated or
+// removed.
+type HoldEvent struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	TargetType  string    `json:"target_type"`
+	Target      string    `json:"target"`
+	RetainUntil time.Time `json:"retain_until"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// SetRetention holds target until the given time, extending any retention
+// already in place.
+func (c *Catalog) SetRetention(targetType, target string, until time.Time, reason string) error {
+	return c.PlaceHold(targetType, target, until, false, reason)
+}
+
+// SetLegalHold places or releases the legal hold on target. Releasing it
+// leaves any retention period in force.
+func (c *Catalog) SetLegalHold(targetType, target string, held bool, reason string) error {
+	if held {
+		return c.PlaceHold(targetType, target, time.Time{}, true, reason)
+	}
+
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if targetType == HoldBlob {
+		target = objects.CanonicalDigest(target)
+	}
+
+	current, err := getHold(tx, targetType, target)
+	if err != nil {
+		return err
+	}
+	if !current.Legal {
+		return fmt.Errorf("no legal hold on %s", target)
+	}
+
+	_, err = tx.Exec("UPDATE holds SET legal = 0 WHERE target_type = ? AND target = ?", targetType, target)
+	if err != nil {
+		return fmt.Errorf("failed to update legal hold: %w", err)
+	}
+
+	if err := recordHoldEvent(tx, time.Now(), ActionLegalRelease, targetType, target, time.Time{}, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PlaceHold retains target until the given time, places a legal hold on it,
+// or both, in one transaction, so a failure leaves neither in place. A zero
+// until sets no retention.
+func (c *Catalog) PlaceHold(targetType, target string, until time.Time, legal bool, reason string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if until.IsZero() && !legal {
+		return fmt.Errorf("a retention date or legal hold is required")
+	}
+	if !until.IsZero() && !until.After(now) {
+		return fmt.Errorf("retention date must be in the future")
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	target, err = checkTarget(tx, targetType, target)
+	if err != nil {
+		return err
+	}
+
+	if !until.IsZero() {
+		if err := setRetention(tx, now, targetType, target, until, reason); err != nil {
+			return err
+		}
+	}
+
+	if legal {
+		_, err = tx.Exec(`
+				INSERT INTO holds (target_type, target, retain_until, legal)
+				VALUES (?, ?, 0, 1)
+				ON CONFLICT(target_type, target) DO UPDATE SET legal = 1
+		`, targetType, target)
+		if err != nil {
+			return fmt.Errorf("failed to update legal hold: %w", err)
+		}
+
+		if err := recordHoldEvent(tx, now, ActionLegalHold, targetType, target, time.Time{}, reason); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// setRetention extends the retention of target to until, refusing to
+// shorten one that has not yet run out.
+func setRetention(tx *sql.Tx, now time.Time, targetType, target string, until time.Time, reason string) error {
+	current, err := getHold(tx, targetType, target)
+	if err != nil {
+		return err
+	}
+	if current.RetainUntil.After(now) && until.Before(current.RetainUntil) {
+		return fmt.Errorf("%w: %s is retained until %s", ErrRetentionActive, target, current.RetainUntil.Format(time.RFC3339))
+	}
+
+	_, err = tx.Exec(`
+			INSERT INTO holds (target_type, target, retain_until, legal)
+			VALUES (?, ?, ?, 0)
+			ON CONFLICT(target_type, target) DO UPDATE SET retain_until = excluded.retain_until
+	`, targetType, target, until.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to set retention: %w", err)
+	}
+
+	return recordHoldEvent(tx, now, ActionRetain, targetType, target, until, reason)
+}
+
+// Holds lists the holds currently in force.
+func (c *Catalog) Holds() ([]Hold, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query(`
+			SELECT target_type, target, retain_until, legal FROM holds
+			WHERE legal = 1 OR retain_until > ?
+			ORDER BY target_type, target
+	`, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []Hold
+	for rows.Next() {
+		var hold Hold
+		var until int64
+		if err := rows.Scan(&hold.TargetType, &hold.Target, &until, &hold.Legal); err != nil {
+			return nil, err
+		}
+		hold.RetainUntil = unixOrZero(until)
+		holds = append(holds, hold)
+	}
+
+	return holds, rows.Err()
+}
+
+// HeldHashes lists the blobs that holds currently protect: those held
+// directly and those held paths point at.
+func (c *Catalog) HeldHashes() ([]string, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query(`
+			SELECT target FROM holds
+			WHERE target_type = 'blob' AND (legal = 1 OR retain_until > ?1)
+			UNION
+			SELECT e.hash FROM holds h JOIN entries e ON e.filepath = h.target
+			WHERE h.target_type = 'path' AND (h.legal = 1 OR h.retain_until > ?1)
+	`, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
+
+// HoldEvents returns every recorded hold change, oldest first.
+func (c *Catalog) HoldEvents() ([]HoldEvent, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query("SELECT time, action, target_type, target, retain_until, reason FROM hold_events ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []HoldEvent
+	for rows.Next() {
+		var event HoldEvent
+		var at, until int64
+		if err := rows.Scan(&at, &event.Action, &event.TargetType, &event.Target, &until, &event.Reason); err != nil {
+			return nil, err
+		}
+		event.Time = time.Unix(0, at)
+		event.RetainUntil = unixOrZero(until)
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// checkTarget validates a hold target and returns its canonical form.
+// Paths must be in the catalog; blobs need only be well-formed digests, since
+// the catalog does not know what storage holds.
+func checkTarget(tx *sql.Tx, targetType, target string) (string, error) {
+	switch targetType {
+	case HoldBlob:
+		if !objects.ValidDigest(target) {
+			return "", fmt.Errorf("invalid digest: %s", target)
+		}
+		return objects.CanonicalDigest(target), nil
+	case HoldPath:
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM entries WHERE filepath = ?)", target).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("path not found in catalog: %s", target)
+		}
+		return target, nil
+	default:
+		return "", fmt.Errorf("unknown hold target type: %s", targetType)
+	}
+}
+
+func getHold(tx *sql.Tx, targetType, target string) (Hold, error) {
+	hold := Hold{TargetType: targetType, Target: target}
+	var until int64
+
+	err := tx.QueryRow(
+		"SELECT retain_until, legal FROM holds WHERE target_type = ? AND target = ?",
+		targetType, target,
+	).Scan(&until, &hold.Legal)
+	if err != nil && err != sql.ErrNoRows {
+		return Hold{}, err
+	}
+
+	hold.RetainUntil = unixOrZero(until)
+	return hold, nil
+}
+
+// checkPathHold fails with ErrHeld if a hold is in force on path.
+func checkPathHold(tx *sql.Tx, path string) error {
+	hold, err := getHold(tx, HoldPath, path)
+	if err != nil {
+		return err
+	}
+	if hold.Active(time.Now()) {
+		return fmt.Errorf("%w: %s", ErrHeld, path)
+	}
+	return nil
+}
+
+func recordHoldEvent(tx *sql.Tx, at time.Time, action, targetType, target string, until time.Time, reason string) error {
+	var untilNano int64
+	if !until.IsZero() {
+		untilNano = until.UnixNano()
+	}
+
+	_, err := tx.Exec(`
+			INSERT INTO hold_events (time, action, target_type, target, retain_until, reason)
+			VALUES (?, ?, ?, ?, ?, ?)
+	`, at.UnixNano(), action, targetType, target, untilNano, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record hold change: %w", err)
+	}
+	return nil
+}
+
+func unixOrZero(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
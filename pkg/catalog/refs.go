@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+// ErrRefMoved is returned when a ref no longer points where the caller
+// expected, because another writer moved it first.
+var ErrRefMoved = errors.New("ref was moved by another writer")
+
+// Ref returns the hash a named ref points at, or "" if it is not set.
+func (c *Catalog) Ref(name string) (string, error) {
+	if err := c.init(); err != nil {
+		return "", err
+	}
+
+	var hash string
+	err := c.db.QueryRow("SELECT hash FROM refs WHERE name = ?", name).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// UpdateRef points name at hash, provided it still points at old; an empty
+// old means the ref must not exist yet.
+func (c *Catalog) UpdateRef(name, old, hash string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	if !objects.ValidDigest(hash) {
+		return fmt.Errorf("invalid digest: %s", hash)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow("SELECT hash FROM refs WHERE name = ?", name).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if current != old {
+		return fmt.Errorf("%w: %s", ErrRefMoved, name)
+	}
+
+	_, err = tx.Exec(`
+			INSERT INTO refs (name, hash) VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET hash = excluded.hash
+	`, name, objects.CanonicalDigest(hash))
+	if err != nil {
+		return fmt.Errorf("failed to update ref: %w", err)
+	}
+
+	return tx.Commit()
+}
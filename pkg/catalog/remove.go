@@ -0,0 +1,249 @@
+package catalog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+)
+
+var (
+	ErrEntryNotFound = errors.New("path not found in catalog")
+	ErrEntryExists   = errors.New("path already in catalog")
+	ErrInvalidPath   = errors.New("invalid catalog path")
+	// ErrIsDirectory is returned for a path that only names a directory of
+	// entries when the operation was not asked to recurse.
+	ErrIsDirectory = errors.New("path is a directory")
+)
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// DeleteEntry removes path from the catalog and returns how many entries
+// were removed. With recursive, a path naming a directory removes every
+// entry under it. The removed entries' versions stay in the history.
+func (c *Catalog) DeleteEntry(path string, recursive bool) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+
+	path, err := cleanEntryPath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// As in AddEntry, pre hooks run before the transaction; the entries
+	// are then looked up again inside it.
+	matches, err := matchEntries(c.db, path, recursive)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range matches {
+		if err := c.hooks.Pre(deleteEvent(entry)); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	matches, err = matchEntries(tx, path, recursive)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range matches {
+		if err := checkPathHold(tx, entry.Filepath); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("DELETE FROM entries WHERE filepath = ?", entry.Filepath); err != nil {
+			return 0, fmt.Errorf("failed to delete %s: %w", entry.Filepath, err)
+		}
+		if err := release(tx, entry.Hash); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, entry := range matches {
+		c.hooks.Post(deleteEvent(entry))
+	}
+
+	return len(matches), nil
+}
+
+// RenameEntry moves from to to and returns how many entries moved. If from
+// names a directory, every entry under it moves in one transaction, so
+// either all of them end up under to or none do. No entry may be
+// overwritten. A moved entry starts a new version at its new path; the
+// history of the old path is kept.
+func (c *Catalog) RenameEntry(from, to string) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+
+	from, err := cleanEntryPath(from)
+	if err != nil {
+		return 0, err
+	}
+	to, err = cleanEntryPath(to)
+	if err != nil {
+		return 0, err
+	}
+
+	if from == to {
+		return 0, fmt.Errorf("%w: cannot move %s onto itself", ErrInvalidPath, from)
+	}
+	if strings.HasPrefix(to, from+"/") {
+		return 0, fmt.Errorf("%w: cannot move %s into itself", ErrInvalidPath, from)
+	}
+
+	matches, err := matchEntries(c.db, from, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range matches {
+		if err := c.hooks.Pre(renameEvent(entry, from, to)); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	matches, err = matchEntries(tx, from, true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range matches {
+		if err := checkPathHold(tx, entry.Filepath); err != nil {
+			return 0, err
+		}
+
+		dest := movedPath(entry.Filepath, from, to)
+
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM entries WHERE filepath = ?)", dest).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, fmt.Errorf("%w: %s", ErrEntryExists, dest)
+		}
+
+		if _, err := tx.Exec("UPDATE entries SET filepath = ? WHERE filepath = ?", dest, entry.Filepath); err != nil {
+			return 0, fmt.Errorf("failed to move %s: %w", entry.Filepath, err)
+		}
+
+		moved := entry
+		moved.Filepath = dest
+		if err := recordVersion(tx, moved, entry.Hash, c.maxVersions); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, entry := range matches {
+		c.hooks.Post(renameEvent(entry, from, to))
+	}
+
+	return len(matches), nil
+}
+
+// matchEntries returns the entry at path and, with recursive, every entry
+// under it. It fails with ErrEntryNotFound if there are none, and with
+// ErrIsDirectory if path is a directory but recursive is off.
+func matchEntries(q queryer, path string, recursive bool) ([]Entry, error) {
+	prefix := path + "/"
+
+	rows, err := q.Query(`
+			SELECT `+entryColumns+` FROM entries
+			WHERE filepath = ?1 OR substr(filepath, 1, ?2) = ?3
+			ORDER BY filepath
+	`, path, utf8.RuneCountInString(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []Entry
+	underPath := false
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Filepath != path {
+			underPath = true
+			if !recursive {
+				continue
+			}
+		}
+
+		matches = append(matches, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Like rm, removing a directory takes recursion even when it is empty.
+	if !recursive && len(matches) == 1 && matches[0].Type == TypeDir {
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, path)
+	}
+
+	if len(matches) == 0 {
+		if underPath {
+			return nil, fmt.Errorf("%w: %s", ErrIsDirectory, path)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, path)
+	}
+	return matches, nil
+}
+
+// cleanEntryPath drops trailing slashes, so "docs/" names the same
+// directory as "docs".
+func cleanEntryPath(path string) (string, error) {
+	cleaned := strings.TrimRight(path, "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return cleaned, nil
+}
+
+func movedPath(path, from, to string) string {
+	if path == from {
+		return to
+	}
+	return to + strings.TrimPrefix(path, from)
+}
+
+func deleteEvent(entry Entry) hooks.Event {
+	return hooks.Event{Kind: hooks.EntryDeleted, Hash: entry.Hash, Size: int64(entry.Filesize), Path: entry.Filepath}
+}
+
+func renameEvent(entry Entry, from, to string) hooks.Event {
+	return hooks.Event{
+		Kind:         hooks.EntryRenamed,
+		Hash:         entry.Hash,
+		Size:         int64(entry.Filesize),
+		Path:         movedPath(entry.Filepath, from, to),
+		PreviousPath: entry.Filepath,
+	}
+}
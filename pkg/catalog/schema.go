@@ -0,0 +1,476 @@
+package catalog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrSchemaTooNew is returned for a catalog written by a newer binary, whose
+// schema this one does not know how to use.
+var ErrSchemaTooNew = errors.New("catalog schema is newer than this binary supports")
+
+// migration is one step of the catalog schema. The schema version of a
+// catalog is the number of migrations applied to it, so migrations are only
+// ever appended.
+//
+// Catalogs from before the schema_version table start at version 0 but may
+// already have any of the first migrations' changes, so those check for
+// what they create instead of assuming it is missing.
+type migration struct {
+	description string
+	up          func(tx *sql.Tx) error
+	down        func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{
+		description: "entries table",
+		up: execAll(`
+				CREATE TABLE IF NOT EXISTS entries (
+						filepath TEXT PRIMARY KEY NOT NULL,
+						hash TEXT NOT NULL,
+						filesize INTEGER NOT NULL,
+						modtime INTEGER NOT NULL
+				);
+				CREATE INDEX IF NOT EXISTS idx_hash ON entries(hash);
+		`),
+		// Never reverted: OldestSchema is this migration's version.
+	},
+	{
+		description: "entry permissions",
+		up: func(tx *sql.Tx) error {
+			return addColumn(tx, "entries", "mode", "INTEGER NOT NULL DEFAULT 0")
+		},
+		down: execAll("ALTER TABLE entries DROP COLUMN mode"),
+	},
+	{
+		description: "blob refcounts",
+		up:          createBlobs,
+		down:        execAll("DROP TABLE blobs"),
+	},
+	{
+		description: "retention and legal holds",
+		up: execAll(`
+				CREATE TABLE IF NOT EXISTS holds (
+						target_type TEXT NOT NULL,
+						target TEXT NOT NULL,
+						retain_until INTEGER NOT NULL DEFAULT 0,
+						legal INTEGER NOT NULL DEFAULT 0,
+						PRIMARY KEY (target_type, target)
+				);
+				CREATE TABLE IF NOT EXISTS hold_events (
+						id INTEGER PRIMARY KEY AUTOINCREMENT,
+						time INTEGER NOT NULL,
+						action TEXT NOT NULL,
+						target_type TEXT NOT NULL,
+						target TEXT NOT NULL,
+						retain_until INTEGER NOT NULL DEFAULT 0,
+						reason TEXT NOT NULL DEFAULT ''
+				);
+		`),
+		down: dropUnlessPopulated("holds and their audit log", "hold_events", "holds"),
+	},
+	{
+		description: "snapshot refs",
+		up: execAll(`
+				CREATE TABLE IF NOT EXISTS refs (
+						name TEXT PRIMARY KEY NOT NULL,
+						hash TEXT NOT NULL
+				);
+		`),
+		down: dropUnlessPopulated("snapshot refs, including HEAD", "refs"),
+	},
+	{
+		description: "entry version history",
+		up:          createVersions,
+		down:        execAll("DROP TABLE entry_versions"),
+	},
+	{
+		description: "entry type, owner, symlink target and xattrs",
+		up: func(tx *sql.Tx) error {
+			for _, table := range []string{"entries", "entry_versions"} {
+				for _, column := range metadataColumns {
+					if err := addColumn(tx, table, column.name, column.definition); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		down: func(tx *sql.Tx) error {
+			for _, table := range []string{"entries", "entry_versions"} {
+				for _, column := range metadataColumns {
+					if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column.name)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		description: "inline objects table",
+		up: execAll(`
+				CREATE TABLE IF NOT EXISTS inline_objects (
+						key TEXT PRIMARY KEY NOT NULL,
+						data BLOB NOT NULL,
+						mtime INTEGER NOT NULL
+				);
+		`),
+		down: dropUnlessPopulated("inline objects", "inline_objects"),
+	},
+}
+
+var metadataColumns = []struct{ name, definition string }{
+	{"type", "TEXT NOT NULL DEFAULT 'file'"},
+	{"uid", "INTEGER NOT NULL DEFAULT 0"},
+	{"gid", "INTEGER NOT NULL DEFAULT 0"},
+	{"target", "TEXT NOT NULL DEFAULT ''"},
+	{"xattrs", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// OldestSchema is the lowest version MigrateSchema goes back to. Every
+// released cas opens a catalog holding just the entries table, and below it
+// there is no catalog left to hand over.
+const OldestSchema = 1
+
+// LatestSchema is the schema version this binary creates and upgrades
+// catalogs to.
+func LatestSchema() int {
+	return len(migrations)
+}
+
+// SchemaMigration is one migration as it stands in a catalog. Applied is
+// zero for a migration that has not run yet.
+type SchemaMigration struct {
+	Version     int
+	Description string
+	Applied     time.Time
+}
+
+// SchemaState describes the schema of a catalog without changing it.
+type SchemaState struct {
+	Version int
+	Latest  int
+	// Unversioned is set for a catalog created before schema versions were
+	// recorded; its version is 0 until it is next opened.
+	Unversioned bool
+	Migrations  []SchemaMigration
+}
+
+// Pending reports how many migrations opening the catalog will apply.
+func (s SchemaState) Pending() int {
+	return max(s.Latest-s.Version, 0)
+}
+
+// TooNew reports whether the catalog was written by a newer binary.
+func (s SchemaState) TooNew() bool {
+	return s.Version > s.Latest
+}
+
+// ReadSchema reports the schema state of the catalog in casDir. Unlike
+// opening it, this applies no migrations.
+func ReadSchema(casDir string) (SchemaState, error) {
+	state := SchemaState{Latest: LatestSchema()}
+
+	dbPath := filepath.Join(casDir, "catalog.db")
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		state.Migrations = describeMigrations(nil)
+		return state, nil
+	}
+
+	db, err := openDB(casDir)
+	if err != nil {
+		return SchemaState{}, err
+	}
+	defer db.Close()
+
+	versioned, err := tableExists(db, "schema_version")
+	if err != nil {
+		return SchemaState{}, err
+	}
+
+	applied := make(map[int]time.Time)
+	if versioned {
+		rows, err := db.Query("SELECT version, applied FROM schema_version ORDER BY version")
+		if err != nil {
+			return SchemaState{}, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			var at int64
+			if err := rows.Scan(&version, &at); err != nil {
+				return SchemaState{}, err
+			}
+			applied[version] = time.Unix(0, at)
+			state.Version = max(state.Version, version)
+		}
+		if err := rows.Err(); err != nil {
+			return SchemaState{}, err
+		}
+	} else {
+		state.Unversioned, err = tableExists(db, "entries")
+		if err != nil {
+			return SchemaState{}, err
+		}
+	}
+
+	state.Migrations = describeMigrations(applied)
+	return state, nil
+}
+
+// MigrateSchema moves the catalog in casDir to the given schema version,
+// running down migrations to go back, but never below OldestSchema.
+// Everything runs in one transaction. Opening a catalog upgrades it again,
+// so going back is only useful before handing the repository to an older
+// binary.
+func MigrateSchema(casDir string, version int) error {
+	if version < OldestSchema {
+		return fmt.Errorf("schema version %d is older than any cas can open (oldest is %d)", version, OldestSchema)
+	}
+
+	db, err := openDB(casDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return migrate(db, version)
+}
+
+func describeMigrations(applied map[int]time.Time) []SchemaMigration {
+	described := make([]SchemaMigration, len(migrations))
+	for i, m := range migrations {
+		described[i] = SchemaMigration{Version: i + 1, Description: m.description, Applied: applied[i+1]}
+	}
+	return described
+}
+
+// upgrade brings db to the latest schema, refusing a catalog written by a
+// newer binary. A catalog that is already current is only read.
+func upgrade(db *sql.DB) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == LatestSchema() {
+		return nil
+	}
+	if current > LatestSchema() {
+		return tooNew(current)
+	}
+
+	return migrate(db, LatestSchema())
+}
+
+// migrate applies the migrations between the catalog's version and target
+// in one transaction. The transaction takes the write lock as it begins, so
+// concurrent openers wait and then find the work done.
+func migrate(db *sql.DB, target int) error {
+	if target < 0 || target > LatestSchema() {
+		return fmt.Errorf("unknown schema version %d (latest is %d)", target, LatestSchema())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+			CREATE TABLE IF NOT EXISTS schema_version (
+					version INTEGER PRIMARY KEY NOT NULL,
+					description TEXT NOT NULL,
+					applied INTEGER NOT NULL
+			);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchema() {
+		return tooNew(current)
+	}
+
+	for ; current < target; current++ {
+		m := migrations[current]
+		if err := m.up(tx); err != nil {
+			return fmt.Errorf("schema migration %d (%s) failed: %w", current+1, m.description, err)
+		}
+		_, err := tx.Exec("INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?)",
+			current+1, m.description, time.Now().UnixNano())
+		if err != nil {
+			return fmt.Errorf("failed to record schema version: %w", err)
+		}
+	}
+
+	for ; current > target; current-- {
+		m := migrations[current-1]
+		if err := m.down(tx); err != nil {
+			return fmt.Errorf("reverting schema migration %d (%s) failed: %w", current, m.description, err)
+		}
+		if _, err := tx.Exec("DELETE FROM schema_version WHERE version = ?", current); err != nil {
+			return fmt.Errorf("failed to record schema version: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// schemaVersion returns the version recorded in q, or 0 if no version has
+// been recorded yet.
+func schemaVersion(q rowQueryer) (int, error) {
+	exists, err := tableExists(q, "schema_version")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func tooNew(version int) error {
+	return fmt.Errorf("%w: catalog is at version %d, this binary knows up to %d", ErrSchemaTooNew, version, LatestSchema())
+}
+
+func tableExists(q rowQueryer, table string) (bool, error) {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", table).Scan(&exists)
+	return exists, err
+}
+
+func execAll(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// createBlobs creates the refcount table, filling it from the entries
+// already in the catalog.
+func createBlobs(tx *sql.Tx) error {
+	exists, err := tableExists(tx, "blobs")
+	if err != nil || exists {
+		return err
+	}
+
+	schema := `
+			CREATE TABLE blobs (
+					hash TEXT PRIMARY KEY NOT NULL,
+					refcount INTEGER NOT NULL,
+					size INTEGER NOT NULL,
+					first_seen INTEGER NOT NULL
+			);
+	`
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+			INSERT INTO blobs (hash, refcount, size, first_seen)
+			SELECT hash, COUNT(*), MAX(filesize), MIN(modtime) FROM entries GROUP BY hash
+	`)
+	return err
+}
+
+// createVersions creates the version table, recording the current entry of
+// each path as its first version.
+func createVersions(tx *sql.Tx) error {
+	exists, err := tableExists(tx, "entry_versions")
+	if err != nil || exists {
+		return err
+	}
+
+	schema := `
+			CREATE TABLE entry_versions (
+					filepath TEXT NOT NULL,
+					version INTEGER NOT NULL,
+					hash TEXT NOT NULL,
+					filesize INTEGER NOT NULL,
+					modtime INTEGER NOT NULL,
+					mode INTEGER NOT NULL DEFAULT 0,
+					recorded INTEGER NOT NULL,
+					PRIMARY KEY (filepath, version)
+			);
+			CREATE INDEX IF NOT EXISTS idx_versions_hash ON entry_versions(hash);
+	`
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+			INSERT INTO entry_versions (filepath, version, hash, filesize, modtime, mode, recorded)
+			SELECT filepath, 1, hash, filesize, modtime, mode, modtime FROM entries
+	`)
+	return err
+}
+
+// dropUnlessPopulated drops tables whose rows are recorded nowhere else, such
+// as inline object content, holds or HEAD. It refuses while any of them has
+// rows rather than losing those without a trace.
+func dropUnlessPopulated(what string, tables ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, table := range tables {
+			var count int
+			if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("%s would be lost: table %s has %d rows", what, table, count)
+			}
+		}
+
+		for _, table := range tables {
+			if _, err := tx.Exec(fmt.Sprintf("DROP TABLE %s", table)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds a column unless the table already has it.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,142 @@
+package catalog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// Version is one content a catalog path has pointed at. Versions are
+// numbered from 1 per path and recorded whenever a path is added with
+// content it did not already have.
+type Version struct {
+	Entry
+	Version  int       `json:"version"`
+	Recorded time.Time `json:"recorded"`
+}
+
+// SetMaxVersions caps how many versions are kept for each path; older ones
+// are forgotten as new ones are recorded. Zero keeps every version.
+func (c *Catalog) SetMaxVersions(n int) {
+	c.maxVersions = n
+}
+
+// recordVersion adds entry as the newest version of its path and drops the
+// versions beyond the newest max.
+func recordVersion(tx *sql.Tx, entry Entry, hash string, max int) error {
+	values, err := entryValues(entry, hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+			INSERT INTO entry_versions (`+entryColumns+`, version, recorded)
+			SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, COALESCE(MAX(version), 0) + 1, ?11
+			FROM entry_versions WHERE filepath = ?1
+	`, append(values, time.Now().UnixNano())...)
+	if err != nil {
+		return fmt.Errorf("failed to record version: %w", err)
+	}
+
+	if max <= 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+			DELETE FROM entry_versions WHERE filepath = ?1 AND version <= (
+					SELECT MAX(version) FROM entry_versions WHERE filepath = ?1
+			) - ?2
+	`, entry.Filepath, max)
+	if err != nil {
+		return fmt.Errorf("failed to prune versions: %w", err)
+	}
+	return nil
+}
+
+// History returns the kept versions of path, newest first.
+func (c *Catalog) History(path string) ([]Version, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query(`
+			SELECT `+entryColumns+`, version, recorded FROM entry_versions
+			WHERE filepath = ? ORDER BY version DESC
+	`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []Version
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, path)
+	}
+	return versions, nil
+}
+
+// GetVersion returns one numbered version of path.
+func (c *Catalog) GetVersion(path string, version int) (Version, error) {
+	if err := c.init(); err != nil {
+		return Version{}, err
+	}
+
+	row := c.db.QueryRow(`
+			SELECT `+entryColumns+`, version, recorded FROM entry_versions
+			WHERE filepath = ? AND version = ?
+	`, path, version)
+
+	v, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return Version{}, fmt.Errorf("%w: %s version %d", ErrVersionNotFound, path, version)
+	}
+	return v, err
+}
+
+// GetEntryAt returns the version path had at the given time: the newest one
+// recorded no later than it.
+func (c *Catalog) GetEntryAt(path string, at time.Time) (Version, error) {
+	if err := c.init(); err != nil {
+		return Version{}, err
+	}
+
+	row := c.db.QueryRow(`
+			SELECT `+entryColumns+`, version, recorded FROM entry_versions
+			WHERE filepath = ? AND recorded <= ?
+			ORDER BY version DESC LIMIT 1
+	`, path, at.UnixNano())
+
+	v, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return Version{}, fmt.Errorf("%w: %s at %s", ErrVersionNotFound, path, at.Format(time.RFC3339))
+	}
+	return v, err
+}
+
+func scanVersion(row scanner) (Version, error) {
+	var v Version
+	var recorded int64
+
+	entry, err := scanEntry(row, &v.Version, &recorded)
+	if err != nil {
+		return Version{}, err
+	}
+
+	v.Entry = entry
+	v.Recorded = time.Unix(0, recorded)
+	return v, nil
+}
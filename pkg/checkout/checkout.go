@@ -0,0 +1,365 @@
+package checkout
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/fsmeta"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+const (
+	ModeAuto     = "auto"
+	ModeHardlink = "hardlink"
+	ModeReflink  = "reflink"
+	ModeCopy     = "copy"
+)
+
+// objectPerm is the mode of every stored object. A hardlink shares it.
+const objectPerm = 0444
+
+var ErrExists = errors.New("destination already exists")
+
+var errNotSupported = errors.New("not supported")
+
+type Options struct {
+	// Mode picks how files are materialized. ModeHardlink links every
+	// read-only file, even when the object's time or owner differs from the
+	// recorded one, and copies writable files. ModeHardlink and ModeReflink
+	// fall back to copying when the link cannot be made. ModeAuto tries a
+	// reflink, then a hardlink to an object whose metadata already matches,
+	// then a copy.
+	Mode      string
+	Overwrite bool
+}
+
+type Result struct {
+	Hardlinked int
+	Reflinked  int
+	Copied     int
+	Dirs       int
+	Symlinks   int
+	Skipped    int
+	Bytes      int64
+	// Unrestored lists hardlinked files that kept the object's modification
+	// time or owner rather than the recorded one.
+	Unrestored []string
+}
+
+func ValidMode(mode string) bool {
+	switch mode {
+	case "", ModeAuto, ModeHardlink, ModeReflink, ModeCopy:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run materializes entries under dest, restoring the recorded metadata of
+// each file, directory and symlink. Existing paths are left alone, and
+// counted as skipped, unless opts.Overwrite is set.
+//
+// Symlinks are only created once every file and directory is in place, so
+// that no entry is written through a link. Directories get their metadata
+// last, deepest first, since creating anything inside one changes its
+// modification time and a read-only one could not be filled in.
+func Run(store *storage.Store, entries []catalog.Entry, dest string, opts Options) (Result, error) {
+	var result Result
+	var dirs, links []catalog.Entry
+
+	for _, entry := range entries {
+		target, err := Target(dest, entry.Filepath)
+		if err != nil {
+			return result, err
+		}
+
+		switch entry.Type {
+		case catalog.TypeDir:
+			err := Dir(entry, target, opts)
+			if errors.Is(err, ErrExists) {
+				result.Skipped++
+				continue
+			}
+			if err != nil {
+				return result, fmt.Errorf("failed to check out %s: %w", entry.Filepath, err)
+			}
+			dirs = append(dirs, entry)
+			result.Dirs++
+			continue
+		case catalog.TypeSymlink:
+			links = append(links, entry)
+			continue
+		}
+
+		method, err := File(store, entry, target, opts)
+		if errors.Is(err, ErrExists) {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to check out %s: %w", entry.Filepath, err)
+		}
+
+		switch method {
+		case ModeHardlink:
+			result.Hardlinked++
+			if info, err := os.Stat(target); err == nil && !sharesMetadata(info, entry) {
+				result.Unrestored = append(result.Unrestored, entry.Filepath)
+			}
+		case ModeReflink:
+			result.Reflinked++
+		default:
+			result.Copied++
+		}
+		result.Bytes += int64(entry.Filesize)
+	}
+
+	for _, entry := range links {
+		target, err := Target(dest, entry.Filepath)
+		if err != nil {
+			return result, err
+		}
+
+		err = Symlink(entry, target, opts)
+		if errors.Is(err, ErrExists) {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to check out %s: %w", entry.Filepath, err)
+		}
+		result.Symlinks++
+	}
+
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i].Filepath, "/") > strings.Count(dirs[j].Filepath, "/")
+	})
+	for _, entry := range dirs {
+		target, err := Target(dest, entry.Filepath)
+		if err != nil {
+			return result, err
+		}
+		if err := fsmeta.Apply(target, entry); err != nil {
+			return result, fmt.Errorf("failed to check out %s: %w", entry.Filepath, err)
+		}
+	}
+
+	return result, nil
+}
+
+// Target maps a catalog path into dest, refusing paths that would land
+// outside it.
+func Target(dest, entryPath string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimLeft(entryPath, `/\`)))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to check out %s outside the destination", entryPath)
+	}
+	return filepath.Join(dest, rel), nil
+}
+
+// Dir creates the directory for entry at target. Its metadata is left to
+// the caller, which applies it once the directory has been filled in. An
+// existing directory is reused, and is ErrExists unless opts.Overwrite is
+// set.
+func Dir(entry catalog.Entry, target string, opts Options) error {
+	if info, err := os.Lstat(target); err == nil {
+		if !opts.Overwrite {
+			return ErrExists
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+// Symlink creates the link recorded by entry at target. The link is made
+// as recorded, even if it points outside the destination.
+func Symlink(entry catalog.Entry, target string, opts Options) error {
+	if err := replace(target, opts); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Symlink(entry.Target, target); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+	return fsmeta.Apply(target, entry)
+}
+
+// File materializes a single entry at target and reports which method was
+// used.
+func File(store *storage.Store, entry catalog.Entry, target string, opts Options) (string, error) {
+	if err := replace(target, opts); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if entry.Mode == 0 {
+		entry.Mode = 0644
+	}
+
+	for _, method := range methods(opts.Mode, entry) {
+		var err error
+		switch method {
+		case ModeHardlink:
+			err = hardlink(store, entry, target, opts.Mode == ModeHardlink)
+		case ModeReflink:
+			err = reflink(store, entry, target)
+		case ModeCopy:
+			err = copyBlob(store, entry, target)
+		}
+		if err == nil {
+			return method, restore(target, entry, method)
+		}
+		if method == ModeCopy {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("no checkout method succeeded")
+}
+
+// replace clears the way for a new entry at target, or returns ErrExists if
+// something is there and opts.Overwrite is not set. A directory is only
+// removed if it is empty.
+func replace(target string, opts Options) error {
+	if _, err := os.Lstat(target); err != nil {
+		return nil
+	}
+	if !opts.Overwrite {
+		return ErrExists
+	}
+	if err := os.Remove(target); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	return nil
+}
+
+// methods lists what to try in order. A hardlink shares the stored object's
+// inode, so it is only used when the recorded mode is exactly the object's
+// read-only one and there are no extended attributes: anything else would
+// have to be set on the object itself.
+func methods(mode string, entry catalog.Entry) []string {
+	canLink := fsmeta.FileMode(entry.Mode) == objectPerm && len(entry.Xattrs) == 0
+
+	switch mode {
+	case ModeHardlink:
+		if canLink {
+			return []string{ModeHardlink, ModeCopy}
+		}
+		return []string{ModeCopy}
+	case ModeReflink:
+		return []string{ModeReflink, ModeCopy}
+	case ModeCopy:
+		return []string{ModeCopy}
+	default:
+		if canLink {
+			return []string{ModeReflink, ModeHardlink, ModeCopy}
+		}
+		return []string{ModeReflink, ModeCopy}
+	}
+}
+
+// hardlink links target to the stored object. A link shares the object's
+// owner and modification time, and setting either would change them for
+// every other entry with this content; gc's grace period also reads the
+// object's time. So unless forced, only an object that already has the
+// recorded ones is linked.
+func hardlink(store *storage.Store, entry catalog.Entry, target string, force bool) error {
+	src, ok := store.ObjectPath(entry.Hash)
+	if !ok {
+		return errNotSupported
+	}
+
+	if !force {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if !sharesMetadata(info, entry) {
+			return errNotSupported
+		}
+	}
+
+	return os.Link(src, target)
+}
+
+// sharesMetadata reports whether an object already has the modification
+// time and owner recorded for entry.
+func sharesMetadata(info os.FileInfo, entry catalog.Entry) bool {
+	if !entry.ModTime.IsZero() && !info.ModTime().Equal(entry.ModTime) {
+		return false
+	}
+	if fsmeta.RestoresOwner() {
+		if uid, gid := fsmeta.Owner(info); uid != entry.UID || gid != entry.GID {
+			return false
+		}
+	}
+	return true
+}
+
+func reflink(store *storage.Store, entry catalog.Entry, target string) error {
+	src, ok := store.ObjectPath(entry.Hash)
+	if !ok {
+		return errNotSupported
+	}
+
+	if err := cloneFile(src, target); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
+
+func copyBlob(store *storage.Store, entry catalog.Entry, target string) error {
+	rc, err := store.OpenBlob(entry.Hash)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".checkout-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+// restore applies the recorded metadata. A hardlink is left as it is, since
+// its metadata is the shared object's.
+func restore(target string, entry catalog.Entry, method string) error {
+	if method == ModeHardlink {
+		return nil
+	}
+	return fsmeta.Apply(target, entry)
+}
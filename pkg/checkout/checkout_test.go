@@ -0,0 +1,295 @@
+package checkout
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/fsmeta"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func newStore(t *testing.T, opts storage.Options) *storage.Store {
+	t.Helper()
+
+	backend, err := storage.NewFSBackend(filepath.Join(t.TempDir(), "objects"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+	return storage.NewStore(backend, opts)
+}
+
+func addEntry(t *testing.T, store *storage.Store, path string, data []byte, mode uint32) catalog.Entry {
+	t.Helper()
+
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	return catalog.Entry{
+		Filepath: path,
+		Hash:     hash,
+		Filesize: uint64(len(data)),
+		ModTime:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Mode:     mode,
+	}
+}
+
+func TestRun_HardlinksReadOnlyFiles(t *testing.T) {
+	store := newStore(t, storage.Options{})
+	entry := addEntry(t, store, "docs/readme.txt", []byte("read only"), 0444)
+	dest := t.TempDir()
+
+	object, ok := store.ObjectPath(entry.Hash)
+	if !ok {
+		t.Fatal("ObjectPath() found no loose object")
+	}
+	objectInfo, _ := os.Stat(object)
+	entry.ModTime = objectInfo.ModTime()
+
+	result, err := Run(store, []catalog.Entry{entry}, dest, Options{Mode: ModeHardlink})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Hardlinked != 1 || result.Copied != 0 {
+		t.Fatalf("Run() = %+v, want one hardlink", result)
+	}
+
+	linkInfo, err := os.Stat(filepath.Join(dest, "docs", "readme.txt"))
+	if err != nil {
+		t.Fatalf("checked out file missing: %v", err)
+	}
+	if !os.SameFile(objectInfo, linkInfo) {
+		t.Error("checked out file is not a hardlink to the object")
+	}
+	if !linkInfo.ModTime().Equal(entry.ModTime) {
+		t.Errorf("ModTime = %v, want %v", linkInfo.ModTime(), entry.ModTime)
+	}
+}
+
+func TestRun_CopiesWritableFiles(t *testing.T) {
+	for _, mode := range []uint32{0640, 0644, 0664} {
+		store := newStore(t, storage.Options{})
+		entry := addEntry(t, store, "notes.txt", []byte("editable"), mode)
+		dest := t.TempDir()
+
+		if object, ok := store.ObjectPath(entry.Hash); ok {
+			objectInfo, _ := os.Stat(object)
+			entry.ModTime = objectInfo.ModTime()
+		}
+
+		result, err := Run(store, []catalog.Entry{entry}, dest, Options{Mode: ModeHardlink})
+		if err != nil {
+			t.Fatalf("Run(%o) error: %v", mode, err)
+		}
+		if result.Copied != 1 || result.Hardlinked != 0 {
+			t.Fatalf("Run(%o) = %+v, want one copy", mode, result)
+		}
+
+		target := filepath.Join(dest, "notes.txt")
+		info, err := os.Stat(target)
+		if err != nil {
+			t.Fatalf("checked out file missing: %v", err)
+		}
+		if info.Mode().Perm() != os.FileMode(mode) {
+			t.Errorf("Mode = %v, want %o", info.Mode().Perm(), mode)
+		}
+		if !info.ModTime().Equal(entry.ModTime) {
+			t.Errorf("ModTime = %v, want %v", info.ModTime(), entry.ModTime)
+		}
+
+		if err := os.WriteFile(target, []byte("changed"), 0640); err != nil {
+			t.Fatalf("os.WriteFile() error: %v", err)
+		}
+		if data, _ := store.ReadBlob(entry.Hash); string(data) != "editable" {
+			t.Errorf("editing the checked out %o file changed the stored object", mode)
+		}
+	}
+}
+
+func TestRun_AutoSkipsLinkWhenModTimeDiffers(t *testing.T) {
+	store := newStore(t, storage.Options{})
+	entry := addEntry(t, store, "readme.txt", []byte("read only"), 0444)
+	dest := t.TempDir()
+
+	object, ok := store.ObjectPath(entry.Hash)
+	if !ok {
+		t.Fatal("ObjectPath() found no loose object")
+	}
+	before, _ := os.Stat(object)
+
+	result, err := Run(store, []catalog.Entry{entry}, dest, Options{Mode: ModeAuto})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Hardlinked != 0 {
+		t.Fatalf("Run() = %+v, want no hardlink", result)
+	}
+
+	after, _ := os.Stat(object)
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("object ModTime changed from %v to %v", before.ModTime(), after.ModTime())
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "readme.txt")); !info.ModTime().Equal(entry.ModTime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime(), entry.ModTime)
+	}
+}
+
+func TestRun_HardlinksAddedFiles(t *testing.T) {
+	store := newStore(t, storage.Options{})
+	dest := t.TempDir()
+
+	// Record the file the way cas add does, so its time is the file's and
+	// not the object's.
+	src := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(src, []byte("final figures"), 0444); err != nil {
+		t.Fatalf("os.WriteFile() error: %v", err)
+	}
+	past := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(src, past, past)
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		t.Fatalf("os.Lstat() error: %v", err)
+	}
+	entry, err := fsmeta.Capture(src, info)
+	if err != nil {
+		t.Fatalf("Capture() error: %v", err)
+	}
+	entry.Filepath = "report.txt"
+	file, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("os.Open() error: %v", err)
+	}
+	entry.Hash, err = store.WriteBlobStream(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	result, err := Run(store, []catalog.Entry{entry}, dest, Options{Mode: ModeHardlink})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Hardlinked != 1 || result.Copied != 0 {
+		t.Fatalf("Run() = %+v, want one hardlink", result)
+	}
+	if len(result.Unrestored) != 1 || result.Unrestored[0] != "report.txt" {
+		t.Errorf("Unrestored = %v, want report.txt", result.Unrestored)
+	}
+
+	object, _ := store.ObjectPath(entry.Hash)
+	objectInfo, _ := os.Stat(object)
+	if objectInfo.ModTime().Equal(past) {
+		t.Error("hardlinking set the recorded time on the shared object")
+	}
+}
+
+func TestRun_CopiesCompressedObjects(t *testing.T) {
+	store := newStore(t, storage.Options{Compression: storage.CompressionGzip})
+	data := bytes.Repeat([]byte("compressible "), 1000)
+	entry := addEntry(t, store, "big.txt", data, 0444)
+	dest := t.TempDir()
+
+	result, err := Run(store, []catalog.Entry{entry}, dest, Options{Mode: ModeAuto})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Copied != 1 {
+		t.Fatalf("Run() = %+v, want one copy", result)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "big.txt"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("checked out content differs: %v", err)
+	}
+}
+
+func TestRun_SkipsExistingFiles(t *testing.T) {
+	store := newStore(t, storage.Options{})
+	entry := addEntry(t, store, "a.txt", []byte("stored"), 0644)
+	dest := t.TempDir()
+
+	target := filepath.Join(dest, "a.txt")
+	os.WriteFile(target, []byte("local"), 0644)
+
+	result, err := Run(store, []catalog.Entry{entry}, dest, Options{})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Skipped != 1 {
+		t.Fatalf("Run() = %+v, want one skipped", result)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "local" {
+		t.Error("existing file was overwritten")
+	}
+
+	if _, err := Run(store, []catalog.Entry{entry}, dest, Options{Overwrite: true}); err != nil {
+		t.Fatalf("Run(Overwrite) error: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "stored" {
+		t.Error("Overwrite did not replace the file")
+	}
+}
+
+func TestTarget_RejectsEscapes(t *testing.T) {
+	dest := t.TempDir()
+
+	for _, path := range []string{"../outside", "a/../../outside", ".."} {
+		if _, err := Target(dest, path); err == nil {
+			t.Errorf("Target(%q) accepted a path outside the destination", path)
+		}
+	}
+
+	got, err := Target(dest, "/abs/file.txt")
+	if err != nil || got != filepath.Join(dest, "abs", "file.txt") {
+		t.Errorf("Target(/abs/file.txt) = %q, %v", got, err)
+	}
+}
+
+func TestRun_RestoresDirsAndSymlinks(t *testing.T) {
+	store := newStore(t, storage.Options{})
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	dir := addEntry(t, store, "bin", nil, 0555)
+	dir.Type = catalog.TypeDir
+	empty := addEntry(t, store, "bin/empty", nil, 0700)
+	empty.Type = catalog.TypeDir
+	file := addEntry(t, store, "bin/run", []byte("#!/bin/sh\n"), 0755)
+	link := addEntry(t, store, "bin/latest", []byte("run"), 0777)
+	link.Type = catalog.TypeSymlink
+	link.Target = "run"
+
+	dest := t.TempDir()
+	t.Cleanup(func() { os.Chmod(filepath.Join(dest, "bin"), 0755) })
+
+	result, err := Run(store, []catalog.Entry{dir, empty, link, file}, dest, Options{})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Dirs != 2 || result.Symlinks != 1 || result.Copied != 1 {
+		t.Fatalf("Run() = %+v, want two dirs, one symlink and one copy", result)
+	}
+
+	target, err := os.Readlink(filepath.Join(dest, "bin", "latest"))
+	if err != nil || target != "run" {
+		t.Errorf("Readlink() = %q, %v, want run", target, err)
+	}
+
+	for path, perm := range map[string]os.FileMode{"bin": 0555, "bin/empty": 0700} {
+		info, err := os.Stat(filepath.Join(dest, path))
+		if err != nil || !info.IsDir() {
+			t.Fatalf("%s not checked out as a directory: %v", path, err)
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("%s Mode = %v, want %v", path, info.Mode().Perm(), perm)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s ModTime = %v, want %v", path, info.ModTime(), modTime)
+		}
+	}
+}
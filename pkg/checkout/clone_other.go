@@ -0,0 +1,7 @@
+//go:build !linux
+
+package checkout
+
+func cloneFile(src, dst string) error {
+	return errNotSupported
+}
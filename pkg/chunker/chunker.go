@@ -0,0 +1,142 @@
+package chunker
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	DefaultAvgSize = 1 << 20
+
+	minAvgSize = 256
+)
+
+var ErrInvalidSize = errors.New("average chunk size must be a power of two of at least 256 bytes")
+
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed keeps the table, and therefore every chunk
+	// boundary, identical across builds.
+	seed := uint64(0x6d696e692d434153)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+type Chunker struct {
+	reader  io.Reader
+	buf     []byte
+	start   int
+	end     int
+	eof     bool
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64
+	maskL   uint64
+}
+
+func New(reader io.Reader, avgSize int) (*Chunker, error) {
+	if avgSize < minAvgSize || avgSize&(avgSize-1) != 0 {
+		return nil, ErrInvalidSize
+	}
+
+	avgBits := bits.TrailingZeros(uint(avgSize))
+
+	return &Chunker{
+		reader:  reader,
+		buf:     make([]byte, avgSize*8),
+		minSize: avgSize / 4,
+		avgSize: avgSize,
+		maxSize: avgSize * 4,
+		maskS:   highMask(avgBits + 2),
+		maskL:   highMask(avgBits - 2),
+	}, nil
+}
+
+func highMask(n int) uint64 {
+	return ((uint64(1) << n) - 1) << (64 - n)
+}
+
+// Next returns the next chunk of the stream, or io.EOF once the stream is
+// exhausted. The returned slice is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+
+	return chunk, nil
+}
+
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.maxSize {
+		return nil
+	}
+
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+
+	normal := c.avgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.minSize
+
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i
+		}
+	}
+
+	return n
+}
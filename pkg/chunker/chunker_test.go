@@ -0,0 +1,105 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte, avgSize int) [][]byte {
+	t.Helper()
+
+	c, err := New(bytes.NewReader(data), avgSize)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+
+	return chunks
+}
+
+func TestChunker_Reassembles(t *testing.T) {
+	data := randomData(200*1024, 1)
+
+	chunks := chunkAll(t, data, 4096)
+
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Error("concatenated chunks differ from input")
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk) > 4*4096 {
+			t.Errorf("chunk %d is %d bytes, exceeds max", i, len(chunk))
+		}
+		if i < len(chunks)-1 && len(chunk) < 4096/4 {
+			t.Errorf("chunk %d is %d bytes, below min", i, len(chunk))
+		}
+	}
+}
+
+func TestChunker_BoundariesSurviveEdit(t *testing.T) {
+	original := randomData(256*1024, 2)
+
+	edited := bytes.Clone(original)
+	edited[100*1024] ^= 0xff
+
+	hashes := func(chunks [][]byte) map[[32]byte]bool {
+		set := make(map[[32]byte]bool)
+		for _, chunk := range chunks {
+			set[sha256.Sum256(chunk)] = true
+		}
+		return set
+	}
+
+	before := hashes(chunkAll(t, original, 4096))
+	after := chunkAll(t, edited, 4096)
+
+	shared := 0
+	for _, chunk := range after {
+		if before[sha256.Sum256(chunk)] {
+			shared++
+		}
+	}
+
+	if shared < len(after)-2 {
+		t.Errorf("only %d of %d chunks shared after a one-byte edit", shared, len(after))
+	}
+}
+
+func TestChunker_Empty(t *testing.T) {
+	if chunks := chunkAll(t, nil, 4096); len(chunks) != 0 {
+		t.Errorf("got %d chunks for empty input, want 0", len(chunks))
+	}
+}
+
+func TestNew_InvalidSize(t *testing.T) {
+	for _, size := range []int{0, 100, 3000} {
+		if _, err := New(bytes.NewReader(nil), size); !errors.Is(err, ErrInvalidSize) {
+			t.Errorf("New(%d) error = %v, want ErrInvalidSize", size, err)
+		}
+	}
+}
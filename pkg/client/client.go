@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
 )
@@ -16,6 +17,9 @@ type Client interface {
 type BlobOperations interface {
 	Upload(ctx context.Context, reader io.Reader) (string, error)
 	Download(ctx context.Context, hash string) (io.ReadCloser, error)
+	// DownloadRange reads length bytes from offset, verified against the
+	// blob's Merkle outboard.
+	DownloadRange(ctx context.Context, hash string, offset, length int64) (io.ReadCloser, error)
 	Stat(ctx context.Context, hash string) (BlobInfo, error)
 	Exists(ctx context.Context, hash string) (bool, error)
 }
@@ -23,12 +27,25 @@ type BlobOperations interface {
 type CatalogOperations interface {
 	GetCatalog(ctx context.Context) ([]catalog.Entry, error)
 	GetEntry(ctx context.Context, filepath string) (catalog.Entry, error)
+	// GetEntryVersion returns a numbered version of a path, and GetEntryAt
+	// the version it had at a point in time.
+	GetEntryVersion(ctx context.Context, filepath string, version int) (catalog.Version, error)
+	GetEntryAt(ctx context.Context, filepath string, at time.Time) (catalog.Version, error)
+	// History lists the kept versions of a path, newest first.
+	History(ctx context.Context, filepath string) ([]catalog.Version, error)
 	AddEntry(ctx context.Context, entry catalog.Entry) error
+	// DeleteEntry removes a path, or with recursive every entry under it,
+	// and RenameEntry moves a path or directory; both return how many
+	// entries they changed.
+	DeleteEntry(ctx context.Context, filepath string, recursive bool) (int, error)
+	RenameEntry(ctx context.Context, from, to string) (int, error)
+	Stats(ctx context.Context) (catalog.Stats, error)
 	SaveCatalog(ctx context.Context) error
 }
 
 type BlobInfo struct {
-	Hash   string
-	Size   int64
-	Exists bool
+	Hash       string
+	Size       int64
+	StoredSize int64
+	Exists     bool
 }
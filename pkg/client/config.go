@@ -9,11 +9,20 @@ type Config struct {
 	ServerURL string
 	AuthToken string
 	CASDir    string
+	// RootsFile keeps the Merkle roots an HTTP client trusts for range
+	// downloads; see HTTPClient.SetRootsFile.
+	RootsFile string
 }
 
 func NewClient(cfg Config) (Client, error) {
 	if cfg.ServerURL != "" {
-		return NewHTTPClient(cfg.ServerURL, cfg.AuthToken), nil
+		c := NewHTTPClient(cfg.ServerURL, cfg.AuthToken)
+		if cfg.RootsFile != "" {
+			if err := c.SetRootsFile(cfg.RootsFile); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
 	}
 
 	if cfg.CASDir == "" {
@@ -28,6 +37,7 @@ func NewClientFromEnv() (Client, error) {
 		ServerURL: os.Getenv("CAS_SERVER_URL"),
 		AuthToken: os.Getenv("CAS_AUTH_TOKEN"),
 		CASDir:    os.Getenv("CAS_DIR"),
+		RootsFile: os.Getenv("CAS_TRUSTED_ROOTS"),
 	}
 
 	if cfg.CASDir == "" && cfg.ServerURL == "" {
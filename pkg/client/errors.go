@@ -8,8 +8,18 @@ import (
 var (
 	ErrBlobNotFound        = errors.New("blob not found")
 	ErrEntryNotFound       = errors.New("catalog entry not found")
+	ErrVersionNotFound     = errors.New("catalog entry version not found")
+	ErrEntryExists         = errors.New("catalog entry already exists")
+	ErrIsDirectory         = errors.New("catalog path is a directory")
+	ErrEntryHeld           = errors.New("catalog entry is under a retention or legal hold")
+	ErrRejected            = errors.New("write rejected by a repository hook")
 	ErrCatalogNotSupported = errors.New("catalog operations not supported")
 	ErrInvalidHash         = errors.New("invalid hash format")
+	ErrInvalidRange        = errors.New("range outside the blob")
+	ErrVerificationFailed  = errors.New("downloaded range failed merkle verification")
+	// ErrUntrustedRoot is returned for a partial range of a blob whose
+	// Merkle root the client has no trusted value for.
+	ErrUntrustedRoot = errors.New("no trusted merkle root for blob")
 )
 
 type HTTPError struct {
@@ -3,20 +3,31 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
 type HTTPClient struct {
 	baseURL   string
 	authToken string
 	client    *http.Client
+
+	mu sync.Mutex
+	// roots holds the Merkle roots DownloadRange trusts, by blob digest.
+	roots map[string]string
+	// rootsFile, when set, keeps roots across clients.
+	rootsFile string
 }
 
 func NewHTTPClient(baseURL, authToken string) *HTTPClient {
@@ -34,10 +45,18 @@ func NewHTTPClient(baseURL, authToken string) *HTTPClient {
 	}
 }
 
+// Upload sends the content and returns its digest. The content's Merkle
+// root is computed on the way, and trusted for DownloadRange when the
+// server's digest matches the SHA-256 of what was sent: the uploader knows
+// the content without having to take the server's word for it.
 func (c *HTTPClient) Upload(ctx context.Context, reader io.Reader) (string, error) {
 	url := c.baseURL + "/blobs"
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, reader)
+	digest := sha256.New()
+	outboard := storage.NewOutboardWriter()
+	body := io.TeeReader(reader, io.MultiWriter(digest, outboard))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -54,6 +73,11 @@ func (c *HTTPClient) Upload(ctx context.Context, reader io.Reader) (string, erro
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusForbidden {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("%w: %s", ErrRejected, body)
+	}
+
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 		return "", &HTTPError{
@@ -71,11 +95,21 @@ func (c *HTTPClient) Upload(ctx context.Context, reader io.Reader) (string, erro
 		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
 
+	if result.Hash == objects.FormatDigest(objects.AlgoSHA256, digest.Sum(nil)) {
+		root, err := outboard.Root()
+		if err != nil {
+			return "", err
+		}
+		if err := c.TrustMerkleRoot(result.Hash, root); err != nil {
+			return "", err
+		}
+	}
+
 	return result.Hash, nil
 }
 
 func (c *HTTPClient) Download(ctx context.Context, hash string) (io.ReadCloser, error) {
-	if len(hash) != 64 {
+	if !objects.ValidDigest(hash) {
 		return nil, ErrInvalidHash
 	}
 
@@ -110,7 +144,7 @@ func (c *HTTPClient) Download(ctx context.Context, hash string) (io.ReadCloser,
 }
 
 func (c *HTTPClient) Stat(ctx context.Context, hash string) (BlobInfo, error) {
-	if len(hash) != 64 {
+	if !objects.ValidDigest(hash) {
 		return BlobInfo{}, ErrInvalidHash
 	}
 
@@ -137,9 +171,10 @@ func (c *HTTPClient) Stat(ctx context.Context, hash string) (BlobInfo, error) {
 	}
 
 	var result struct {
-		Hash   string `json:"hash"`
-		Size   int64  `json:"size"`
-		Exists bool   `json:"exists"`
+		Hash       string `json:"hash"`
+		Size       int64  `json:"size"`
+		StoredSize int64  `json:"stored_size"`
+		Exists     bool   `json:"exists"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -147,14 +182,15 @@ func (c *HTTPClient) Stat(ctx context.Context, hash string) (BlobInfo, error) {
 	}
 
 	return BlobInfo{
-		Hash:   result.Hash,
-		Size:   result.Size,
-		Exists: result.Exists,
+		Hash:       result.Hash,
+		Size:       result.Size,
+		StoredSize: result.StoredSize,
+		Exists:     result.Exists,
 	}, nil
 }
 
 func (c *HTTPClient) Exists(ctx context.Context, hash string) (bool, error) {
-	if len(hash) != 64 {
+	if !objects.ValidDigest(hash) {
 		return false, ErrInvalidHash
 	}
 
@@ -252,17 +288,107 @@ func (c *HTTPClient) GetEntry(ctx context.Context, filepath string) (catalog.Ent
 	return entry, nil
 }
 
+func (c *HTTPClient) GetEntryVersion(ctx context.Context, filepath string, version int) (catalog.Version, error) {
+	query := url.Values{"filepath": {filepath}, "version": {strconv.Itoa(version)}}
+	return c.getVersion(ctx, query)
+}
+
+func (c *HTTPClient) GetEntryAt(ctx context.Context, filepath string, at time.Time) (catalog.Version, error) {
+	query := url.Values{"filepath": {filepath}, "at": {at.Format(time.RFC3339Nano)}}
+	return c.getVersion(ctx, query)
+}
+
+func (c *HTTPClient) getVersion(ctx context.Context, query url.Values) (catalog.Version, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/catalog?"+query.Encode(), nil)
+	if err != nil {
+		return catalog.Version{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return catalog.Version{}, fmt.Errorf("version request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return catalog.Version{}, ErrVersionNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return catalog.Version{}, &HTTPError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var v catalog.Version
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return catalog.Version{}, fmt.Errorf("failed to parse version: %w", err)
+	}
+
+	return v, nil
+}
+
+func (c *HTTPClient) History(ctx context.Context, filepath string) ([]catalog.Version, error) {
+	reqURL := fmt.Sprintf("%s/catalog/history?filepath=%s", c.baseURL, url.QueryEscape(filepath))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("history request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrEntryNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var versions []catalog.Version
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %w", err)
+	}
+
+	return versions, nil
+}
+
 func (c *HTTPClient) AddEntry(ctx context.Context, entry catalog.Entry) error {
 	reqBody := struct {
-		Filepath string    `json:"filepath"`
-		Hash     string    `json:"hash"`
-		Size     uint64    `json:"size"`
-		Modified time.Time `json:"modified"`
+		Filepath string            `json:"filepath"`
+		Hash     string            `json:"hash"`
+		Size     uint64            `json:"size"`
+		Modified time.Time         `json:"modified"`
+		Mode     uint32            `json:"mode,omitempty"`
+		Type     string            `json:"type,omitempty"`
+		UID      uint32            `json:"uid,omitempty"`
+		GID      uint32            `json:"gid,omitempty"`
+		Target   string            `json:"target,omitempty"`
+		Xattrs   map[string][]byte `json:"xattrs,omitempty"`
 	}{
 		Filepath: entry.Filepath,
 		Hash:     entry.Hash,
 		Size:     entry.Filesize,
 		Modified: entry.ModTime,
+		Mode:     entry.Mode,
+		Type:     entry.Type,
+		UID:      entry.UID,
+		GID:      entry.GID,
+		Target:   entry.Target,
+		Xattrs:   entry.Xattrs,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -290,6 +416,15 @@ func (c *HTTPClient) AddEntry(ctx context.Context, entry catalog.Entry) error {
 		return ErrBlobNotFound
 	}
 
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %s", ErrEntryHeld, entry.Filepath)
+	}
+
+	if resp.StatusCode == http.StatusForbidden {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: %s", ErrRejected, body)
+	}
+
 	if resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 		return &HTTPError{StatusCode: resp.StatusCode, Message: string(body)}
@@ -298,6 +433,108 @@ func (c *HTTPClient) AddEntry(ctx context.Context, entry catalog.Entry) error {
 	return nil
 }
 
+func (c *HTTPClient) DeleteEntry(ctx context.Context, filepath string, recursive bool) (int, error) {
+	query := url.Values{"filepath": {filepath}}
+	if recursive {
+		query.Set("recursive", "true")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.baseURL+"/catalog?"+query.Encode(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	return c.doCatalogChange(req)
+}
+
+func (c *HTTPClient) RenameEntry(ctx context.Context, from, to string) (int, error) {
+	jsonBody, err := json.Marshal(map[string]string{"from": from, "to": to})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal rename: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", c.baseURL+"/catalog", bytes.NewReader(jsonBody))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.doCatalogChange(req)
+}
+
+// doCatalogChange sends a delete or rename and returns the number of
+// entries the server reports changing.
+func (c *HTTPClient) doCatalogChange(req *http.Request) (int, error) {
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("catalog request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		var sentinel error
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			sentinel = ErrEntryNotFound
+		case http.StatusConflict:
+			sentinel = ErrEntryHeld
+		case http.StatusPreconditionFailed:
+			sentinel = ErrEntryExists
+		case http.StatusUnprocessableEntity:
+			sentinel = ErrIsDirectory
+		case http.StatusForbidden:
+			sentinel = ErrRejected
+		default:
+			return 0, &HTTPError{StatusCode: resp.StatusCode, Message: string(body)}
+		}
+		return 0, fmt.Errorf("%w: %s", sentinel, body)
+	}
+
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return result.Count, nil
+}
+
+func (c *HTTPClient) Stats(ctx context.Context) (catalog.Stats, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/health", nil)
+	if err != nil {
+		return catalog.Stats{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return catalog.Stats{}, fmt.Errorf("health request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return catalog.Stats{}, &HTTPError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var stats catalog.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return catalog.Stats{}, fmt.Errorf("failed to parse stats: %w", err)
+	}
+
+	return stats, nil
+}
+
 func (c *HTTPClient) SaveCatalog(ctx context.Context) error {
 	return nil
 }
@@ -2,43 +2,116 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
 	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
 type LocalClient struct {
-	casDir  string
-	catalog *catalog.Catalog
-	mu      sync.RWMutex
+	casDir      string
+	store       *storage.Store
+	catalog     *catalog.Catalog
+	hooks       *hooks.Hooks
+	lockTimeout time.Duration
+	mu          sync.RWMutex
+
+	lockMu sync.Mutex
+	// lock is the shared repository lock, taken by the first write and
+	// held until Close.
+	lock *path.Lock
 }
 
 func NewLocalClient(casDir string) (*LocalClient, error) {
+	store, err := storage.Open(casDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
 	cat := catalog.NewCatalog(casDir)
 
 	if err := cat.Load(); err != nil {
+		store.Close()
 		return nil, fmt.Errorf("failed to load catalog: %w", err)
 	}
 
+	store.SetReferences(snapshot.References(cat, store))
+	store.SetHolds(cat)
+
+	cfg, err := path.LoadConfig(casDir)
+	if err != nil {
+		cat.Close()
+		store.Close()
+		return nil, err
+	}
+
+	h, err := hooks.Load(casDir)
+	if err != nil {
+		cat.Close()
+		store.Close()
+		return nil, err
+	}
+	store.SetHooks(h)
+	cat.SetHooks(h)
+	cat.SetMaxVersions(cfg.MaxVersions)
+
 	return &LocalClient{
-		casDir:  casDir,
-		catalog: cat,
+		casDir:      casDir,
+		store:       store,
+		catalog:     cat,
+		hooks:       h,
+		lockTimeout: cfg.LockTimeout(),
 	}, nil
 }
 
+// Hooks returns the write hooks of the repository, already holding its
+// configured executables, so Go code can register its own.
+func (c *LocalClient) Hooks() *hooks.Hooks {
+	return c.hooks
+}
+
+// lockShared takes the repository's shared lock on the first write and
+// keeps it until Close, so commands that need it exclusively, such as gc,
+// never run in the middle of a command: between uploading a blob and
+// recording the entry that references it, say. Reads take no lock.
+func (c *LocalClient) lockShared() error {
+	c.lockMu.Lock()
+	defer c.lockMu.Unlock()
+
+	if c.lock != nil {
+		return nil
+	}
+
+	lock, err := path.AcquireLock(c.casDir, path.LockShared, c.lockTimeout)
+	if err != nil {
+		return err
+	}
+	c.lock = lock
+	return nil
+}
+
 func (c *LocalClient) Upload(ctx context.Context, reader io.Reader) (string, error) {
 	if err := ctx.Err(); err != nil {
 		return "", err
 	}
 
-	hash, err := storage.WriteBlobStream(c.casDir, reader)
+	if err := c.lockShared(); err != nil {
+		return "", err
+	}
+
+	hash, err := c.store.WriteBlobStream(reader)
 	if err != nil {
+		if errors.Is(err, hooks.ErrVetoed) {
+			return "", fmt.Errorf("%w: %v", ErrRejected, err)
+		}
 		return "", fmt.Errorf("upload failed: %w", err)
 	}
 
@@ -50,13 +123,13 @@ func (c *LocalClient) Download(ctx context.Context, hash string) (io.ReadCloser,
 		return nil, err
 	}
 
-	if len(hash) != 64 {
+	if !objects.ValidDigest(hash) {
 		return nil, ErrInvalidHash
 	}
 
-	reader, err := storage.OpenBlob(c.casDir, hash)
+	reader, err := c.store.OpenBlob(hash)
 	if err != nil {
-		if os.IsNotExist(err) || strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, storage.ErrNotFound) {
 			return nil, ErrBlobNotFound
 		}
 		return nil, fmt.Errorf("download failed: %w", err)
@@ -65,29 +138,51 @@ func (c *LocalClient) Download(ctx context.Context, hash string) (io.ReadCloser,
 	return reader, nil
 }
 
+func (c *LocalClient) DownloadRange(ctx context.Context, hash string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !objects.ValidDigest(hash) {
+		return nil, ErrInvalidHash
+	}
+
+	reader, err := c.store.OpenVerifiedRange(hash, offset, length)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return nil, ErrBlobNotFound
+		case errors.Is(err, storage.ErrInvalidRange):
+			return nil, ErrInvalidRange
+		}
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+
+	return reader, nil
+}
+
 func (c *LocalClient) Stat(ctx context.Context, hash string) (BlobInfo, error) {
 	if err := ctx.Err(); err != nil {
 		return BlobInfo{}, err
 	}
 
-	if len(hash) != 64 {
+	if !objects.ValidDigest(hash) {
 		return BlobInfo{}, ErrInvalidHash
 	}
 
-	blobPath := filepath.Join(c.casDir, "storage", hash[:2], hash[2:4], hash)
-
-	info, err := os.Stat(blobPath)
+	info, err := c.store.Stat(hash)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, storage.ErrNotFound) {
 			return BlobInfo{Hash: hash, Exists: false}, nil
 		}
 		return BlobInfo{}, fmt.Errorf("stat failed: %w", err)
 	}
 
 	return BlobInfo{
-		Hash:   hash,
-		Size:   info.Size(),
-		Exists: true,
+		Hash:       hash,
+		Size:       info.Size,
+		StoredSize: info.StoredSize,
+		Exists:     true,
 	}, nil
 }
 
@@ -96,21 +191,16 @@ func (c *LocalClient) Exists(ctx context.Context, hash string) (bool, error) {
 		return false, err
 	}
 
-	if len(hash) != 64 {
+	if !objects.ValidDigest(hash) {
 		return false, ErrInvalidHash
 	}
 
-	blobPath := filepath.Join(c.casDir, hash[:2], hash[2:4], hash)
-
-	_, err := os.Stat(blobPath)
+	exists, err := c.store.Exists(hash)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		}
 		return false, fmt.Errorf("stat failed: %w", err)
 	}
 
-	return true, nil
+	return exists, nil
 }
 
 func (c *LocalClient) GetCatalog(ctx context.Context) ([]catalog.Entry, error) {
@@ -153,18 +243,177 @@ func (c *LocalClient) GetEntry(ctx context.Context, filepath string) (catalog.En
 	return entry, nil
 }
 
+func (c *LocalClient) GetEntryVersion(ctx context.Context, filepath string, version int) (catalog.Version, error) {
+	if err := ctx.Err(); err != nil {
+		return catalog.Version{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	v, err := c.catalog.GetVersion(filepath, version)
+	if err != nil {
+		if errors.Is(err, catalog.ErrVersionNotFound) {
+			return catalog.Version{}, ErrVersionNotFound
+		}
+		return catalog.Version{}, fmt.Errorf("failed to read version: %w", err)
+	}
+
+	return v, nil
+}
+
+func (c *LocalClient) GetEntryAt(ctx context.Context, filepath string, at time.Time) (catalog.Version, error) {
+	if err := ctx.Err(); err != nil {
+		return catalog.Version{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	v, err := c.catalog.GetEntryAt(filepath, at)
+	if err != nil {
+		if errors.Is(err, catalog.ErrVersionNotFound) {
+			return catalog.Version{}, ErrVersionNotFound
+		}
+		return catalog.Version{}, fmt.Errorf("failed to read version: %w", err)
+	}
+
+	return v, nil
+}
+
+func (c *LocalClient) History(ctx context.Context, filepath string) ([]catalog.Version, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	versions, err := c.catalog.History(filepath)
+	if err != nil {
+		return nil, ErrEntryNotFound
+	}
+
+	return versions, nil
+}
+
 func (c *LocalClient) AddEntry(ctx context.Context, entry catalog.Entry) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
 
+	if err := c.lockShared(); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.catalog.AddEntry(entry)
+	if err := c.catalog.AddEntry(entry); err != nil {
+		if errors.Is(err, catalog.ErrHeld) {
+			return fmt.Errorf("%w: %s", ErrEntryHeld, entry.Filepath)
+		}
+		if errors.Is(err, hooks.ErrVetoed) {
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+		return fmt.Errorf("failed to add entry: %w", err)
+	}
 	return nil
 }
 
+func (c *LocalClient) DeleteEntry(ctx context.Context, filepath string, recursive bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := c.lockShared(); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.catalog.DeleteEntry(filepath, recursive)
+	if err != nil {
+		return 0, catalogWriteError(err)
+	}
+	return n, nil
+}
+
+func (c *LocalClient) RenameEntry(ctx context.Context, from, to string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := c.lockShared(); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.catalog.RenameEntry(from, to)
+	if err != nil {
+		return 0, catalogWriteError(err)
+	}
+	return n, nil
+}
+
+// catalogWriteError maps a catalog error onto the client's errors, keeping
+// the catalog's message, which names the path involved.
+func catalogWriteError(err error) error {
+	for _, pair := range []struct{ from, to error }{
+		{catalog.ErrEntryNotFound, ErrEntryNotFound},
+		{catalog.ErrEntryExists, ErrEntryExists},
+		{catalog.ErrIsDirectory, ErrIsDirectory},
+		{catalog.ErrHeld, ErrEntryHeld},
+		{hooks.ErrVetoed, ErrRejected},
+	} {
+		if errors.Is(err, pair.from) {
+			return fmt.Errorf("%w: %v", pair.to, err)
+		}
+	}
+	return fmt.Errorf("failed to update catalog: %w", err)
+}
+
+func (c *LocalClient) Stats(ctx context.Context) (catalog.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return catalog.Stats{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats, err := c.catalog.Stats()
+	if err != nil {
+		return catalog.Stats{}, fmt.Errorf("failed to read catalog stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// StoredSize sums the on-disk size of every blob the catalog references.
+func (c *LocalClient) StoredSize(ctx context.Context) (int64, error) {
+	c.mu.RLock()
+	hashes, err := c.catalog.Hashes()
+	c.mu.RUnlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	var total int64
+	for _, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if info, err := c.store.Stat(hash); err == nil {
+			total += info.StoredSize
+		}
+	}
+
+	return total, nil
+}
+
 func (c *LocalClient) SaveCatalog(ctx context.Context) error {
 	if err := ctx.Err(); err != nil {
 		return err
@@ -180,6 +429,25 @@ func (c *LocalClient) SaveCatalog(ctx context.Context) error {
 	return nil
 }
 
+// CacheUsage reports quota use for size-limited repositories.
+func (c *LocalClient) CacheUsage() (storage.CacheUsage, error) {
+	return c.store.CacheUsage()
+}
+
+// InlineUsage reports the blobs kept inside the catalog database.
+func (c *LocalClient) InlineUsage() (storage.InlineUsage, error) {
+	return c.store.InlineUsage()
+}
+
 func (c *LocalClient) Close() error {
-	return c.catalog.Close()
+	c.store.Close()
+	err := c.catalog.Close()
+
+	c.lockMu.Lock()
+	defer c.lockMu.Unlock()
+	if c.lock != nil {
+		err = errors.Join(err, c.lock.Unlock())
+		c.lock = nil
+	}
+	return err
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
 )
 
 func setupTestClient(t *testing.T) (*LocalClient, string) {
@@ -178,3 +179,52 @@ func TestGetEntry_NotFound(t *testing.T) {
 		t.Errorf("GetEntry() error = %v, want ErrEntryNotFound", err)
 	}
 }
+
+func TestDeleteEntry_RenameEntry(t *testing.T) {
+	client, _ := setupTestClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+
+	if err := client.AddEntry(ctx, catalog.Entry{Filepath: "old.txt", Hash: validHash(), Filesize: 1}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+
+	if n, err := client.RenameEntry(ctx, "old.txt", "new.txt"); err != nil || n != 1 {
+		t.Fatalf("RenameEntry() = %d, %v, want 1", n, err)
+	}
+	if _, err := client.GetEntry(ctx, "new.txt"); err != nil {
+		t.Errorf("GetEntry() after rename error: %v", err)
+	}
+
+	if n, err := client.DeleteEntry(ctx, "new.txt", false); err != nil || n != 1 {
+		t.Fatalf("DeleteEntry() = %d, %v, want 1", n, err)
+	}
+	if _, err := client.DeleteEntry(ctx, "new.txt", false); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("DeleteEntry() of missing entry error = %v, want ErrEntryNotFound", err)
+	}
+}
+
+func TestLocalClient_HoldsSharedLockUntilClose(t *testing.T) {
+	client, casDir := setupTestClient(t)
+
+	if _, err := client.Upload(context.Background(), strings.NewReader("locked")); err != nil {
+		t.Fatalf("Upload() error: %v", err)
+	}
+
+	// Between the upload and the entry recording it, gc must not get in.
+	if lock, err := path.AcquireLock(casDir, path.LockExclusive, -1); err == nil {
+		lock.Unlock()
+		t.Fatal("exclusive lock taken while the client was between writes")
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	lock, err := path.AcquireLock(casDir, path.LockExclusive, -1)
+	if err != nil {
+		t.Fatalf("exclusive lock after Close() error: %v", err)
+	}
+	lock.Unlock()
+}
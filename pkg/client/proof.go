@@ -0,0 +1,369 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/merkle"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+// DownloadRange asks the server for the range widened to whole outboard
+// chunks, together with the Merkle proof nodes for them. Every chunk is
+// hashed as it arrives and the proof is checked once the last one is in: the
+// reader returns ErrVerificationFailed instead of io.EOF when it does not
+// hold, so callers must not trust the bytes before reaching EOF.
+//
+// The proof only ties the range to a Merkle root, so the root must in turn
+// be tied to hash. A range that covers the whole blob is also checked
+// against hash itself, after which its root is trusted for later ranges.
+// Any other range needs a root trusted already, through an earlier whole
+// download, an Upload of the same content, TrustMerkleRoot or the roots
+// file, and fails with ErrUntrustedRoot otherwise.
+func (c *HTTPClient) DownloadRange(ctx context.Context, hash string, offset, length int64) (io.ReadCloser, error) {
+	if !objects.ValidDigest(hash) {
+		return nil, ErrInvalidHash
+	}
+	if offset < 0 || length <= 0 {
+		return nil, ErrInvalidRange
+	}
+
+	url := fmt.Sprintf("%s/blobs/%s", c.baseURL, hash)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	req.Header.Set("X-CAS-Proof", "merkle")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download request failed: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, ErrInvalidRange
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	reader, err := newProofReader(resp, offset, length)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	if err := c.trustReader(reader, hash); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// TrustMerkleRoot records the Merkle root of a blob, as known from a source
+// other than the server, for DownloadRange to check ranges against. With a
+// roots file set the root is saved there too, and the error reports a failed
+// save.
+func (c *HTTPClient) TrustMerkleRoot(hash, root string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.roots == nil {
+		c.roots = make(map[string]string)
+	}
+	c.roots[objects.CanonicalDigest(hash)] = root
+
+	if c.rootsFile == "" {
+		return nil
+	}
+	return c.saveRoots()
+}
+
+// SetRootsFile keeps trusted Merkle roots in path, so that clients sharing
+// it trust every root one of them has checked. Roots already in the file are
+// loaded, and any the client trusted before are added to it.
+func (c *HTTPClient) SetRootsFile(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rootsFile = path
+	if err := c.loadRoots(); err != nil {
+		return err
+	}
+	if len(c.roots) == 0 {
+		return nil
+	}
+	return c.saveRoots()
+}
+
+func (c *HTTPClient) trustedRoot(hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	digest := objects.CanonicalDigest(hash)
+	if root, ok := c.roots[digest]; ok || c.rootsFile == "" {
+		return root, ok
+	}
+
+	// Another client sharing the file may have checked the root since it
+	// was last read.
+	if err := c.loadRoots(); err != nil {
+		return "", false
+	}
+	root, ok := c.roots[digest]
+	return root, ok
+}
+
+// loadRoots adds the roots in the roots file to those held in memory. A
+// missing file holds none. The caller must hold c.mu.
+func (c *HTTPClient) loadRoots() error {
+	data, err := os.ReadFile(c.rootsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read trusted roots: %w", err)
+	}
+
+	var saved map[string]string
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse trusted roots %s: %w", c.rootsFile, err)
+	}
+
+	if c.roots == nil {
+		c.roots = make(map[string]string, len(saved))
+	}
+	for digest, root := range saved {
+		if _, ok := c.roots[digest]; !ok {
+			c.roots[digest] = root
+		}
+	}
+	return nil
+}
+
+// saveRoots merges the roots held in memory into the roots file, replacing
+// it atomically so that a reader never sees half of it. The caller must
+// hold c.mu.
+func (c *HTTPClient) saveRoots() error {
+	if err := c.loadRoots(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c.roots, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.rootsFile), ".roots-*")
+	if err != nil {
+		return fmt.Errorf("failed to save trusted roots: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save trusted roots: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save trusted roots: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.rootsFile); err != nil {
+		return fmt.Errorf("failed to save trusted roots: %w", err)
+	}
+	return nil
+}
+
+// trustReader checks the root reader will verify against, or arranges for a
+// whole blob to be checked against its digest.
+func (c *HTTPClient) trustReader(reader *ProofReader, hash string) error {
+	if root, ok := c.trustedRoot(hash); ok {
+		if reader.Root() != root {
+			return fmt.Errorf("%w: server reports merkle root %s for %s", ErrVerificationFailed, reader.Root(), objects.ShortDigest(hash))
+		}
+		return nil
+	}
+
+	if !reader.whole {
+		return fmt.Errorf("%w %s", ErrUntrustedRoot, objects.ShortDigest(hash))
+	}
+
+	algo, _, err := objects.ParseDigest(hash)
+	if err != nil {
+		return err
+	}
+	reader.digest, err = objects.NewHasher(algo)
+	if err != nil {
+		return err
+	}
+	reader.want = objects.CanonicalDigest(hash)
+	reader.algo = algo
+	reader.verified = func() error { return c.TrustMerkleRoot(hash, reader.Root()) }
+	return nil
+}
+
+type ProofReader struct {
+	body      io.ReadCloser
+	proof     *merkle.RangeProof
+	chunkSize int64
+	end       int64
+	pos       int64
+	skip      int64
+	remaining int64
+	leaves    []string
+	ready     []byte
+
+	// whole is set when the response holds the entire blob, which digest
+	// then hashes for comparison with want.
+	whole    bool
+	digest   hash.Hash
+	algo     string
+	want     string
+	verified func() error
+}
+
+func newProofReader(resp *http.Response, offset, length int64) (*ProofReader, error) {
+	start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize, err := strconv.ParseInt(resp.Header.Get("X-CAS-Merkle-Chunk-Size"), 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return nil, fmt.Errorf("response carries no merkle proof")
+	}
+
+	if start%chunkSize != 0 || start > offset || (end+1 != size && (end+1)%chunkSize != 0) {
+		return nil, fmt.Errorf("response range %d-%d is not aligned to %d byte chunks", start, end, chunkSize)
+	}
+	if end+1 < min(offset+length, size) {
+		return nil, fmt.Errorf("response range %d-%d does not cover the requested %d bytes at %d", start, end, length, offset)
+	}
+
+	leafCount := 1
+	if size > 0 {
+		leafCount = int((size + chunkSize - 1) / chunkSize)
+	}
+
+	var siblings []string
+	if header := resp.Header.Get("X-CAS-Merkle-Proof"); header != "" {
+		siblings = strings.Split(header, ",")
+	}
+
+	proof := &merkle.RangeProof{
+		First:     int(start / chunkSize),
+		Last:      int(end / chunkSize),
+		LeafCount: leafCount,
+		Siblings:  siblings,
+		RootHash:  resp.Header.Get("X-CAS-Merkle-Root"),
+	}
+
+	return &ProofReader{
+		body:      resp.Body,
+		proof:     proof,
+		chunkSize: chunkSize,
+		end:       end + 1,
+		pos:       start,
+		skip:      offset - start,
+		remaining: min(length, end+1-offset),
+		whole:     start == 0 && end+1 == size,
+	}, nil
+}
+
+// Root is the Merkle root the proof is checked against.
+func (r *ProofReader) Root() string {
+	return r.proof.RootHash
+}
+
+func (r *ProofReader) Read(p []byte) (int, error) {
+	for len(r.ready) == 0 {
+		if r.pos == r.end {
+			if !r.proof.Verify(r.leaves, storage.OutboardHash) {
+				return 0, ErrVerificationFailed
+			}
+			if r.digest != nil {
+				if objects.FormatDigest(r.algo, r.digest.Sum(nil)) != r.want {
+					return 0, ErrVerificationFailed
+				}
+				r.digest = nil
+				if err := r.verified(); err != nil {
+					return 0, err
+				}
+			}
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.ready)
+	r.ready = r.ready[n:]
+	return n, nil
+}
+
+func (r *ProofReader) next() error {
+	data := make([]byte, min(r.chunkSize, r.end-r.pos))
+	if _, err := io.ReadFull(r.body, data); err != nil {
+		return fmt.Errorf("failed to read range: %w", err)
+	}
+
+	r.leaves = append(r.leaves, storage.OutboardHash(data))
+	r.pos += int64(len(data))
+	if r.digest != nil {
+		r.digest.Write(data)
+	}
+
+	skip := min(r.skip, int64(len(data)))
+	data = data[skip:]
+	r.skip -= skip
+
+	if r.remaining < 0 {
+		return fmt.Errorf("response range does not cover the request")
+	}
+	if int64(len(data)) > r.remaining {
+		data = data[:r.remaining]
+	}
+	r.remaining -= int64(len(data))
+	r.ready = data
+
+	return nil
+}
+
+func (r *ProofReader) Close() error {
+	return r.body.Close()
+}
+
+func parseContentRange(header string) (int64, int64, int64, error) {
+	var start, end, size int64
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	if start < 0 || end < start || end >= size {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return start, end, size, nil
+}
@@ -0,0 +1,560 @@
+package fsck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+const QuarantineDir = "quarantine"
+
+const (
+	KindCorrupt     = "corrupt"
+	KindMissing     = "missing"
+	KindMisplaced   = "misplaced"
+	KindPermissions = "permissions"
+	KindTemp        = "temp"
+	KindStray       = "stray"
+	KindDegraded    = "degraded"
+)
+
+// Fetcher downloads a blob from another repository, such as a client for a
+// remote CAS server.
+type Fetcher interface {
+	Download(ctx context.Context, hash string) (io.ReadCloser, error)
+}
+
+type Options struct {
+	// QuarantineDir receives corrupt objects instead of deleting them.
+	QuarantineDir string
+	DryRun        bool
+	Remote        Fetcher
+}
+
+type Problem struct {
+	Kind   string
+	Key    string
+	Detail string
+	Fixed  bool
+}
+
+type Result struct {
+	Checked   int
+	Problems  []Problem
+	Refetched int
+}
+
+func (r *Result) add(kind, key, detail string, fixed bool) {
+	r.Problems = append(r.Problems, Problem{Kind: kind, Key: key, Detail: detail, Fixed: fixed})
+}
+
+// Unfixed counts problems that are still present after the run.
+func (r Result) Unfixed() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+type checker struct {
+	store  *storage.Store
+	cat    *catalog.Catalog
+	opts   Options
+	result Result
+	lost   map[string]bool
+}
+
+// Run checks every stored object, independently of the catalog, and then that
+// every blob the catalog or its snapshots reference is present. Layout problems on the
+// filesystem backend are repaired in place, corrupt objects are moved to the
+// quarantine directory, and missing or corrupt blobs are fetched again from
+// opts.Remote when one is given. With DryRun nothing is changed.
+func Run(ctx context.Context, store *storage.Store, cat *catalog.Catalog, opts Options) (Result, error) {
+	c := &checker{store: store, cat: cat, opts: opts, lost: make(map[string]bool)}
+
+	if loose, ok := storage.Unwrap[*storage.FSBackend](store.Backend()); ok {
+		if err := c.checkLayout(loose); err != nil {
+			return c.result, err
+		}
+	}
+
+	if err := c.checkShards(); err != nil {
+		return c.result, err
+	}
+
+	if err := c.checkObjects(); err != nil {
+		return c.result, err
+	}
+
+	if err := c.checkCatalog(); err != nil {
+		return c.result, err
+	}
+
+	if opts.Remote != nil && !opts.DryRun {
+		c.refetch(ctx)
+	}
+
+	return c.result, nil
+}
+
+// checkLayout looks for problems only visible on disk: objects outside their
+// shard, writable objects and abandoned temp files.
+func (c *checker) checkLayout(loose *storage.FSBackend) error {
+	root := loose.Root()
+	shardDepth, legacy := loose.ShardDepth()
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		depth := len(strings.Split(rel, string(filepath.Separator)))
+
+		if d.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			if depth == 1 && d.Name() == "packs" {
+				return c.checkTempFiles(path)
+			}
+			if depth > max(shardDepth, legacy) {
+				c.result.add(KindStray, rel, "unexpected directory", false)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if depth == 1 {
+			if strings.HasPrefix(d.Name(), "tmp-") {
+				return c.checkTempFile(path, d)
+			}
+			c.result.add(KindStray, rel, "unexpected file in storage root", false)
+			return nil
+		}
+
+		if depth != shardDepth+1 && (legacy == 0 || depth != legacy+1) {
+			c.result.add(KindStray, rel, "object outside a shard directory", false)
+			return nil
+		}
+
+		// Objects not yet moved by a running migration are where they
+		// should be for now.
+		key := loose.KeyFromName(d.Name())
+		want := loose.Path(key)
+		if legacy != 0 && path == loose.PathAt(key, legacy) {
+			want = path
+		}
+		if want != path {
+			c.result.add(KindMisplaced, rel, "belongs in "+relTo(root, want), c.moveMisplaced(path, want))
+			path = want
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		if info.Mode().Perm() != 0444 {
+			fixed := !c.opts.DryRun && os.Chmod(path, 0444) == nil
+			c.result.add(KindPermissions, rel, fmt.Sprintf("mode %o, want 444", info.Mode().Perm()), fixed)
+		}
+
+		return nil
+	})
+}
+
+func (c *checker) moveMisplaced(from, to string) bool {
+	if c.opts.DryRun {
+		return false
+	}
+
+	if _, err := os.Stat(to); err == nil {
+		// A copy is already in the right place; keep this one for inspection.
+		return c.quarantineFile(from)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return false
+	}
+	return os.Rename(from, to) == nil
+}
+
+func (c *checker) checkTempFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "tmp-") {
+			if err := c.checkTempFile(filepath.Join(dir, entry.Name()), entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return filepath.SkipDir
+}
+
+// checkTempFile reports temp files old enough to have been abandoned. Newer
+// ones most likely belong to a write that is still in progress.
+func (c *checker) checkTempFile(path string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil || time.Since(info.ModTime()) < storage.StaleTempAge {
+		return nil
+	}
+
+	fixed := !c.opts.DryRun && os.Remove(path) == nil
+	c.result.add(KindTemp, filepath.Base(path), "left behind by an interrupted write", fixed)
+	return nil
+}
+
+// checkShards finds objects of an erasure-coded repository with missing or
+// damaged shards and rebuilds those shards from the surviving ones. It runs
+// before the content checks so they read fully repaired objects.
+func (c *checker) checkShards() error {
+	erasure, ok := storage.Unwrap[*storage.ErasureBackend](c.store.Backend())
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	err := erasure.Walk(func(info storage.ObjectInfo) error {
+		names = append(names, info.Key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk storage: %w", err)
+	}
+
+	for _, name := range names {
+		damaged, err := erasure.Repair(name, c.opts.DryRun)
+		if err != nil {
+			detail := fmt.Sprintf("shards cannot be rebuilt: %v", err)
+			if len(damaged) > 0 {
+				detail = fmt.Sprintf("shards %v of %d missing or damaged, and rebuilding them failed: %v", damaged, len(erasure.Roots()), err)
+			}
+			c.result.add(KindDegraded, name, detail, false)
+			continue
+		}
+		if len(damaged) > 0 {
+			detail := fmt.Sprintf("shards %v of %d missing or damaged", damaged, len(erasure.Roots()))
+			c.result.add(KindDegraded, name, detail, !c.opts.DryRun)
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) checkObjects() error {
+	backend := c.store.Backend()
+
+	// Encrypted objects are walked under their on-disk names so that one
+	// that no longer decrypts is reported instead of aborting the walk.
+	physical := backend
+	resolve := func(name string) (string, error) { return name, nil }
+	if enc, ok := storage.Unwrap[*storage.EncryptedBackend](backend); ok {
+		physical = enc.Inner()
+		resolve = enc.KeyOf
+	}
+
+	var names []string
+	err := physical.Walk(func(info storage.ObjectInfo) error {
+		names = append(names, info.Key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk storage: %w", err)
+	}
+
+	for _, name := range names {
+		c.result.Checked++
+
+		key, err := resolve(name)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			c.result.add(KindCorrupt, name, c.quarantine(physical, name, "", err.Error()), false)
+			continue
+		}
+
+		hash := storage.HashFromKey(key)
+		if !objects.ValidDigest(hash) {
+			c.result.add(KindStray, key, "name is not a digest", false)
+			continue
+		}
+
+		if storage.IsOutboardKey(key) {
+			c.checkOutboard(key, hash)
+			continue
+		}
+
+		if err := c.verify(key, hash); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.result.add(KindMissing, key, err.Error(), false)
+				continue
+			}
+			c.lost[objects.CanonicalDigest(hash)] = true
+			c.result.add(KindCorrupt, key, c.quarantine(physical, name, key, err.Error()), false)
+		}
+	}
+
+	return nil
+}
+
+// checkOutboard drops a Merkle outboard that no longer matches its blob, or
+// whose blob is gone. Outboards are derived data; the next verified range
+// read builds a fresh one.
+func (c *checker) checkOutboard(key, hash string) {
+	err := c.store.VerifyOutboard(hash)
+	if err == nil {
+		return
+	}
+
+	fixed := !c.opts.DryRun && c.store.Backend().Delete(key) == nil
+	if errors.Is(err, storage.ErrNotFound) {
+		c.result.add(KindStray, key, "outboard of a missing blob", fixed)
+		return
+	}
+	c.result.add(KindCorrupt, key, err.Error(), fixed)
+}
+
+func (c *checker) verify(key, hash string) error {
+	algo, _, err := objects.ParseDigest(hash)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := objects.NewHasher(algo)
+	if err != nil {
+		return err
+	}
+
+	rc, err := c.store.OpenObject(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(hasher, rc); err != nil {
+		return err
+	}
+
+	if got := objects.FormatDigest(algo, hasher.Sum(nil)); got != objects.CanonicalDigest(hash) {
+		return fmt.Errorf("content hashes to %s", objects.ShortDigest(got))
+	}
+
+	return nil
+}
+
+// quarantine copies an object out of the backend and then deletes it, so a
+// corrupt copy can no longer satisfy deduplication or reads. The blob stays
+// lost until it is fetched again, so the problem is not counted as fixed; the
+// returned detail records whether the object was moved.
+func (c *checker) quarantine(physical storage.Backend, name, key, detail string) string {
+	if c.opts.DryRun || c.opts.QuarantineDir == "" {
+		return detail
+	}
+
+	if err := c.moveToQuarantine(physical, name, key); err != nil {
+		return detail + "; quarantine failed: " + err.Error()
+	}
+	return detail + "; quarantined"
+}
+
+func (c *checker) moveToQuarantine(physical storage.Backend, name, key string) error {
+	rc, err := physical.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(c.opts.QuarantineDir, 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(filepath.Join(c.opts.QuarantineDir, strings.ReplaceAll(name, ":", "_")))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if key != "" {
+		return c.store.DeleteObject(key)
+	}
+	return physical.Delete(name)
+}
+
+func (c *checker) quarantineFile(path string) bool {
+	if err := os.MkdirAll(c.opts.QuarantineDir, 0755); err != nil {
+		return false
+	}
+	return os.Rename(path, filepath.Join(c.opts.QuarantineDir, filepath.Base(path))) == nil
+}
+
+func (c *checker) checkCatalog() error {
+	if c.cat == nil {
+		return nil
+	}
+
+	hashes, err := c.cat.Hashes()
+	if err != nil {
+		return fmt.Errorf("failed to list catalog: %w", err)
+	}
+
+	for _, hash := range hashes {
+		if err := c.checkReferenced(hash, "referenced by the catalog"); err != nil {
+			return err
+		}
+	}
+
+	return c.checkSnapshots()
+}
+
+// checkSnapshots walks the history from HEAD, since snapshots keep blobs the
+// catalog no longer points at. A missing snapshot hides its parents, so the
+// walk stops there.
+func (c *checker) checkSnapshots() error {
+	hash, err := c.cat.Ref(snapshot.Head)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", snapshot.Head, err)
+	}
+
+	for hash != "" {
+		snap, err := snapshot.Load(c.store, hash)
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.checkReferenced(hash, "snapshot in the history")
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range snap.Entries {
+			if err := c.checkReferenced(entry.Hash, "referenced by snapshot "+objects.ShortDigest(snap.Hash)); err != nil {
+				return err
+			}
+		}
+		hash = snap.Parent
+	}
+
+	return nil
+}
+
+func (c *checker) checkReferenced(hash, detail string) error {
+	exists, err := c.store.Exists(hash)
+	if err != nil {
+		return err
+	}
+	hash = objects.CanonicalDigest(hash)
+	if !exists && !c.lost[hash] {
+		// Blobs quarantined above are already reported as corrupt.
+		c.lost[hash] = true
+		c.result.add(KindMissing, hash, detail, false)
+	}
+
+	return nil
+}
+
+func (c *checker) refetch(ctx context.Context) {
+	for hash := range c.lost {
+		if exists, _ := c.store.Exists(hash); exists {
+			continue
+		}
+
+		if err := c.fetch(ctx, hash); err != nil {
+			c.result.add(KindMissing, hash, "re-fetch failed: "+err.Error(), false)
+			continue
+		}
+
+		c.result.Refetched++
+		for i, p := range c.result.Problems {
+			if objects.CanonicalDigest(storage.HashFromKey(p.Key)) == hash {
+				c.result.Problems[i].Fixed = true
+			}
+		}
+	}
+}
+
+// fetch downloads a lost blob and stores it only once its digest is known
+// to match. The download is spooled to a temp file first: storing it before
+// checking could land on some other live blob, which a clean-up of the bad
+// download would then delete.
+func (c *checker) fetch(ctx context.Context, hash string) error {
+	algo, _, err := objects.ParseDigest(hash)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := objects.NewHasher(algo)
+	if err != nil {
+		return err
+	}
+
+	rc, err := c.opts.Remote.Download(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "cas-fetch-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), rc); err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+
+	if got := objects.FormatDigest(algo, hasher.Sum(nil)); got != hash {
+		return fmt.Errorf("remote returned content hashing to %s", objects.ShortDigest(got))
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	got, err := c.store.WriteBlobStreamAs(algo, tmp)
+	if err != nil {
+		return err
+	}
+	if got != hash {
+		return fmt.Errorf("stored content hashed to %s", objects.ShortDigest(got))
+	}
+
+	return nil
+}
+
+func relTo(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
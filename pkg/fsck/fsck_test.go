@@ -0,0 +1,376 @@
+package fsck
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+type fakeRemote map[string]string
+
+func (r fakeRemote) Download(ctx context.Context, hash string) (io.ReadCloser, error) {
+	content, ok := r[hash]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+func setupFsck(t *testing.T) (string, *catalog.Catalog, *storage.Store, *storage.FSBackend) {
+	t.Helper()
+
+	casDir := t.TempDir()
+
+	backend, err := storage.NewFSBackend(filepath.Join(casDir, "storage"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	cat := catalog.NewCatalog(casDir)
+	t.Cleanup(func() { cat.Close() })
+
+	return casDir, cat, storage.NewStore(backend, storage.Options{}), backend
+}
+
+func corrupt(t *testing.T, path string) {
+	t.Helper()
+
+	os.Chmod(path, 0644)
+	if err := os.WriteFile(path, []byte("bit rot"), 0444); err != nil {
+		t.Fatalf("os.WriteFile() error: %v", err)
+	}
+	os.Chmod(path, 0444)
+}
+
+func TestRun_CleanRepository(t *testing.T) {
+	casDir, cat, store, _ := setupFsck(t)
+
+	hash, err := store.WriteBlobStream(strings.NewReader("healthy"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: hash, ModTime: time.Now()})
+
+	result, err := Run(context.Background(), store, cat, Options{QuarantineDir: filepath.Join(casDir, QuarantineDir)})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if result.Checked != 1 || len(result.Problems) != 0 {
+		t.Errorf("Run() = %+v, want one clean object", result)
+	}
+}
+
+func TestRun_QuarantinesCorruptObjects(t *testing.T) {
+	casDir, cat, store, backend := setupFsck(t)
+	quarantine := filepath.Join(casDir, QuarantineDir)
+
+	hash, err := store.WriteBlobStream(strings.NewReader("will rot"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: hash, ModTime: time.Now()})
+	corrupt(t, backend.Path(hash))
+
+	result, err := Run(context.Background(), store, cat, Options{QuarantineDir: quarantine, DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(result.Problems) != 1 || result.Problems[0].Kind != KindCorrupt || result.Problems[0].Fixed {
+		t.Fatalf("dry run problems = %+v, want one unfixed corrupt object", result.Problems)
+	}
+	if _, err := os.Stat(backend.Path(hash)); err != nil {
+		t.Fatalf("dry run removed the object: %v", err)
+	}
+
+	result, err = Run(context.Background(), store, cat, Options{QuarantineDir: quarantine})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(result.Problems) != 1 || result.Unfixed() != 1 {
+		t.Errorf("problems = %+v, want the corrupt object reported once", result.Problems)
+	}
+
+	if _, err := os.Stat(backend.Path(hash)); !os.IsNotExist(err) {
+		t.Errorf("corrupt object still in storage: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(quarantine, hash))
+	if err != nil || string(data) != "bit rot" {
+		t.Errorf("quarantined copy = %q, %v", data, err)
+	}
+}
+
+func TestRun_RefetchesFromRemote(t *testing.T) {
+	casDir, cat, store, backend := setupFsck(t)
+
+	hash, err := store.WriteBlobStream(strings.NewReader("precious"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	missing, err := store.WriteBlobStream(strings.NewReader("lost"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: hash, ModTime: time.Now()})
+	cat.AddEntry(catalog.Entry{Filepath: "b.txt", Hash: missing, ModTime: time.Now()})
+
+	corrupt(t, backend.Path(hash))
+	os.Remove(backend.Path(missing))
+
+	remote := fakeRemote{hash: "precious", missing: "lost"}
+	result, err := Run(context.Background(), store, cat, Options{
+		QuarantineDir: filepath.Join(casDir, QuarantineDir),
+		Remote:        remote,
+	})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if result.Refetched != 2 || result.Unfixed() != 0 {
+		t.Errorf("Run() = %+v, want both blobs re-fetched", result)
+	}
+
+	data, err := store.ReadBlob(hash)
+	if err != nil || string(data) != "precious" {
+		t.Errorf("ReadBlob() = %q, %v", data, err)
+	}
+}
+
+func TestRun_RejectsWrongRemoteContent(t *testing.T) {
+	casDir, cat, store, _ := setupFsck(t)
+
+	hash := strings.Repeat("ab", 32)
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: hash, ModTime: time.Now()})
+
+	result, err := Run(context.Background(), store, cat, Options{
+		QuarantineDir: filepath.Join(casDir, QuarantineDir),
+		Remote:        fakeRemote{hash: "not the right content"},
+	})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if result.Refetched != 0 || result.Unfixed() == 0 {
+		t.Errorf("Run() = %+v, want the re-fetch rejected", result)
+	}
+
+	count := 0
+	store.Walk(func(storage.ObjectInfo) error { count++; return nil })
+	if count != 0 {
+		t.Errorf("storage has %d objects, want the bad download removed", count)
+	}
+}
+
+func TestRun_RepairsLayout(t *testing.T) {
+	casDir, cat, store, backend := setupFsck(t)
+
+	hash, err := store.WriteBlobStream(strings.NewReader("misfiled"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	right := backend.Path(hash)
+	wrong := filepath.Join(backend.Root(), "00", "00", hash)
+	os.MkdirAll(filepath.Dir(wrong), 0755)
+	if err := os.Rename(right, wrong); err != nil {
+		t.Fatalf("os.Rename() error: %v", err)
+	}
+	os.Chmod(wrong, 0644)
+
+	tmp := filepath.Join(backend.Root(), "tmp-abandoned")
+	os.WriteFile(tmp, []byte("partial"), 0600)
+	old := time.Now().Add(-2 * storage.StaleTempAge)
+	os.Chtimes(tmp, old, old)
+
+	result, err := Run(context.Background(), store, cat, Options{QuarantineDir: filepath.Join(casDir, QuarantineDir)})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	kinds := map[string]bool{}
+	for _, p := range result.Problems {
+		kinds[p.Kind] = true
+		if !p.Fixed {
+			t.Errorf("problem not fixed: %+v", p)
+		}
+	}
+	for _, kind := range []string{KindMisplaced, KindPermissions, KindTemp} {
+		if !kinds[kind] {
+			t.Errorf("no %s problem reported in %+v", kind, result.Problems)
+		}
+	}
+
+	info, err := os.Stat(right)
+	if err != nil {
+		t.Fatalf("object not moved back into place: %v", err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("permissions = %o, want 0444", info.Mode().Perm())
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("stale temp file still present")
+	}
+}
+
+func TestRun_RegeneratesErasureShards(t *testing.T) {
+	casDir := t.TempDir()
+
+	var roots []storage.Backend
+	var dirs []string
+	for _, name := range []string{"a", "b", "c"} {
+		dir := filepath.Join(casDir, "disk-"+name)
+		root, err := storage.NewFSBackend(dir)
+		if err != nil {
+			t.Fatalf("NewFSBackend() error: %v", err)
+		}
+		roots = append(roots, root)
+		dirs = append(dirs, dir)
+	}
+
+	backend, err := storage.NewErasureBackend(roots, 1, filepath.Join(casDir, "staging"))
+	if err != nil {
+		t.Fatalf("NewErasureBackend() error: %v", err)
+	}
+	store := storage.NewStore(backend, storage.Options{})
+
+	cat := catalog.NewCatalog(casDir)
+	t.Cleanup(func() { cat.Close() })
+
+	hash, err := store.WriteBlobStream(strings.NewReader("spread over three disks"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: hash, ModTime: time.Now()})
+
+	os.RemoveAll(dirs[2])
+
+	result, err := Run(context.Background(), store, cat, Options{QuarantineDir: filepath.Join(casDir, QuarantineDir)})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(result.Problems) != 1 || result.Problems[0].Kind != KindDegraded || !result.Problems[0].Fixed {
+		t.Fatalf("Run() problems = %+v, want one repaired degraded object", result.Problems)
+	}
+
+	if _, err := roots[2].Stat(hash); err != nil {
+		t.Errorf("lost shard not regenerated: %v", err)
+	}
+}
+
+func TestRun_ReportsShardsItCannotRebuild(t *testing.T) {
+	casDir := t.TempDir()
+
+	var roots []storage.Backend
+	for _, name := range []string{"a", "b", "c"} {
+		root, err := storage.NewFSBackend(filepath.Join(casDir, "disk-"+name))
+		if err != nil {
+			t.Fatalf("NewFSBackend() error: %v", err)
+		}
+		roots = append(roots, root)
+	}
+
+	backend, err := storage.NewErasureBackend(roots, 1, filepath.Join(casDir, "staging"))
+	if err != nil {
+		t.Fatalf("NewErasureBackend() error: %v", err)
+	}
+	store := storage.NewStore(backend, storage.Options{})
+
+	cat := catalog.NewCatalog(casDir)
+	t.Cleanup(func() { cat.Close() })
+
+	hash, err := store.WriteBlobStream(strings.NewReader("spread over three disks"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	for _, root := range roots[1:] {
+		if err := root.Delete(hash); err != nil {
+			t.Fatalf("Delete() error: %v", err)
+		}
+	}
+
+	result, err := Run(context.Background(), store, cat, Options{QuarantineDir: filepath.Join(casDir, QuarantineDir)})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	var reported bool
+	for _, problem := range result.Problems {
+		if problem.Kind == KindDegraded && problem.Key == hash {
+			reported = true
+			if problem.Fixed {
+				t.Errorf("problem %+v reported as fixed", problem)
+			}
+		}
+	}
+	if !reported {
+		t.Errorf("Run() problems = %+v, want the object whose shards cannot be rebuilt", result.Problems)
+	}
+}
+
+func TestRun_KeepsLiveBlobReturnedByRemote(t *testing.T) {
+	casDir, cat, store, _ := setupFsck(t)
+
+	live, err := store.WriteBlobStream(strings.NewReader("live content"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "live.txt", Hash: live, Filesize: 12, ModTime: time.Now()})
+
+	missing := strings.Repeat("cd", 32)
+	cat.AddEntry(catalog.Entry{Filepath: "missing.txt", Hash: missing, ModTime: time.Now()})
+
+	result, err := Run(context.Background(), store, cat, Options{
+		QuarantineDir: filepath.Join(casDir, QuarantineDir),
+		Remote:        fakeRemote{missing: "live content"},
+	})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Refetched != 0 || result.Unfixed() == 0 {
+		t.Errorf("Run() = %+v, want the re-fetch rejected", result)
+	}
+
+	data, err := store.ReadBlob(live)
+	if err != nil || string(data) != "live content" {
+		t.Errorf("live blob after a rejected re-fetch: %q, %v", data, err)
+	}
+}
+
+func TestRun_ReportsBlobsOnlySnapshotsReference(t *testing.T) {
+	casDir, cat, store, backend := setupFsck(t)
+	cat.SetMaxVersions(1)
+
+	old, err := store.WriteBlobStream(strings.NewReader("committed"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: old, ModTime: time.Now()})
+	if _, err := snapshot.Commit(cat, store, "alice", "first"); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	current, err := store.WriteBlobStream(strings.NewReader("current"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: current, ModTime: time.Now()})
+
+	os.Remove(backend.Path(old))
+
+	result, err := Run(context.Background(), store, cat, Options{QuarantineDir: filepath.Join(casDir, QuarantineDir)})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if len(result.Problems) != 1 || result.Problems[0].Kind != KindMissing || result.Problems[0].Key != old {
+		t.Errorf("Run() problems = %+v, want %s missing", result.Problems, old[:8])
+	}
+}
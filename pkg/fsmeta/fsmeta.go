@@ -0,0 +1,140 @@
+// Package fsmeta reads and restores the file system metadata that catalog
+// entries record beyond content: type, permission bits, ownership, symlink
+// targets and extended attributes.
+package fsmeta
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+)
+
+// Capture fills in everything about the file at path except its content
+// hash. info must come from os.Lstat, so that a symlink describes the link
+// rather than what it points at.
+func Capture(path string, info fs.FileInfo) (catalog.Entry, error) {
+	entry := catalog.Entry{
+		Filepath: path,
+		ModTime:  info.ModTime(),
+		Mode:     UnixMode(info.Mode()),
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return catalog.Entry{}, fmt.Errorf("failed to read symlink: %w", err)
+		}
+		entry.Type = catalog.TypeSymlink
+		entry.Target = target
+		entry.Filesize = uint64(len(target))
+	case info.IsDir():
+		entry.Type = catalog.TypeDir
+	case info.Mode().IsRegular():
+		entry.Type = catalog.TypeFile
+		entry.Filesize = uint64(info.Size())
+	default:
+		return catalog.Entry{}, fmt.Errorf("unsupported file type %s", info.Mode().Type())
+	}
+
+	entry.UID, entry.GID = owner(info)
+
+	xattrs, err := listXattrs(path)
+	if err != nil {
+		return catalog.Entry{}, fmt.Errorf("failed to read extended attributes: %w", err)
+	}
+	entry.Xattrs = xattrs
+
+	return entry, nil
+}
+
+// Apply restores the metadata of entry onto path, which must already exist
+// with the right type. Ownership is only restored when running as root,
+// and extended attributes the file system or the caller's privileges do not
+// allow are skipped.
+func Apply(path string, entry catalog.Entry) error {
+	if RestoresOwner() {
+		if err := os.Lchown(path, int(entry.UID), int(entry.GID)); err != nil {
+			return fmt.Errorf("failed to set owner: %w", err)
+		}
+	}
+
+	if err := setXattrs(path, entry.Xattrs); err != nil {
+		return fmt.Errorf("failed to set extended attributes: %w", err)
+	}
+
+	// Symlink permissions are meaningless on most systems, and chmod would
+	// follow the link. Changing the owner clears setuid and setgid, so the
+	// mode is set after it.
+	if entry.Type != catalog.TypeSymlink && entry.Mode != 0 {
+		if err := os.Chmod(path, FileMode(entry.Mode)); err != nil {
+			return fmt.Errorf("failed to set permissions: %w", err)
+		}
+	}
+
+	return SetTimes(path, entry)
+}
+
+// SetTimes restores the modification time, of the link itself for a
+// symlink.
+func SetTimes(path string, entry catalog.Entry) error {
+	if entry.ModTime.IsZero() {
+		return nil
+	}
+
+	var err error
+	if entry.Type == catalog.TypeSymlink {
+		err = lchtimes(path, entry)
+	} else {
+		err = os.Chtimes(path, entry.ModTime, entry.ModTime)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set modification time: %w", err)
+	}
+	return nil
+}
+
+// Owner returns the user and group owning the file info describes, or zero
+// where the system has no such notion.
+func Owner(info fs.FileInfo) (uid, gid uint32) {
+	return owner(info)
+}
+
+// RestoresOwner reports whether Apply changes ownership, which only root
+// may do.
+func RestoresOwner() bool {
+	return os.Geteuid() == 0
+}
+
+// UnixMode converts a Go file mode to the permission, setuid, setgid and
+// sticky bits of a Unix mode.
+func UnixMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// FileMode is the inverse of UnixMode.
+func FileMode(bits uint32) fs.FileMode {
+	mode := fs.FileMode(bits).Perm()
+	if bits&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,86 @@
+package fsmeta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+)
+
+func capture(t *testing.T, path string) catalog.Entry {
+	t.Helper()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("os.Lstat() error: %v", err)
+	}
+	entry, err := Capture(path, info)
+	if err != nil {
+		t.Fatalf("Capture(%s) error: %v", path, err)
+	}
+	return entry
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("os.WriteFile() error: %v", err)
+	}
+	if err := os.Chmod(file, 0755|os.ModeSetgid); err != nil {
+		t.Fatalf("os.Chmod() error: %v", err)
+	}
+	link := filepath.Join(dir, "latest")
+	if err := os.Symlink("run.sh", link); err != nil {
+		t.Fatalf("os.Symlink() error: %v", err)
+	}
+
+	if got := capture(t, file); got.Type != catalog.TypeFile || got.Mode != 02755 || got.Filesize != 10 {
+		t.Errorf("Capture(file) = %+v, want a 02755 file of 10 bytes", got)
+	}
+	if got := capture(t, link); got.Type != catalog.TypeSymlink || got.Target != "run.sh" || got.Filesize != 6 {
+		t.Errorf("Capture(symlink) = %+v, want a link to run.sh", got)
+	}
+	if got := capture(t, dir); got.Type != catalog.TypeDir {
+		t.Errorf("Capture(dir) = %+v, want a directory", got)
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("os.Mkdir() error: %v", err)
+	}
+	want := catalog.Entry{Type: catalog.TypeDir, Mode: 01750, ModTime: modTime}
+	if err := Apply(sub, want); err != nil {
+		t.Fatalf("Apply(dir) error: %v", err)
+	}
+	if got := capture(t, sub); got.Mode != want.Mode || !got.ModTime.Equal(modTime) {
+		t.Errorf("dir after Apply = %+v, want mode %o at %v", got, want.Mode, modTime)
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("sub", link); err != nil {
+		t.Fatalf("os.Symlink() error: %v", err)
+	}
+	if err := Apply(link, catalog.Entry{Type: catalog.TypeSymlink, Target: "sub", Mode: 0777, ModTime: modTime.Add(time.Hour)}); err != nil {
+		t.Fatalf("Apply(symlink) error: %v", err)
+	}
+	if got := capture(t, sub); got.Mode != want.Mode || !got.ModTime.Equal(modTime) {
+		t.Errorf("Apply(symlink) changed the directory it points at: %+v", got)
+	}
+}
+
+func TestFileMode_RoundTrip(t *testing.T) {
+	for _, bits := range []uint32{0644, 0755, 04755, 02775, 01777} {
+		if got := UnixMode(FileMode(bits)); got != bits {
+			t.Errorf("UnixMode(FileMode(%o)) = %o", bits, got)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package fsmeta
+
+import (
+	"io/fs"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+)
+
+func owner(info fs.FileInfo) (uint32, uint32) {
+	return 0, 0
+}
+
+// lchtimes leaves the link's time alone where there is no call to set it
+// without following the link.
+func lchtimes(path string, entry catalog.Entry) error {
+	return nil
+}
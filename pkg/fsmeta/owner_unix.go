@@ -0,0 +1,23 @@
+//go:build unix
+
+package fsmeta
+
+import (
+	"io/fs"
+	"syscall"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"golang.org/x/sys/unix"
+)
+
+func owner(info fs.FileInfo) (uint32, uint32) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid
+	}
+	return 0, 0
+}
+
+func lchtimes(path string, entry catalog.Entry) error {
+	ts := unix.NsecToTimespec(entry.ModTime.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fsmeta
+
+func listXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattrs(path string, xattrs map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+const DefaultGracePeriod = 24 * time.Hour
+
+type Options struct {
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+type Result struct {
+	Scanned        int
+	Live           int
+	Held           int
+	Young          int
+	Orphans        []storage.ObjectInfo
+	ReclaimedBytes int64
+}
+
+// Run deletes every stored object that neither the catalog nor a committed
+// snapshot references.
+//
+// A blob written by a concurrent add or upload exists before its catalog
+// entry does, so objects modified within the grace period are never swept.
+// Writes that deduplicate against an existing object refresh its modification
+// time, which keeps an old orphan alive once something starts referencing it
+// again. Blobs under a retention or legal hold are kept whether or not
+// anything references them; the store enforces this too, so the chunks of a
+// held blob survive as well.
+func Run(cat *catalog.Catalog, store *storage.Store, opts Options) (Result, error) {
+	var result Result
+
+	live, err := mark(cat, store)
+	if err != nil {
+		return result, err
+	}
+
+	held, err := cat.HeldHashes()
+	if err != nil {
+		return result, fmt.Errorf("failed to list held blobs: %w", err)
+	}
+	heldSet := make(map[string]bool, len(held))
+	for _, hash := range held {
+		heldSet[hash] = true
+	}
+
+	// The caller holds the repository lock exclusively, so no hold can be
+	// placed or released until gc is done.
+	thaw, err := store.FreezeHolds()
+	if err != nil {
+		return result, err
+	}
+	defer thaw()
+
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
+	var candidates []storage.ObjectInfo
+	err = store.Walk(func(info storage.ObjectInfo) error {
+		result.Scanned++
+
+		switch {
+		case live[storage.HashFromKey(info.Key)]:
+			result.Live++
+		case heldSet[storage.HashFromKey(info.Key)]:
+			result.Held++
+		case info.ModTime.After(cutoff):
+			result.Young++
+		default:
+			candidates = append(candidates, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to walk storage: %w", err)
+	}
+
+	for _, info := range candidates {
+		swept, err := sweep(cat, store, info, cutoff, opts.DryRun)
+		if errors.Is(err, storage.ErrHeld) {
+			// A chunk of a held blob.
+			result.Held++
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if !swept {
+			result.Young++
+			continue
+		}
+
+		result.Orphans = append(result.Orphans, info)
+		result.ReclaimedBytes += storedSize(info)
+	}
+
+	return result, nil
+}
+
+func storedSize(info storage.ObjectInfo) int64 {
+	if info.StoredSize > 0 {
+		return info.StoredSize
+	}
+	return info.Size
+}
+
+func mark(cat *catalog.Catalog, store *storage.Store) (map[string]bool, error) {
+	hashes, err := cat.Hashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalog: %w", err)
+	}
+
+	// Snapshots keep what the catalog used to point at. A history that
+	// cannot be read stops gc rather than leaving it to sweep old content.
+	reachable, err := snapshot.Reachable(cat, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot history: %w", err)
+	}
+	hashes = append(hashes, reachable...)
+
+	live := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		live[hash] = true
+
+		manifest, err := store.ReadManifest(hash)
+		if err != nil {
+			continue
+		}
+		for _, chunk := range manifest.Chunks {
+			live[chunk.Hash] = true
+		}
+	}
+
+	return live, nil
+}
+
+// sweep re-checks a candidate right before deleting it, since the catalog and
+// storage may have changed while the walk was running.
+func sweep(cat *catalog.Catalog, store *storage.Store, info storage.ObjectInfo, cutoff time.Time, dryRun bool) (bool, error) {
+	current, err := store.Backend().Stat(info.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", info.Key, err)
+	}
+	if current.ModTime.After(cutoff) {
+		return false, nil
+	}
+
+	referenced, err := cat.HasHash(storage.HashFromKey(info.Key))
+	if err != nil {
+		return false, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	if referenced {
+		return false, nil
+	}
+
+	if dryRun {
+		return true, nil
+	}
+
+	if err := store.DeleteObject(info.Key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if errors.Is(err, storage.ErrHeld) {
+			return false, err
+		}
+		return false, fmt.Errorf("failed to delete %s: %w", info.Key, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,289 @@
+package gc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func setupGC(t *testing.T, opts storage.Options) (*catalog.Catalog, *storage.Store, *storage.FSBackend) {
+	t.Helper()
+
+	casDir := t.TempDir()
+
+	backend, err := storage.NewFSBackend(filepath.Join(casDir, "storage"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	cat := catalog.NewCatalog(casDir)
+	t.Cleanup(func() { cat.Close() })
+
+	return cat, storage.NewStore(backend, opts), backend
+}
+
+func writeAged(t *testing.T, store *storage.Store, backend *storage.FSBackend, content string, age time.Duration) string {
+	t.Helper()
+
+	hash, err := store.WriteBlobStream(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	ageAll(t, backend, age)
+	return hash
+}
+
+func ageAll(t *testing.T, backend *storage.FSBackend, age time.Duration) {
+	t.Helper()
+
+	old := time.Now().Add(-age)
+	backend.Walk(func(info storage.ObjectInfo) error {
+		if info.ModTime.After(old) {
+			if err := os.Chtimes(backend.Path(info.Key), old, old); err != nil {
+				t.Fatalf("os.Chtimes() error: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func TestRun_DeletesOldOrphans(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{})
+
+	kept := writeAged(t, store, backend, "referenced", 48*time.Hour)
+	orphan := writeAged(t, store, backend, "orphaned", 48*time.Hour)
+	young, err := store.WriteBlobStream(strings.NewReader("just uploaded"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	if err := cat.AddEntry(catalog.Entry{Filepath: "kept.txt", Hash: kept, ModTime: time.Now()}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+
+	result, err := Run(cat, store, Options{GracePeriod: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if len(result.Orphans) != 1 || result.Orphans[0].Key != orphan {
+		t.Errorf("Run() orphans = %v, want only %s", result.Orphans, orphan)
+	}
+	if result.Live != 1 || result.Young != 1 {
+		t.Errorf("Run() live = %d, young = %d, want 1 and 1", result.Live, result.Young)
+	}
+
+	for hash, want := range map[string]bool{kept: true, orphan: false, young: true} {
+		exists, err := store.Exists(hash)
+		if err != nil {
+			t.Fatalf("Exists() error: %v", err)
+		}
+		if exists != want {
+			t.Errorf("Exists(%s) = %v, want %v", hash[:8], exists, want)
+		}
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{})
+
+	orphan := writeAged(t, store, backend, "orphaned", 48*time.Hour)
+
+	result, err := Run(cat, store, Options{GracePeriod: time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if len(result.Orphans) != 1 {
+		t.Errorf("Run() reported %d orphans, want 1", len(result.Orphans))
+	}
+
+	if exists, _ := store.Exists(orphan); !exists {
+		t.Error("dry run deleted the orphan")
+	}
+}
+
+func TestRun_DedupWriteProtectsOldOrphan(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{})
+
+	hash := writeAged(t, store, backend, "orphaned then re-added", 48*time.Hour)
+
+	// A concurrent add stores the same content but has not yet written its
+	// catalog entry when gc runs.
+	if _, err := store.WriteBlobStream(strings.NewReader("orphaned then re-added")); err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	if _, err := Run(cat, store, Options{GracePeriod: time.Hour}); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if exists, _ := store.Exists(hash); !exists {
+		t.Error("gc deleted a blob that was just re-added")
+	}
+}
+
+func TestRun_KeepsChunksOfReferencedManifest(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{Chunking: true, ChunkAvgSize: 1024})
+
+	data := make([]byte, 32*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	ageAll(t, backend, 48*time.Hour)
+
+	if err := cat.AddEntry(catalog.Entry{Filepath: "big.bin", Hash: hash, ModTime: time.Now()}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+
+	result, err := Run(cat, store, Options{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if len(result.Orphans) != 0 {
+		t.Errorf("Run() deleted %d objects of a referenced chunked blob", len(result.Orphans))
+	}
+
+	got, err := store.ReadBlob(hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("ReadBlob() after gc failed: %v", err)
+	}
+}
+
+func TestRun_KeepsHeldBlobs(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{Chunking: true, ChunkAvgSize: 1024})
+	store.SetHolds(cat)
+
+	data := make([]byte, 32*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	chunked, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	retained := writeAged(t, store, backend, "retained", 48*time.Hour)
+	orphan := writeAged(t, store, backend, "orphaned", 48*time.Hour)
+
+	if err := cat.SetRetention(catalog.HoldBlob, retained, time.Now().Add(time.Hour), ""); err != nil {
+		t.Fatalf("SetRetention() error: %v", err)
+	}
+	if err := cat.SetLegalHold(catalog.HoldBlob, chunked, true, ""); err != nil {
+		t.Fatalf("SetLegalHold() error: %v", err)
+	}
+
+	result, err := Run(cat, store, Options{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if len(result.Orphans) != 1 || result.Orphans[0].Key != orphan {
+		t.Errorf("Run() orphans = %v, want only %s", result.Orphans, orphan)
+	}
+	if result.Held == 0 {
+		t.Error("Run() reported no held objects")
+	}
+
+	if got, err := store.ReadBlob(chunked); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("ReadBlob() of held chunked blob after gc failed: %v", err)
+	}
+	if _, err := store.ReadBlob(retained); err != nil {
+		t.Errorf("ReadBlob() of retained blob after gc failed: %v", err)
+	}
+
+	if err := store.Delete(retained); !errors.Is(err, storage.ErrHeld) {
+		t.Errorf("Delete() of retained blob error = %v, want ErrHeld", err)
+	}
+}
+
+// countingHolds counts how often the store reads holds.
+type countingHolds struct {
+	storage.HoldSource
+	reads int
+}
+
+func (h *countingHolds) HeldHashes() ([]string, error) {
+	h.reads++
+	return h.HoldSource.HeldHashes()
+}
+
+func TestRun_ReadsHoldsOncePerSweep(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{})
+	holds := &countingHolds{HoldSource: cat}
+	store.SetHolds(holds)
+
+	retained := writeAged(t, store, backend, "retained", 48*time.Hour)
+	for i := range 20 {
+		writeAged(t, store, backend, fmt.Sprintf("orphan %d", i), 48*time.Hour)
+	}
+	if err := cat.SetRetention(catalog.HoldBlob, retained, time.Now().Add(time.Hour), ""); err != nil {
+		t.Fatalf("SetRetention() error: %v", err)
+	}
+
+	result, err := Run(cat, store, Options{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(result.Orphans) != 20 {
+		t.Errorf("Run() deleted %d orphans, want 20", len(result.Orphans))
+	}
+	if holds.reads != 1 {
+		t.Errorf("Run() read holds %d times, want once", holds.reads)
+	}
+
+	if err := store.Delete(retained); !errors.Is(err, storage.ErrHeld) {
+		t.Errorf("Delete() of retained blob after gc error = %v, want ErrHeld", err)
+	}
+	if holds.reads != 2 {
+		t.Errorf("Delete() after gc read holds %d times in total, want 2", holds.reads)
+	}
+}
+
+func TestRun_KeepsSnapshotContent(t *testing.T) {
+	cat, store, backend := setupGC(t, storage.Options{})
+
+	old := writeAged(t, store, backend, "first version", 48*time.Hour)
+	if err := cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: old, ModTime: time.Now()}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+
+	snap, err := snapshot.Commit(cat, store, "tester", "first")
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	current := writeAged(t, store, backend, "second version", 48*time.Hour)
+	if err := cat.AddEntry(catalog.Entry{Filepath: "a.txt", Hash: current, ModTime: time.Now()}); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+	orphan := writeAged(t, store, backend, "orphaned", 48*time.Hour)
+
+	result, err := Run(cat, store, Options{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if len(result.Orphans) != 1 || result.Orphans[0].Key != orphan {
+		t.Errorf("Run() orphans = %v, want only %s", result.Orphans, orphan)
+	}
+
+	for _, hash := range []string{snap.Hash, old} {
+		if exists, _ := store.Exists(hash); !exists {
+			t.Errorf("Run() deleted %s, which a snapshot references", hash)
+		}
+	}
+}
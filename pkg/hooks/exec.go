@@ -0,0 +1,123 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Dir is where executable hooks live inside the .cas directory. Each is
+	// named for the phase and kind it handles, such as pre-blob-written or
+	// post-entry-added; other files are ignored.
+	Dir = "hooks"
+
+	DefaultTimeout = 30 * time.Second
+)
+
+// Load returns hooks running the executables configured for a repository.
+// Post-hook failures are reported on stderr.
+func Load(casDir string) (*Hooks, error) {
+	h := New()
+	h.OnError = func(name string, event Event, err error) {
+		fmt.Fprintf(os.Stderr, "hook %s failed: %v\n", name, err)
+	}
+
+	if err := h.RegisterDir(filepath.Join(casDir, Dir), filepath.Dir(casDir)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// RegisterDir registers every executable in dir named after a phase and
+// kind. They run with workDir as their working directory and receive the
+// event as JSON on stdin; a pre hook vetoes the change by exiting non-zero.
+func (h *Hooks) RegisterDir(dir, workDir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read hooks: %w", err)
+	}
+
+	for _, entry := range entries {
+		phase, kind, ok := parseHookName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		h.register(phase, kind, entry.Name(), execHook(path, workDir, DefaultTimeout))
+	}
+
+	return nil
+}
+
+func parseHookName(name string) (Phase, Kind, bool) {
+	for _, phase := range []Phase{Pre, Post} {
+		rest, ok := strings.CutPrefix(name, string(phase)+"-")
+		if !ok {
+			continue
+		}
+		for _, kind := range Kinds {
+			if rest == string(kind) {
+				return phase, kind, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func execHook(path, workDir string, timeout time.Duration) Func {
+	return func(event Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, path)
+		cmd.Dir = workDir
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Env = append(os.Environ(),
+			"CAS_HOOK_PHASE="+string(event.Phase),
+			"CAS_HOOK_EVENT="+string(event.Kind),
+		)
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("timed out after %v", timeout)
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("%v: %s", err, truncate(msg, 512))
+			}
+			return err
+		}
+
+		return nil
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,137 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kind names something that happened to the repository.
+type Kind string
+
+const (
+	// BlobWritten is a blob stored for the first time.
+	BlobWritten Kind = "blob-written"
+	// BlobDeduplicated is a write of content that was already stored.
+	BlobDeduplicated Kind = "blob-deduplicated"
+	// EntryAdded is a catalog path recorded for the first time.
+	EntryAdded Kind = "entry-added"
+	// EntryReplaced is a catalog path recorded again, possibly with other
+	// content.
+	EntryReplaced Kind = "entry-replaced"
+	// EntryDeleted is a catalog path removed from the catalog.
+	EntryDeleted Kind = "entry-deleted"
+	// EntryRenamed is a catalog path moved to another path, Path being the
+	// new one.
+	EntryRenamed Kind = "entry-renamed"
+)
+
+var Kinds = []Kind{BlobWritten, BlobDeduplicated, EntryAdded, EntryReplaced, EntryDeleted, EntryRenamed}
+
+// Phase says whether a hook runs before the change, when it can still veto
+// it, or after it has been made.
+type Phase string
+
+const (
+	Pre  Phase = "pre"
+	Post Phase = "post"
+)
+
+var ErrVetoed = errors.New("vetoed by hook")
+
+type Event struct {
+	Kind  Kind      `json:"kind"`
+	Phase Phase     `json:"phase"`
+	Time  time.Time `json:"time"`
+	Hash  string    `json:"hash,omitempty"`
+	Size  int64     `json:"size"`
+	Path  string    `json:"path,omitempty"`
+	// PreviousHash is the content a replaced entry pointed at.
+	PreviousHash string `json:"previous_hash,omitempty"`
+	// PreviousPath is where a renamed entry used to be.
+	PreviousPath string `json:"previous_path,omitempty"`
+}
+
+// Func handles an event. A pre hook returning an error stops the change;
+// a post hook's error is only reported.
+type Func func(Event) error
+
+type hook struct {
+	name string
+	fn   Func
+}
+
+// Hooks dispatches events to registered functions. The zero value and a nil
+// *Hooks are both ready to use and run nothing.
+type Hooks struct {
+	mu    sync.RWMutex
+	hooks map[Phase]map[Kind][]hook
+
+	// OnError receives post-hook failures, which cannot undo the change
+	// that triggered them. Nil discards them.
+	OnError func(name string, event Event, err error)
+}
+
+func New() *Hooks {
+	return &Hooks{}
+}
+
+// Register adds fn to the hooks run for kind in phase. Hooks run in the
+// order they were registered.
+func (h *Hooks) Register(phase Phase, kind Kind, fn Func) {
+	h.register(phase, kind, fmt.Sprintf("%s-%s func", phase, kind), fn)
+}
+
+func (h *Hooks) register(phase Phase, kind Kind, name string, fn Func) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.hooks == nil {
+		h.hooks = make(map[Phase]map[Kind][]hook)
+	}
+	if h.hooks[phase] == nil {
+		h.hooks[phase] = make(map[Kind][]hook)
+	}
+	h.hooks[phase][kind] = append(h.hooks[phase][kind], hook{name: name, fn: fn})
+}
+
+func (h *Hooks) registered(phase Phase, kind Kind) []hook {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.hooks[phase][kind]
+}
+
+// Pre runs the pre hooks for an event, stopping at the first that fails.
+// The error wraps ErrVetoed.
+func (h *Hooks) Pre(event Event) error {
+	event.Phase = Pre
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, hk := range h.registered(Pre, event.Kind) {
+		if err := hk.fn(event); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrVetoed, hk.name, err)
+		}
+	}
+	return nil
+}
+
+// Post runs every post hook for an event, passing failures to OnError.
+func (h *Hooks) Post(event Event) {
+	event.Phase = Post
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, hk := range h.registered(Post, event.Kind) {
+		if err := hk.fn(event); err != nil && h.OnError != nil {
+			h.OnError(hk.name, event, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package hooks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHooks_PreVetoesAndPostReports(t *testing.T) {
+	h := New()
+
+	var seen []Phase
+	h.Register(Pre, BlobWritten, func(e Event) error {
+		seen = append(seen, e.Phase)
+		if e.Size > 10 {
+			return errors.New("too big")
+		}
+		return nil
+	})
+	h.Register(Post, BlobWritten, func(e Event) error {
+		seen = append(seen, e.Phase)
+		return errors.New("indexer down")
+	})
+
+	var reported error
+	h.OnError = func(name string, e Event, err error) { reported = err }
+
+	if err := h.Pre(Event{Kind: BlobWritten, Size: 5}); err != nil {
+		t.Errorf("Pre() error: %v", err)
+	}
+	if err := h.Pre(Event{Kind: BlobWritten, Size: 50}); !errors.Is(err, ErrVetoed) {
+		t.Errorf("Pre() error = %v, want ErrVetoed", err)
+	}
+	if err := h.Pre(Event{Kind: EntryAdded, Size: 50}); err != nil {
+		t.Errorf("Pre() for another kind error: %v", err)
+	}
+
+	h.Post(Event{Kind: BlobWritten})
+	if reported == nil {
+		t.Error("Post() did not report the failing hook")
+	}
+
+	if len(seen) != 3 || seen[2] != Post {
+		t.Errorf("hooks saw phases %v, want pre, pre, post", seen)
+	}
+
+	var none *Hooks
+	if err := none.Pre(Event{Kind: BlobWritten}); err != nil {
+		t.Errorf("nil Hooks Pre() error: %v", err)
+	}
+	none.Post(Event{Kind: BlobWritten})
+}
+
+func TestLoad_RunsExecutables(t *testing.T) {
+	root := t.TempDir()
+	casDir := filepath.Join(root, ".cas")
+	hookDir := filepath.Join(casDir, Dir)
+	if err := os.MkdirAll(hookDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() error: %v", err)
+	}
+
+	out := filepath.Join(root, "events.json")
+	scripts := map[string]string{
+		"post-entry-added":       "#!/bin/sh\ncat >> " + out + "\n",
+		"pre-blob-written":       "#!/bin/sh\ngrep -q '\"size\":0' && { echo 'empty blobs are not allowed' >&2; exit 1; }\nexit 0\n",
+		"pre-entry-added.sample": "#!/bin/sh\nexit 1\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(hookDir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+	}
+
+	h, err := Load(casDir)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	err = h.Pre(Event{Kind: BlobWritten, Hash: "sha256:00", Size: 0})
+	if !errors.Is(err, ErrVetoed) || !strings.Contains(err.Error(), "empty blobs are not allowed") {
+		t.Errorf("Pre() error = %v, want a veto with the hook's message", err)
+	}
+	if err := h.Pre(Event{Kind: BlobWritten, Size: 3}); err != nil {
+		t.Errorf("Pre() error: %v", err)
+	}
+
+	// Files not named for an event are not hooks.
+	if err := h.Pre(Event{Kind: EntryAdded, Path: "a.txt"}); err != nil {
+		t.Errorf("Pre() ran a sample hook: %v", err)
+	}
+
+	h.Post(Event{Kind: EntryAdded, Path: "a.txt"})
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("post hook did not run: %v", err)
+	}
+	if !strings.Contains(string(data), `"kind":"entry-added"`) || !strings.Contains(string(data), `"path":"a.txt"`) {
+		t.Errorf("post hook received %s", data)
+	}
+}
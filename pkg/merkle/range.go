@@ -0,0 +1,117 @@
+package merkle
+
+// RangeProof proves a run of consecutive leaves at once. It holds only the
+// hashes outside the run that are needed to rebuild the root: at most one on
+// each side per level, ordered from the leaves up and left before right.
+type RangeProof struct {
+	First     int
+	Last      int
+	LeafCount int
+	Siblings  []string
+	RootHash  string
+}
+
+func (t *Tree) GenerateRangeProof(first, last int) (*RangeProof, error) {
+	if t.Root == nil {
+		return nil, ErrTreeNotBuilt
+	}
+
+	if first < 0 || last < first || last >= len(t.Leaves) {
+		return nil, ErrIndexOutOfBounds
+	}
+
+	level := make([]string, len(t.Leaves))
+	for i, leaf := range t.Leaves {
+		level[i] = leaf.Hash
+	}
+
+	siblings := []string{}
+	lo, hi := first, last
+
+	for len(level) > 1 {
+		n := len(level)
+		if n%2 == 1 {
+			level = append(level, level[n-1])
+		}
+
+		if lo%2 == 1 {
+			siblings = append(siblings, level[lo-1])
+		}
+		// The duplicate of an odd level's last node is implied by the leaf
+		// count, so it is never sent.
+		if hi%2 == 0 && hi+1 < n {
+			siblings = append(siblings, level[hi+1])
+		}
+
+		next := make([]string, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next[i/2] = hashPair(level[i], level[i+1], t.HashFunc)
+		}
+
+		level = next
+		lo, hi = lo/2, hi/2
+	}
+
+	return &RangeProof{
+		First:     first,
+		Last:      last,
+		LeafCount: len(t.Leaves),
+		Siblings:  siblings,
+		RootHash:  t.Root.Hash,
+	}, nil
+}
+
+// Verify rebuilds the root from the hashes of leaves First through Last and
+// the proof's siblings.
+func (p *RangeProof) Verify(leafHashes []string, hashFunc func([]byte) string) bool {
+	if p.First < 0 || p.Last < p.First || p.Last >= p.LeafCount || len(leafHashes) != p.Last-p.First+1 {
+		return false
+	}
+
+	nodes := append([]string(nil), leafHashes...)
+	siblings := p.Siblings
+	lo, hi, n := p.First, p.Last, p.LeafCount
+
+	next := func() (string, bool) {
+		if len(siblings) == 0 {
+			return "", false
+		}
+		sibling := siblings[0]
+		siblings = siblings[1:]
+		return sibling, true
+	}
+
+	for n > 1 {
+		if lo%2 == 1 {
+			sibling, ok := next()
+			if !ok {
+				return false
+			}
+			nodes = append([]string{sibling}, nodes...)
+			lo--
+		}
+
+		if hi%2 == 0 {
+			if hi+1 < n {
+				sibling, ok := next()
+				if !ok {
+					return false
+				}
+				nodes = append(nodes, sibling)
+			} else {
+				nodes = append(nodes, nodes[len(nodes)-1])
+			}
+			hi++
+		}
+
+		parents := make([]string, len(nodes)/2)
+		for i := 0; i < len(nodes); i += 2 {
+			parents[i/2] = hashPair(nodes[i], nodes[i+1], hashFunc)
+		}
+
+		nodes = parents
+		lo, hi, n = lo/2, hi/2, (n+1)/2
+	}
+
+	return len(siblings) == 0 && len(nodes) == 1 && nodes[0] == p.RootHash
+}
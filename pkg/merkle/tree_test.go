@@ -119,3 +119,57 @@ func TestOddNumberOfLeaves(t *testing.T) {
 		}
 	}
 }
+
+func TestRangeProofAllRanges(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		hashes := make([]string, count)
+		for i := range hashes {
+			hashes[i] = fmt.Sprintf("h%d", i)
+		}
+
+		tree := NewTree(testHashFunc)
+		if err := tree.Build(hashes); err != nil {
+			t.Fatalf("Build(%d leaves) failed: %v", count, err)
+		}
+
+		for first := 0; first < count; first++ {
+			for last := first; last < count; last++ {
+				proof, err := tree.GenerateRangeProof(first, last)
+				if err != nil {
+					t.Fatalf("GenerateRangeProof(%d, %d) of %d failed: %v", first, last, count, err)
+				}
+
+				if !proof.Verify(hashes[first:last+1], testHashFunc) {
+					t.Fatalf("range proof %d-%d of %d leaves failed verification", first, last, count)
+				}
+			}
+		}
+	}
+}
+
+func TestTamperedRangeProof(t *testing.T) {
+	tree := NewTree(testHashFunc)
+	hashes := []string{"h0", "h1", "h2", "h3", "h4"}
+	tree.Build(hashes)
+
+	proof, err := tree.GenerateRangeProof(1, 2)
+	if err != nil {
+		t.Fatalf("GenerateRangeProof() failed: %v", err)
+	}
+
+	if proof.Verify([]string{"h1", "tampered"}, testHashFunc) {
+		t.Fatal("range proof verified a tampered leaf")
+	}
+	if proof.Verify([]string{"h1"}, testHashFunc) {
+		t.Fatal("range proof verified too few leaves")
+	}
+
+	proof.Siblings = proof.Siblings[1:]
+	if proof.Verify(hashes[1:3], testHashFunc) {
+		t.Fatal("range proof verified with a missing sibling")
+	}
+
+	if _, err := tree.GenerateRangeProof(3, 5); err != ErrIndexOutOfBounds {
+		t.Fatalf("expected ErrIndexOutOfBounds, got: %v", err)
+	}
+}
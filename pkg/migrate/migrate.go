@@ -0,0 +1,234 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+var ErrInProgress = errors.New("a different migration is already in progress")
+
+type Options struct {
+	// ShardDepth is the shard depth to move objects to; zero keeps it.
+	ShardDepth int
+	// Compression is the encoding to rewrite objects in; empty keeps it.
+	Compression string
+	// Pack moves every loose object into a pack, whatever its size.
+	Pack bool
+}
+
+func (o Options) empty() bool {
+	return o == Options{}
+}
+
+type Result struct {
+	// Resumed is set when the run finished a migration an earlier one
+	// started.
+	Resumed   bool
+	Moved     int
+	Reencoded int
+	Packed    int
+	// Failed lists objects that could not be rewritten, such as corrupt
+	// ones. The migration stays in progress until a later run gets them.
+	Failed []string
+	Format path.Format
+}
+
+// Run converts the repository at casDir to the layout opts describe, or,
+// with empty opts, finishes a migration left in progress.
+//
+// The target is recorded in the format file before any object is touched, so
+// readers look in both layouts from then on and an interrupted run can be
+// resumed by running again. Every step is idempotent: objects are moved or
+// rewritten one at a time and the old copy is removed only once the new one
+// is in place, so the repository stays readable throughout.
+func Run(casDir string, opts Options) (Result, error) {
+	var result Result
+
+	format, err := path.LoadFormat(casDir)
+	if err != nil {
+		return result, err
+	}
+
+	cfg, err := path.LoadConfig(casDir)
+	if err != nil {
+		return result, err
+	}
+
+	plan := format.Migration
+	if plan != nil {
+		if !opts.empty() && !matches(format, *plan, opts) {
+			return result, fmt.Errorf("%w; run cas migrate without options to finish it", ErrInProgress)
+		}
+		result.Resumed = true
+	} else {
+		plan, err = newPlan(&format, cfg, opts)
+		if err != nil || plan == nil {
+			result.Format = format
+			return result, err
+		}
+	}
+
+	if !result.Resumed {
+		if plan.Pack {
+			if err := checkPacks(casDir); err != nil {
+				return result, err
+			}
+		}
+
+		format.Migration = plan
+		if err := path.SaveFormat(casDir, format); err != nil {
+			return result, err
+		}
+	}
+
+	// New writes switch to the target encoding straight away.
+	if plan.Compression != "" && cfg.Storage.Compression != plan.Compression {
+		cfg.Storage.Compression = plan.Compression
+		if err := path.SaveConfig(casDir, cfg); err != nil {
+			return result, err
+		}
+	}
+
+	store, err := storage.Open(casDir)
+	if err != nil {
+		return result, err
+	}
+	defer store.Close()
+
+	if plan.FromShardDepth != 0 {
+		if result.Moved, err = store.Relayout(); err != nil {
+			return result, fmt.Errorf("failed to move objects: %w", err)
+		}
+	}
+
+	if plan.Compression != "" {
+		if err := reencode(store, &result); err != nil {
+			return result, err
+		}
+	}
+
+	if plan.Pack {
+		packed, err := store.Repack(math.MaxInt64)
+		if err != nil {
+			return result, fmt.Errorf("failed to pack objects: %w", err)
+		}
+		result.Packed = packed.PackedObjects
+	}
+
+	if len(result.Failed) > 0 {
+		result.Format = format
+		return result, nil
+	}
+
+	// Objects written at the old depth by a process that had not yet seen
+	// the format change are caught by one last pass.
+	if plan.FromShardDepth != 0 {
+		moved, err := store.Relayout()
+		result.Moved += moved
+		if err != nil {
+			return result, fmt.Errorf("failed to move objects: %w", err)
+		}
+	}
+
+	format.Migration = nil
+	if err := path.SaveFormat(casDir, format); err != nil {
+		return result, err
+	}
+
+	result.Format = format
+	return result, nil
+}
+
+// newPlan works out what has to change to reach opts and points format at
+// the target layout. It returns nil when the repository is already there.
+func newPlan(format *path.Format, cfg path.Config, opts Options) (*path.Migration, error) {
+	plan := &path.Migration{Pack: opts.Pack}
+
+	if opts.ShardDepth != 0 && opts.ShardDepth != format.ShardDepth {
+		if !path.ValidShardDepth(opts.ShardDepth) {
+			return nil, fmt.Errorf("shard depth must be between 1 and %d", path.MaxShardDepth)
+		}
+		plan.FromShardDepth = format.ShardDepth
+		format.ShardDepth = opts.ShardDepth
+	}
+
+	if opts.Compression != "" {
+		if !storage.ValidCompression(opts.Compression) {
+			return nil, fmt.Errorf("unknown compression: %s", opts.Compression)
+		}
+		if normalize(opts.Compression) != normalize(cfg.Storage.Compression) {
+			plan.Compression = opts.Compression
+		}
+	}
+
+	if *plan == (path.Migration{}) {
+		return nil, nil
+	}
+
+	return plan, nil
+}
+
+func checkPacks(casDir string) error {
+	store, err := storage.Open(casDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if !store.SupportsPacks() {
+		return fmt.Errorf("storage backend does not support packs")
+	}
+	return nil
+}
+
+// matches reports whether opts ask for the migration already in progress.
+func matches(format path.Format, plan path.Migration, opts Options) bool {
+	if opts.ShardDepth != 0 && opts.ShardDepth != format.ShardDepth {
+		return false
+	}
+	if opts.Compression != "" && normalize(opts.Compression) != normalize(plan.Compression) {
+		return false
+	}
+	return !opts.Pack || plan.Pack
+}
+
+func normalize(compression string) string {
+	if compression == "" {
+		return storage.CompressionNone
+	}
+	return compression
+}
+
+func reencode(store *storage.Store, result *Result) error {
+	// Keys are collected first since rewriting objects changes what a walk
+	// would see.
+	var keys []string
+	err := store.Walk(func(info storage.ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk storage: %w", err)
+	}
+
+	for _, key := range keys {
+		rewritten, err := store.Reencode(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Deleted since the walk.
+			continue
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		if rewritten {
+			result.Reencoded++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func setupRepo(t *testing.T, contents ...string) (string, []string) {
+	t.Helper()
+
+	repo, err := path.Init(t.TempDir())
+	if err != nil {
+		t.Fatalf("Init() error: %v", err)
+	}
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer store.Close()
+
+	var hashes []string
+	for _, content := range contents {
+		hash, err := store.WriteBlobStream(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("WriteBlobStream() error: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return repo.RootDir, hashes
+}
+
+func assertReadable(t *testing.T, casDir string, hashes []string, contents []string) {
+	t.Helper()
+
+	store, err := storage.Open(casDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer store.Close()
+
+	for i, hash := range hashes {
+		data, err := store.ReadBlob(hash)
+		if err != nil {
+			t.Fatalf("ReadBlob(%s) error: %v", hash, err)
+		}
+		if string(data) != contents[i] {
+			t.Errorf("ReadBlob(%s) = %q, want %q", hash, data, contents[i])
+		}
+	}
+}
+
+func TestRun_ShardDepth(t *testing.T) {
+	contents := []string{"first", "second", "third"}
+	casDir, hashes := setupRepo(t, contents...)
+
+	result, err := Run(casDir, Options{ShardDepth: 1})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	if result.Moved != len(hashes) {
+		t.Errorf("Run() moved = %d, want %d", result.Moved, len(hashes))
+	}
+	if result.Format.ShardDepth != 1 || result.Format.Migration != nil {
+		t.Errorf("Run() format = %+v, want depth 1 and no migration", result.Format)
+	}
+
+	for _, hash := range hashes {
+		want := filepath.Join(casDir, "storage", hash[:2], hash)
+		if _, err := os.Stat(want); err != nil {
+			t.Errorf("object %s not at %s: %v", hash, want, err)
+		}
+	}
+
+	// The old second-level shard directories are gone.
+	if _, err := os.Stat(filepath.Join(casDir, "storage", hashes[0][:2], hashes[0][2:4])); !os.IsNotExist(err) {
+		t.Errorf("old shard directory still exists: %v", err)
+	}
+
+	assertReadable(t, casDir, hashes, contents)
+}
+
+func TestRun_HalfMigrated(t *testing.T) {
+	contents := []string{"moved", "not moved yet"}
+	casDir, hashes := setupRepo(t, contents...)
+
+	// Simulate a run that recorded its target and moved one object before
+	// being interrupted.
+	format := path.DefaultFormat()
+	format.ShardDepth = 3
+	format.Migration = &path.Migration{FromShardDepth: path.DefaultShardDepth}
+	if err := path.SaveFormat(casDir, format); err != nil {
+		t.Fatalf("SaveFormat() error: %v", err)
+	}
+
+	hash := hashes[0]
+	from := filepath.Join(casDir, "storage", hash[:2], hash[2:4], hash)
+	to := filepath.Join(casDir, "storage", hash[:2], hash[2:4], hash[4:6], hash)
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		t.Fatalf("MkdirAll() error: %v", err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		t.Fatalf("Rename() error: %v", err)
+	}
+
+	assertReadable(t, casDir, hashes, contents)
+
+	store, err := storage.Open(casDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	seen := 0
+	store.Walk(func(storage.ObjectInfo) error {
+		seen++
+		return nil
+	})
+	store.Close()
+	if seen != len(hashes) {
+		t.Errorf("Walk() visited %d objects, want %d", seen, len(hashes))
+	}
+
+	if _, err := Run(casDir, Options{ShardDepth: 1}); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Run() with other options error = %v, want ErrInProgress", err)
+	}
+
+	result, err := Run(casDir, Options{})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !result.Resumed || result.Moved != 1 {
+		t.Errorf("Run() = %+v, want resumed with 1 moved", result)
+	}
+
+	loaded, err := path.LoadFormat(casDir)
+	if err != nil {
+		t.Fatalf("LoadFormat() error: %v", err)
+	}
+	if loaded.ShardDepth != 3 || loaded.Migration != nil {
+		t.Errorf("LoadFormat() = %+v, want depth 3 and no migration", loaded)
+	}
+
+	assertReadable(t, casDir, hashes, contents)
+}
+
+func TestRun_Compression(t *testing.T) {
+	contents := []string{strings.Repeat("compressible ", 100), "short"}
+	casDir, hashes := setupRepo(t, contents...)
+
+	result, err := Run(casDir, Options{Compression: storage.CompressionGzip})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Reencoded != len(hashes) {
+		t.Errorf("Run() reencoded = %d, want %d", result.Reencoded, len(hashes))
+	}
+
+	cfg, err := path.LoadConfig(casDir)
+	if err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	if cfg.Storage.Compression != storage.CompressionGzip {
+		t.Errorf("config compression = %q, want %q", cfg.Storage.Compression, storage.CompressionGzip)
+	}
+
+	assertReadable(t, casDir, hashes, contents)
+
+	// Running again finds nothing left to do.
+	result, err = Run(casDir, Options{Compression: storage.CompressionGzip})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Reencoded != 0 || result.Resumed {
+		t.Errorf("second Run() = %+v, want nothing done", result)
+	}
+}
+
+func TestRun_Pack(t *testing.T) {
+	contents := []string{"one", "two", "three"}
+	casDir, hashes := setupRepo(t, contents...)
+
+	result, err := Run(casDir, Options{Pack: true})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if result.Packed != len(hashes) {
+		t.Errorf("Run() packed = %d, want %d", result.Packed, len(hashes))
+	}
+
+	assertReadable(t, casDir, hashes, contents)
+}
@@ -0,0 +1,113 @@
+package objects
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+const (
+	AlgoSHA256    = "sha256"
+	AlgoSHA512256 = "sha512-256"
+	AlgoSHA1      = "sha1"
+
+	DefaultAlgorithm = AlgoSHA256
+)
+
+var digestSizes = map[string]int{
+	AlgoSHA256:    sha256.Size,
+	AlgoSHA512256: sha512.Size256,
+	AlgoSHA1:      sha1.Size,
+}
+
+func ValidAlgorithm(algo string) bool {
+	_, ok := digestSizes[algo]
+	return ok || algo == ""
+}
+
+func NewHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "", AlgoSHA256:
+		return sha256.New(), nil
+	case AlgoSHA512256:
+		return sha512.New512_256(), nil
+	case AlgoSHA1:
+		return sha1.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm: %s", algo)
+	}
+}
+
+// FormatDigest renders a digest as algo:hex. SHA-256 digests are written as
+// bare hex, which is how every repository stored them before the algorithm
+// became configurable.
+func FormatDigest(algo string, sum []byte) string {
+	if algo == "" || algo == AlgoSHA256 {
+		return hex.EncodeToString(sum)
+	}
+	return algo + ":" + hex.EncodeToString(sum)
+}
+
+// ParseDigest splits a digest into its algorithm and hex value. A bare hex
+// digest is SHA-256.
+func ParseDigest(digest string) (string, string, error) {
+	algo, value, found := strings.Cut(digest, ":")
+	if !found {
+		algo, value = AlgoSHA256, digest
+	}
+
+	size, ok := digestSizes[algo]
+	if !ok {
+		return "", "", fmt.Errorf("unknown hash algorithm: %s", algo)
+	}
+
+	if len(value) != hex.EncodedLen(size) {
+		return "", "", fmt.Errorf("%s digest must be %d hex characters", algo, hex.EncodedLen(size))
+	}
+
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", "", fmt.Errorf("digest must be lowercase hex: %s", digest)
+		}
+	}
+
+	return algo, value, nil
+}
+
+func ValidDigest(digest string) bool {
+	_, _, err := ParseDigest(digest)
+	return err == nil
+}
+
+// CanonicalDigest maps the accepted spellings of a digest onto the one used
+// for storage keys and catalog entries, so sha256:<hex> and <hex> name the
+// same blob.
+func CanonicalDigest(digest string) string {
+	if value, ok := strings.CutPrefix(digest, AlgoSHA256+":"); ok {
+		return value
+	}
+	return digest
+}
+
+// ShortDigest abbreviates a digest for display, keeping its algorithm.
+func ShortDigest(digest string) string {
+	algo, value, found := strings.Cut(digest, ":")
+	if !found {
+		return algo[:min(8, len(algo))]
+	}
+	return algo + ":" + value[:min(8, len(value))]
+}
+
+func HashWith(algo string, blob Blob) (string, error) {
+	hasher, err := NewHasher(algo)
+	if err != nil {
+		return "", err
+	}
+
+	hasher.Write(blob.Data)
+	return FormatDigest(algo, hasher.Sum(nil)), nil
+}
@@ -0,0 +1,75 @@
+package objects
+
+import "testing"
+
+func TestHashWith(t *testing.T) {
+	blob := Blob{Data: []byte("hello")}
+
+	tests := []struct {
+		algo string
+		want string
+	}{
+		{AlgoSHA256, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{AlgoSHA1, "sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+		{AlgoSHA512256, "sha512-256:e30d87cfa2a75db545eac4d61baf970366a8357c7f72fa95b52d0accb698f13a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algo, func(t *testing.T) {
+			got, err := HashWith(tt.algo, blob)
+			if err != nil {
+				t.Fatalf("HashWith() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("HashWith() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := HashWith("md5", blob); err == nil {
+		t.Error("HashWith() accepted an unknown algorithm")
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	const sha256Hex = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	tests := []struct {
+		digest string
+		algo   string
+		valid  bool
+	}{
+		{sha256Hex, AlgoSHA256, true},
+		{"sha256:" + sha256Hex, AlgoSHA256, true},
+		{"sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", AlgoSHA1, true},
+		{"sha512-256:" + sha256Hex, AlgoSHA512256, true},
+		{"aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", "", false},
+		{"sha1:" + sha256Hex, "", false},
+		{"md5:" + sha256Hex, "", false},
+		{"2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824", "", false},
+	}
+
+	for _, tt := range tests {
+		algo, _, err := ParseDigest(tt.digest)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseDigest(%q) error = %v, want valid %v", tt.digest, err, tt.valid)
+			continue
+		}
+		if tt.valid && algo != tt.algo {
+			t.Errorf("ParseDigest(%q) algo = %q, want %q", tt.digest, algo, tt.algo)
+		}
+	}
+}
+
+func TestCanonicalDigest(t *testing.T) {
+	const sha256Hex = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if got := CanonicalDigest("sha256:" + sha256Hex); got != sha256Hex {
+		t.Errorf("CanonicalDigest(sha256:...) = %q, want bare hex", got)
+	}
+
+	sha1Digest := "sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	if got := CanonicalDigest(sha1Digest); got != sha1Digest {
+		t.Errorf("CanonicalDigest(%q) = %q, want unchanged", sha1Digest, got)
+	}
+}
@@ -0,0 +1,99 @@
+package path
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const ConfigFile = "config.json"
+
+type Config struct {
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	Remote        string `json:"remote,omitempty"`
+	// LockTimeoutSeconds is how long commands wait for the repository
+	// lock; zero uses DefaultLockTimeout.
+	LockTimeoutSeconds int `json:"lock_timeout_seconds,omitempty"`
+	// MaxVersions caps how many versions of each catalog path are kept;
+	// zero keeps them all.
+	MaxVersions int           `json:"max_versions,omitempty"`
+	Storage     StorageConfig `json:"storage"`
+}
+
+type StorageConfig struct {
+	Backend      string   `json:"backend"`
+	Dir          string   `json:"dir,omitempty"`
+	S3           S3Config `json:"s3,omitempty"`
+	Roots        []string `json:"roots,omitempty"`
+	ParityShards int      `json:"parity_shards,omitempty"`
+	Compression  string   `json:"compression,omitempty"`
+	Chunking     bool     `json:"chunking,omitempty"`
+	ChunkAvgSize int      `json:"chunk_avg_size,omitempty"`
+	Durability   string   `json:"durability,omitempty"`
+	Encryption   bool     `json:"encryption,omitempty"`
+	KeyFile      string   `json:"key_file,omitempty"`
+	KeyID        string   `json:"key_id,omitempty"`
+	MaxSize      int64    `json:"max_size,omitempty"`
+	ColdDir      string   `json:"cold_dir,omitempty"`
+	DemoteAfter  int      `json:"demote_after_days,omitempty"`
+	// InlineThreshold keeps blobs smaller than this many bytes in the
+	// catalog database instead of in their own files; zero disables it.
+	InlineThreshold int64 `json:"inline_threshold,omitempty"`
+}
+
+type S3Config struct {
+	Endpoint  string `json:"endpoint,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Storage: StorageConfig{
+			Backend: "fs",
+		},
+	}
+}
+
+func (c Config) LockTimeout() time.Duration {
+	if c.LockTimeoutSeconds == 0 {
+		return DefaultLockTimeout
+	}
+	return time.Duration(c.LockTimeoutSeconds) * time.Second
+}
+
+func LoadConfig(casDir string) (Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(filepath.Join(casDir, ConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return Config{}, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func SaveConfig(casDir string, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(casDir, ConfigFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package path
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	FormatFile = "format.json"
+
+	// FormatVersion is the newest repository format this build understands.
+	FormatVersion = 1
+
+	// DefaultShardDepth is the number of two-character directory levels
+	// objects are sharded into, as in storage/ab/cd/abcd....
+	DefaultShardDepth = 2
+	MaxShardDepth     = 3
+)
+
+// Format describes how a repository lays out its objects on disk. Unlike
+// Config it is not meant to be edited by hand: it changes only through
+// cas migrate, which records what it is doing here so it can be resumed.
+type Format struct {
+	Version    int `json:"version"`
+	ShardDepth int `json:"shard_depth"`
+	// Migration is set while a layout change is under way. New objects are
+	// already written in the target layout; readers also look in the one
+	// being migrated from.
+	Migration *Migration `json:"migration,omitempty"`
+}
+
+type Migration struct {
+	// FromShardDepth is the depth objects are being moved out of, or zero
+	// when the shard depth is not changing.
+	FromShardDepth int `json:"from_shard_depth,omitempty"`
+	// Compression is the encoding existing objects are being rewritten in,
+	// or empty when they are kept as they are.
+	Compression string `json:"compression,omitempty"`
+	Pack        bool   `json:"pack,omitempty"`
+}
+
+func DefaultFormat() Format {
+	return Format{Version: FormatVersion, ShardDepth: DefaultShardDepth}
+}
+
+func ValidShardDepth(depth int) bool {
+	return depth >= 1 && depth <= MaxShardDepth
+}
+
+// LoadFormat reads the repository format. Repositories created before the
+// format file existed use the default layout.
+func LoadFormat(casDir string) (Format, error) {
+	format := DefaultFormat()
+
+	data, err := os.ReadFile(filepath.Join(casDir, FormatFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return format, nil
+		}
+		return Format{}, fmt.Errorf("failed to read format: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &format); err != nil {
+		return Format{}, fmt.Errorf("failed to parse format: %w", err)
+	}
+
+	if format.Version > FormatVersion {
+		return Format{}, fmt.Errorf("repository format version %d is newer than this cas supports (%d)", format.Version, FormatVersion)
+	}
+	if !ValidShardDepth(format.ShardDepth) {
+		return Format{}, fmt.Errorf("invalid shard depth in format: %d", format.ShardDepth)
+	}
+
+	return format, nil
+}
+
+// SaveFormat replaces the format file atomically, since readers in other
+// processes pick up changes to it while a migration runs.
+func SaveFormat(casDir string, format Format) error {
+	data, err := json.MarshalIndent(format, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal format: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(casDir, "tmp-format-")
+	if err != nil {
+		return fmt.Errorf("failed to write format: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write format: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync format: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write format: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(casDir, FormatFile)); err != nil {
+		return fmt.Errorf("failed to write format: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package path
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	LockFile = "lock"
+	// LockHoldersDir holds one record per lock held, naming the process,
+	// so a blocked command can say what it is waiting for.
+	LockHoldersDir = "locks"
+
+	// ServeLockFile is held shared by every cas serve for as long as it
+	// runs. Servers only take the repository lock per request, so this is
+	// how a command that must not run under a live server, such as a schema
+	// migration, finds one.
+	ServeLockFile = "serve.lock"
+
+	DefaultLockTimeout = 30 * time.Second
+)
+
+var (
+	ErrLockTimeout   = errors.New("timed out waiting for the repository lock")
+	ErrServerRunning = errors.New("cas serve is running on this repository")
+	ErrServeLocked   = errors.New("repository is locked against servers")
+)
+
+type LockMode int
+
+const (
+	// LockShared is taken by operations that add to the repository and can
+	// run alongside each other, such as adds and uploads.
+	LockShared LockMode = iota
+	// LockExclusive is taken by operations that delete or move objects,
+	// such as gc, repack and migrate.
+	LockExclusive
+)
+
+func (m LockMode) String() string {
+	if m == LockExclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// LockHolder describes a process holding the repository lock.
+type LockHolder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Mode    string    `json:"mode"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+func (h LockHolder) String() string {
+	return fmt.Sprintf("pid %d on %s (%s, %s lock since %s)", h.PID, h.Host, h.Command, h.Mode, h.Since.Format(time.RFC3339))
+}
+
+// Lock is a held advisory lock on a repository. The operating system drops
+// it if the process dies, so a crash never leaves the repository locked.
+type Lock struct {
+	file   *os.File
+	record string
+	mode   LockMode
+}
+
+func (r *Repository) Lock(mode LockMode) (*Lock, error) {
+	return AcquireLock(r.RootDir, mode, r.Config.LockTimeout())
+}
+
+// AcquireLock takes the repository lock in the given mode, waiting up to
+// timeout for conflicting holders to let go. A negative timeout fails at
+// once if the lock is taken.
+func AcquireLock(casDir string, mode LockMode, timeout time.Duration) (*Lock, error) {
+	file, err := os.OpenFile(filepath.Join(casDir, LockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	wait := 10 * time.Millisecond
+
+	for {
+		acquired, err := tryLock(file, mode)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock repository: %w", err)
+		}
+		if acquired {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			file.Close()
+			return nil, lockTimeoutError(casDir, mode, timeout)
+		}
+
+		time.Sleep(min(wait, time.Until(deadline)))
+		wait = min(wait*2, 500*time.Millisecond)
+	}
+
+	lock := &Lock{file: file, mode: mode}
+
+	// The record only helps others report who is holding things up; failing
+	// to write it does not make the lock any less held.
+	lock.record, _ = writeLockRecord(casDir, mode)
+
+	return lock, nil
+}
+
+// AcquireServeLock takes the serve lock without waiting. Servers take it
+// shared. A command that must not run beside a server takes it exclusive and
+// gets ErrServerRunning while any server holds it; a server starting in the
+// meantime gets ErrServeLocked.
+func AcquireServeLock(casDir string, mode LockMode) (*Lock, error) {
+	file, err := os.OpenFile(filepath.Join(casDir, ServeLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serve lock file: %w", err)
+	}
+
+	acquired, err := tryLock(file, mode)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	if !acquired {
+		file.Close()
+		if mode == LockExclusive {
+			return nil, ErrServerRunning
+		}
+		return nil, ErrServeLocked
+	}
+
+	return &Lock{file: file, mode: mode}, nil
+}
+
+func (l *Lock) Mode() LockMode {
+	return l.mode
+}
+
+func (l *Lock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+
+	if l.record != "" {
+		os.Remove(l.record)
+	}
+
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+
+	if err != nil {
+		return fmt.Errorf("failed to unlock repository: %w", err)
+	}
+	return nil
+}
+
+// LockHolders lists the processes recorded as holding the repository lock.
+// Records left by processes on this host that are no longer running are
+// stale, since their lock died with them, and are removed.
+func LockHolders(casDir string) ([]LockHolder, error) {
+	dir := filepath.Join(casDir, LockHoldersDir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read lock holders: %w", err)
+	}
+
+	host, _ := os.Hostname()
+
+	var holders []LockHolder
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		recordPath := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(recordPath)
+		if err != nil {
+			continue
+		}
+
+		var holder LockHolder
+		if err := json.Unmarshal(data, &holder); err != nil {
+			continue
+		}
+
+		if holder.Host == host && !processAlive(holder.PID) {
+			os.Remove(recordPath)
+			continue
+		}
+
+		holders = append(holders, holder)
+	}
+
+	return holders, nil
+}
+
+func writeLockRecord(casDir string, mode LockMode) (string, error) {
+	dir := filepath.Join(casDir, LockHoldersDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	host, _ := os.Hostname()
+	holder := LockHolder{
+		PID:     os.Getpid(),
+		Host:    host,
+		Mode:    mode.String(),
+		Command: commandName(),
+		Since:   time.Now(),
+	}
+
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(dir, fmt.Sprintf("%d-*", holder.PID))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+func lockTimeoutError(casDir string, mode LockMode, timeout time.Duration) error {
+	holders, _ := LockHolders(casDir)
+
+	var blocking []string
+	for _, holder := range holders {
+		// Shared holders only block exclusive requests.
+		if mode == LockExclusive || holder.Mode == LockExclusive.String() {
+			blocking = append(blocking, holder.String())
+		}
+	}
+
+	if len(blocking) == 0 {
+		return fmt.Errorf("%w (%s, after %v)", ErrLockTimeout, mode, timeout)
+	}
+	return fmt.Errorf("%w (%s, after %v); held by %s", ErrLockTimeout, mode, timeout, strings.Join(blocking, ", "))
+}
+
+func commandName() string {
+	name := filepath.Base(os.Args[0])
+	if len(os.Args) > 1 {
+		name += " " + os.Args[1]
+	}
+	return name
+}
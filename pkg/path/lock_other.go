@@ -0,0 +1,19 @@
+//go:build !unix
+
+package path
+
+import "os"
+
+// Without flock every lock is granted; the repository is then only as safe
+// as SQLite's own locking makes it.
+func tryLock(file *os.File, mode LockMode) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+func processAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,127 @@
+package path
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock_SharedAndExclusive(t *testing.T) {
+	casDir := t.TempDir()
+
+	first, err := AcquireLock(casDir, LockShared, time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock(shared) error: %v", err)
+	}
+	second, err := AcquireLock(casDir, LockShared, 0)
+	if err != nil {
+		t.Fatalf("second AcquireLock(shared) error: %v", err)
+	}
+
+	_, err = AcquireLock(casDir, LockExclusive, 50*time.Millisecond)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("AcquireLock(exclusive) under shared locks error = %v, want ErrLockTimeout", err)
+	}
+	if !strings.Contains(err.Error(), "shared lock") {
+		t.Errorf("timeout error %q does not name the holders", err)
+	}
+
+	first.Unlock()
+	second.Unlock()
+
+	exclusive, err := AcquireLock(casDir, LockExclusive, time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock(exclusive) after release error: %v", err)
+	}
+
+	if _, err := AcquireLock(casDir, LockShared, -1); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("AcquireLock(shared) under exclusive lock error = %v, want ErrLockTimeout", err)
+	}
+
+	// A waiter gets the lock once it is released.
+	done := make(chan error, 1)
+	go func() {
+		lock, err := AcquireLock(casDir, LockShared, 5*time.Second)
+		if err == nil {
+			lock.Unlock()
+		}
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	exclusive.Unlock()
+
+	if err := <-done; err != nil {
+		t.Errorf("waiting AcquireLock(shared) error: %v", err)
+	}
+
+	holders, err := LockHolders(casDir)
+	if err != nil {
+		t.Fatalf("LockHolders() error: %v", err)
+	}
+	if len(holders) != 0 {
+		t.Errorf("LockHolders() after every unlock = %v, want none", holders)
+	}
+}
+
+func TestServeLock(t *testing.T) {
+	casDir := t.TempDir()
+
+	server, err := AcquireServeLock(casDir, LockShared)
+	if err != nil {
+		t.Fatalf("AcquireServeLock(shared) error: %v", err)
+	}
+	if _, err := AcquireServeLock(casDir, LockExclusive); !errors.Is(err, ErrServerRunning) {
+		t.Errorf("AcquireServeLock(exclusive) under a server error = %v, want ErrServerRunning", err)
+	}
+
+	// The serve lock is separate from the repository lock.
+	lock, err := AcquireLock(casDir, LockExclusive, -1)
+	if err != nil {
+		t.Fatalf("AcquireLock(exclusive) under a server error: %v", err)
+	}
+	lock.Unlock()
+	server.Unlock()
+
+	migration, err := AcquireServeLock(casDir, LockExclusive)
+	if err != nil {
+		t.Fatalf("AcquireServeLock(exclusive) error: %v", err)
+	}
+	if _, err := AcquireServeLock(casDir, LockShared); !errors.Is(err, ErrServeLocked) {
+		t.Errorf("AcquireServeLock(shared) during a migration error = %v, want ErrServeLocked", err)
+	}
+	migration.Unlock()
+}
+
+func TestLockHolders_RemovesStaleRecords(t *testing.T) {
+	casDir := t.TempDir()
+
+	lock, err := AcquireLock(casDir, LockExclusive, time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock() error: %v", err)
+	}
+	defer lock.Unlock()
+
+	// A record left by a process that died without unlocking.
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(LockHolder{PID: 1 << 30, Host: host, Mode: "exclusive", Since: time.Now()})
+	stale := filepath.Join(casDir, LockHoldersDir, "stale")
+	if err := os.WriteFile(stale, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	holders, err := LockHolders(casDir)
+	if err != nil {
+		t.Fatalf("LockHolders() error: %v", err)
+	}
+	if len(holders) != 1 || holders[0].PID != os.Getpid() {
+		t.Errorf("LockHolders() = %v, want only this process", holders)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale record was not removed: %v", err)
+	}
+}
@@ -10,9 +10,14 @@ const CASDir = ".cas"
 
 type Repository struct {
 	RootDir string
+	Config  Config
 }
 
 func Init(path string) (*Repository, error) {
+	return InitWithConfig(path, DefaultConfig())
+}
+
+func InitWithConfig(path string, cfg Config) (*Repository, error) {
 	if path == "" {
 		var err error
 		path, err = os.Getwd()
@@ -38,7 +43,15 @@ func Init(path string) (*Repository, error) {
 		}
 	}
 
-	return &Repository{RootDir: casDir}, nil
+	if err := SaveConfig(casDir, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := SaveFormat(casDir, DefaultFormat()); err != nil {
+		return nil, err
+	}
+
+	return &Repository{RootDir: casDir, Config: cfg}, nil
 }
 
 func Open(path string) (*Repository, error) {
@@ -56,5 +69,10 @@ func Open(path string) (*Repository, error) {
 		return nil, fmt.Errorf("no CAS repository found: %w", err)
 	}
 
-	return &Repository{RootDir: casDir}, nil
+	cfg, err := LoadConfig(casDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{RootDir: casDir, Config: cfg}, nil
 }
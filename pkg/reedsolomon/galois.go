@@ -0,0 +1,69 @@
+package reedsolomon
+
+// Arithmetic in GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1, the
+// field used by most storage erasure codes. Addition is XOR; multiplication
+// goes through precomputed tables.
+
+const fieldPolynomial = 0x11d
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+func galMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+func galDiv(a, b byte) byte {
+	if b == 0 {
+		panic("reedsolomon: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+func galExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// mulAddSlice sets out[i] ^= c * in[i].
+func mulAddSlice(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+
+	row := &mulTable[c]
+	for i, v := range in {
+		out[i] ^= row[v]
+	}
+}
@@ -0,0 +1,101 @@
+package reedsolomon
+
+import "errors"
+
+var errSingular = errors.New("reedsolomon: matrix is singular")
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+func identity(n int) matrix {
+	m := newMatrix(n, n)
+	for i := range m {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// vandermonde builds the rows x cols matrix with m[r][c] = r^c. Any cols of
+// its rows are linearly independent, which is what makes every large enough
+// subset of shards sufficient for reconstruction.
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = galExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) multiply(other matrix) matrix {
+	result := newMatrix(len(m), len(other[0]))
+	for r := range result {
+		for c := range result[r] {
+			var v byte
+			for i := range m[r] {
+				v ^= galMul(m[r][i], other[i][c])
+			}
+			result[r][c] = v
+		}
+	}
+	return result
+}
+
+func (m matrix) subRows(rows []int) matrix {
+	sub := make(matrix, len(rows))
+	for i, r := range rows {
+		sub[i] = append([]byte(nil), m[r]...)
+	}
+	return sub
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination.
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errSingular
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		if scale := work[col][col]; scale != 1 {
+			for c := range work[col] {
+				work[col][c] = galDiv(work[col][c], scale)
+			}
+		}
+
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			for c := range work[r] {
+				work[r][c] ^= galMul(factor, work[col][c])
+			}
+		}
+	}
+
+	inverse := newMatrix(n, n)
+	for r := range inverse {
+		copy(inverse[r], work[r][n:])
+	}
+	return inverse, nil
+}
@@ -0,0 +1,184 @@
+// Package reedsolomon implements a systematic Reed-Solomon erasure code over
+// GF(2^8). A blob split into k data shards gains m parity shards, and any k of
+// the k+m shards are enough to rebuild the rest.
+package reedsolomon
+
+import (
+	"errors"
+	"fmt"
+)
+
+const MaxShards = 256
+
+var (
+	ErrTooFewShards = errors.New("too few shards to reconstruct")
+	ErrShardSize    = errors.New("shards must all have the same size")
+)
+
+type Encoder struct {
+	dataShards   int
+	parityShards int
+	// matrix maps the data shards to all shards. Its first dataShards rows
+	// are the identity, so data shards are stored unchanged.
+	matrix matrix
+}
+
+func New(dataShards, parityShards int) (*Encoder, error) {
+	if dataShards < 1 || parityShards < 1 {
+		return nil, fmt.Errorf("need at least one data and one parity shard, got %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > MaxShards {
+		return nil, fmt.Errorf("at most %d shards are supported, got %d", MaxShards, dataShards+parityShards)
+	}
+
+	total := dataShards + parityShards
+	v := vandermonde(total, dataShards)
+
+	top, err := v.subRows(seq(dataShards)).invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encoder{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       v.multiply(top),
+	}, nil
+}
+
+func (e *Encoder) DataShards() int {
+	return e.dataShards
+}
+
+func (e *Encoder) ParityShards() int {
+	return e.parityShards
+}
+
+func (e *Encoder) TotalShards() int {
+	return e.dataShards + e.parityShards
+}
+
+// Encode computes the parity shards from the data shards. shards must hold
+// DataShards data slices followed by ParityShards slices, which are
+// overwritten, or allocated when nil.
+func (e *Encoder) Encode(shards [][]byte) error {
+	size, err := e.checkShards(shards, false)
+	if err != nil {
+		return err
+	}
+
+	for i := e.dataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+		} else if len(shards[i]) != size {
+			return ErrShardSize
+		}
+		e.computeRow(e.matrix[i], shards[:e.dataShards], shards[i])
+	}
+
+	return nil
+}
+
+// Verify reports whether the parity shards match the data shards.
+func (e *Encoder) Verify(shards [][]byte) (bool, error) {
+	size, err := e.checkShards(shards, false)
+	if err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, size)
+	for i := e.dataShards; i < len(shards); i++ {
+		e.computeRow(e.matrix[i], shards[:e.dataShards], buf)
+		if string(buf) != string(shards[i]) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Reconstruct rebuilds every missing shard, marked by a nil slice, from the
+// ones present. At least DataShards shards must be present.
+func (e *Encoder) Reconstruct(shards [][]byte) error {
+	size, err := e.checkShards(shards, true)
+	if err != nil {
+		return err
+	}
+
+	present := make([]int, 0, e.dataShards)
+	for i, shard := range shards {
+		if shard != nil && len(present) < e.dataShards {
+			present = append(present, i)
+		}
+	}
+	if len(present) < e.dataShards {
+		return ErrTooFewShards
+	}
+
+	decode, err := e.matrix.subRows(present).invert()
+	if err != nil {
+		return err
+	}
+
+	inputs := make([][]byte, len(present))
+	for i, index := range present {
+		inputs[i] = shards[index]
+	}
+
+	for i := 0; i < e.dataShards; i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			e.computeRow(decode[i], inputs, shards[i])
+		}
+	}
+
+	for i := e.dataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			e.computeRow(e.matrix[i], shards[:e.dataShards], shards[i])
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) computeRow(row []byte, inputs [][]byte, out []byte) {
+	clear(out)
+	for i, input := range inputs {
+		mulAddSlice(row[i], input, out)
+	}
+}
+
+func (e *Encoder) checkShards(shards [][]byte, allowMissing bool) (int, error) {
+	if len(shards) != e.TotalShards() {
+		return 0, fmt.Errorf("expected %d shards, got %d", e.TotalShards(), len(shards))
+	}
+
+	size := -1
+	for i, shard := range shards {
+		if shard == nil {
+			if allowMissing || i >= e.dataShards {
+				continue
+			}
+			return 0, ErrTooFewShards
+		}
+		if size == -1 {
+			size = len(shard)
+		} else if len(shard) != size {
+			return 0, ErrShardSize
+		}
+	}
+
+	if size == -1 {
+		return 0, ErrTooFewShards
+	}
+	return size, nil
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package reedsolomon
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomShards(t *testing.T, enc *Encoder, size int) [][]byte {
+	t.Helper()
+
+	rng := rand.New(rand.NewSource(int64(enc.TotalShards())))
+	shards := make([][]byte, enc.TotalShards())
+	for i := 0; i < enc.DataShards(); i++ {
+		shards[i] = make([]byte, size)
+		rng.Read(shards[i])
+	}
+
+	if err := enc.Encode(shards); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	return shards
+}
+
+func TestGalois_Inverses(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := galMul(byte(a), galDiv(1, byte(a))); got != 1 {
+			t.Fatalf("%d * 1/%d = %d, want 1", a, a, got)
+		}
+	}
+}
+
+func TestReconstruct_EveryErasurePattern(t *testing.T) {
+	for _, tc := range []struct{ data, parity int }{{1, 1}, {3, 2}, {4, 2}, {5, 3}} {
+		enc, err := New(tc.data, tc.parity)
+		if err != nil {
+			t.Fatalf("New(%d, %d) error: %v", tc.data, tc.parity, err)
+		}
+
+		original := randomShards(t, enc, 97)
+		total := enc.TotalShards()
+
+		for mask := 0; mask < 1<<total; mask++ {
+			missing := 0
+			for i := 0; i < total; i++ {
+				if mask&(1<<i) != 0 {
+					missing++
+				}
+			}
+			if missing > tc.parity {
+				continue
+			}
+
+			shards := make([][]byte, total)
+			for i := range shards {
+				if mask&(1<<i) == 0 {
+					shards[i] = append([]byte(nil), original[i]...)
+				}
+			}
+
+			if err := enc.Reconstruct(shards); err != nil {
+				t.Fatalf("%d+%d mask %b: Reconstruct() error: %v", tc.data, tc.parity, mask, err)
+			}
+			for i := range shards {
+				if !bytes.Equal(shards[i], original[i]) {
+					t.Fatalf("%d+%d mask %b: shard %d differs", tc.data, tc.parity, mask, i)
+				}
+			}
+		}
+	}
+}
+
+func TestReconstruct_TooFewShards(t *testing.T) {
+	enc, _ := New(3, 2)
+	shards := randomShards(t, enc, 16)
+	shards[0], shards[2], shards[4] = nil, nil, nil
+
+	if err := enc.Reconstruct(shards); !errors.Is(err, ErrTooFewShards) {
+		t.Errorf("Reconstruct() error = %v, want ErrTooFewShards", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	enc, _ := New(4, 2)
+	shards := randomShards(t, enc, 64)
+
+	if ok, err := enc.Verify(shards); err != nil || !ok {
+		t.Fatalf("Verify() = %v, %v, want true", ok, err)
+	}
+
+	shards[1][10] ^= 0xff
+	if ok, _ := enc.Verify(shards); ok {
+		t.Error("Verify() = true after corrupting a data shard")
+	}
+}
+
+func TestNew_InvalidShardCounts(t *testing.T) {
+	for _, tc := range []struct{ data, parity int }{{0, 1}, {1, 0}, {200, 57}} {
+		if _, err := New(tc.data, tc.parity); err == nil {
+			t.Errorf("New(%d, %d) succeeded, want error", tc.data, tc.parity)
+		}
+	}
+}
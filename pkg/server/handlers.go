@@ -2,38 +2,31 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"regexp"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
-var hashRegex = regexp.MustCompile("^[a-f0-9]{64}$")
-
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	entries, err := s.catalog.ListEntries()
+	stats, err := s.catalog.Stats()
 	if err != nil {
-		s.logger.Printf("Failed to list entries: %v", err)
-		WriteError(w, http.StatusInternalServerError, "Failed to list entries")
+		s.logger.Printf("Failed to read catalog stats: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to read catalog stats")
 		return
 	}
 
-	uniqueHashes := make(map[string]bool)
-
-	for _, entry := range entries {
-		uniqueHashes[entry.Hash] = true
-	}
-
 	response := HealthResponse{
-		Status:      "ok",
-		TotalFiles:  len(entries),
-		UniqueBlobs: len(uniqueHashes),
+		Status: "ok",
+		Stats:  stats,
 	}
 
 	WriteJSON(w, http.StatusOK, response)
@@ -47,9 +40,9 @@ func (s *Server) handleGetBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reader, err := storage.OpenBlob(s.casDir, hash)
+	info, err := s.store.Stat(hash)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, storage.ErrNotFound) {
 			WriteError(w, http.StatusNotFound, "Blob not found")
 		} else {
 			s.logger.Printf("Error opening blob %s: %v", hash, err)
@@ -58,20 +51,34 @@ func (s *Server) handleGetBlob(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
-	defer reader.Close()
-
-	size := getSizeFromFile(reader)
+	size := info.Size
 
 	if r.Method == http.MethodHead {
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
 		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hash))
 		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-		reader.Close()
+		w.Header().Set("X-CAS-Stored-Size", fmt.Sprintf("%d", info.StoredSize))
+		w.Header().Set("Accept-Ranges", "bytes")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	if r.Header.Get("Range") != "" {
+		s.serveRange(w, r, hash, size)
+		return
+	}
+
+	reader, err := s.store.OpenBlob(hash)
+	if err != nil {
+		s.logger.Printf("Error opening blob %s: %v", hash, err)
+		WriteError(w, http.StatusInternalServerError, "Failed to read blob")
+		return
+	}
+
+	w.Header().Set("X-CAS-Stored-Size", fmt.Sprintf("%d", info.StoredSize))
+	w.Header().Set("Accept-Ranges", "bytes")
+
 	if err := WriteBlob(w, hash, size, reader); err != nil {
 		s.logger.Printf("Error streaming blob %s: %v", hash, err)
 	}
@@ -85,7 +92,7 @@ func (s *Server) handleStatBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reader, err := storage.OpenBlob(s.casDir, hash)
+	info, err := s.store.Stat(hash)
 	if err != nil {
 		WriteJSON(w, http.StatusOK, BlobResponse{
 			Hash:   hash,
@@ -94,14 +101,12 @@ func (s *Server) handleStatBlob(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	defer reader.Close()
-
-	size := getSizeFromFile(reader)
 
 	WriteJSON(w, http.StatusOK, BlobResponse{
-		Hash:   hash,
-		Size:   size,
-		Exists: true,
+		Hash:       hash,
+		Size:       info.Size,
+		StoredSize: info.StoredSize,
+		Exists:     true,
 	})
 }
 
@@ -112,7 +117,14 @@ func (s *Server) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if filepath := r.URL.Query().Get("filepath"); filepath != "" {
+	query := r.URL.Query()
+
+	if filepath := query.Get("filepath"); filepath != "" {
+		if query.Has("version") || query.Has("at") {
+			s.getEntryVersion(w, filepath, query)
+			return
+		}
+
 		entry, err := s.catalog.GetEntry(filepath)
 		if err != nil {
 			WriteError(w, http.StatusNotFound, "Entry not found")
@@ -133,26 +145,79 @@ func (s *Server) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
 	WriteJSON(w, http.StatusOK, entries)
 }
 
+// getEntryVersion serves a numbered version of a path, or the version it had
+// at a point in time.
+func (s *Server) getEntryVersion(w http.ResponseWriter, filepath string, query url.Values) {
+	var v catalog.Version
+	var err error
+
+	if query.Has("version") {
+		n, convErr := strconv.Atoi(query.Get("version"))
+		if convErr != nil || n < 1 {
+			WriteError(w, http.StatusBadRequest, "Invalid version")
+			return
+		}
+		v, err = s.catalog.GetVersion(filepath, n)
+	} else {
+		at, parseErr := time.Parse(time.RFC3339Nano, query.Get("at"))
+		if parseErr != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid time, want RFC 3339")
+			return
+		}
+		v, err = s.catalog.GetEntryAt(filepath, at)
+	}
+
+	if err != nil {
+		if errors.Is(err, catalog.ErrVersionNotFound) {
+			WriteError(w, http.StatusNotFound, "Version not found")
+			return
+		}
+		s.logger.Printf("Failed to read version of %s: %v", filepath, err)
+		WriteError(w, http.StatusInternalServerError, "Failed to read version")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	filepath := r.URL.Query().Get("filepath")
+	if filepath == "" {
+		WriteError(w, http.StatusBadRequest, "filepath is required")
+		return
+	}
+
+	versions, err := s.catalog.History(filepath)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "Entry not found")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, versions)
+}
+
 func (s *Server) handlePostBlob(w http.ResponseWriter, r *http.Request) {
-	hash, err := storage.WriteBlobStream(s.casDir, r.Body)
+	hash, err := s.store.WriteBlobStream(r.Body)
 	if err != nil {
+		if errors.Is(err, hooks.ErrVetoed) {
+			WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		s.logger.Printf("Error writing blob: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to write blob")
 		return
 	}
 
-	reader, err := storage.OpenBlob(s.casDir, hash)
+	info, err := s.store.Stat(hash)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Failed to read blob after write")
 		return
 	}
-	defer reader.Close()
-
-	size := getSizeFromFile(reader)
 
 	response := BlobResponse{
-		Hash: hash,
-		Size: size,
+		Hash:       hash,
+		Size:       info.Size,
+		StoredSize: info.StoredSize,
 	}
 
 	WriteJSON(w, http.StatusCreated, response)
@@ -160,10 +225,16 @@ func (s *Server) handlePostBlob(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handlePostCatalog(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Filepath string    `json:"filepath"`
-		Hash     string    `json:"hash"`
-		Size     uint64    `json:"size"`
-		Modified time.Time `json:"modified"`
+		Filepath string            `json:"filepath"`
+		Hash     string            `json:"hash"`
+		Size     uint64            `json:"size"`
+		Modified time.Time         `json:"modified"`
+		Mode     uint32            `json:"mode"`
+		Type     string            `json:"type"`
+		UID      uint32            `json:"uid"`
+		GID      uint32            `json:"gid"`
+		Target   string            `json:"target"`
+		Xattrs   map[string][]byte `json:"xattrs"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -186,10 +257,22 @@ func (s *Server) handlePostCatalog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := storage.OpenBlob(s.casDir, req.Hash)
+	switch req.Type {
+	case "", catalog.TypeFile, catalog.TypeDir:
+	case catalog.TypeSymlink:
+		if req.Target == "" {
+			WriteError(w, http.StatusBadRequest, "target is required for a symlink")
+			return
+		}
+	default:
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("Unknown entry type: %s", req.Type))
+		return
+	}
+
+	_, err := s.store.Stat(req.Hash)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			WriteError(w, http.StatusNotFound, fmt.Sprintf("Blob %s not found - upload blob first", req.Hash[:8]))
+		if errors.Is(err, storage.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("Blob %s not found - upload blob first", objects.ShortDigest(req.Hash)))
 		} else {
 			WriteError(w, http.StatusInternalServerError, "Failed to verify blob")
 		}
@@ -201,9 +284,27 @@ func (s *Server) handlePostCatalog(w http.ResponseWriter, r *http.Request) {
 		Hash:     req.Hash,
 		Filesize: req.Size,
 		ModTime:  req.Modified,
+		Mode:     req.Mode,
+		Type:     req.Type,
+		UID:      req.UID,
+		GID:      req.GID,
+		Target:   req.Target,
+		Xattrs:   req.Xattrs,
 	}
 
-	s.catalog.AddEntry(entry)
+	if err := s.catalog.AddEntry(entry); err != nil {
+		if errors.Is(err, catalog.ErrHeld) {
+			WriteError(w, http.StatusConflict, fmt.Sprintf("%s is under a retention or legal hold", req.Filepath))
+			return
+		}
+		if errors.Is(err, hooks.ErrVetoed) {
+			WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		s.logger.Printf("Error adding catalog entry: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to add catalog entry")
+		return
+	}
 
 	if err := s.catalog.Save(); err != nil {
 		s.logger.Printf("Error saving catalog: %v", err)
@@ -211,20 +312,85 @@ func (s *Server) handlePostCatalog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.logger.Printf("Added catalog entry: %s -> %s", req.Filepath, req.Hash[:8])
+	s.logger.Printf("Added catalog entry: %s -> %s", req.Filepath, objects.ShortDigest(req.Hash))
 	WriteJSON(w, http.StatusCreated, entry)
 }
 
-func getSizeFromFile(reader io.ReadCloser) int64 {
-	var size int64
-	if file, ok := reader.(*os.File); ok {
-		if stat, err := file.Stat(); err == nil {
-			size = stat.Size()
-		}
+// CatalogChangeResponse reports how many entries a delete or rename changed.
+type CatalogChangeResponse struct {
+	Count int `json:"count"`
+}
+
+func (s *Server) handleDeleteCatalog(w http.ResponseWriter, r *http.Request) {
+	filepath := r.URL.Query().Get("filepath")
+	if filepath == "" {
+		WriteError(w, http.StatusBadRequest, "filepath is required")
+		return
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	n, err := s.catalog.DeleteEntry(filepath, recursive)
+	if err != nil {
+		s.writeCatalogChangeError(w, err)
+		return
+	}
+
+	s.logger.Printf("Deleted %d catalog entries at %s", n, filepath)
+	WriteJSON(w, http.StatusOK, CatalogChangeResponse{Count: n})
+}
+
+func (s *Server) handleRenameCatalog(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.From == "" || req.To == "" {
+		WriteError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	if strings.Contains(req.To, "..") {
+		WriteError(w, http.StatusBadRequest, "Path traversal not allowed")
+		return
+	}
+
+	n, err := s.catalog.RenameEntry(req.From, req.To)
+	if err != nil {
+		s.writeCatalogChangeError(w, err)
+		return
+	}
+
+	s.logger.Printf("Renamed %d catalog entries from %s to %s", n, req.From, req.To)
+	WriteJSON(w, http.StatusOK, CatalogChangeResponse{Count: n})
+}
+
+func (s *Server) writeCatalogChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, catalog.ErrEntryNotFound):
+		WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, catalog.ErrHeld):
+		WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, catalog.ErrEntryExists):
+		WriteError(w, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, catalog.ErrIsDirectory):
+		WriteError(w, http.StatusUnprocessableEntity, err.Error()+"; use recursive=true")
+	case errors.Is(err, hooks.ErrVetoed):
+		WriteError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, catalog.ErrInvalidPath):
+		WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Printf("Error changing catalog: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to update catalog")
 	}
-	return size
 }
 
 func isValidHash(hash string) bool {
-	return hashRegex.MatchString(hash)
+	return objects.ValidDigest(hash)
 }
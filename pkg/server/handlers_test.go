@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -12,6 +14,7 @@ import (
 	"testing"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
 	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
@@ -25,6 +28,11 @@ func setupTestServer(t *testing.T) *Server {
 		t.Fatalf("failed to create storage dir: %v", err)
 	}
 
+	store, err := storage.Open(casDir)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
 	cat := catalog.NewCatalog(casDir)
 	cat.Load()
 
@@ -38,6 +46,7 @@ func setupTestServer(t *testing.T) *Server {
 			CORSOrigins: []string{"*"},
 		},
 		catalog: cat,
+		store:   store,
 		casDir:  casDir,
 		logger:  log.New(io.Discard, "", 0),
 	}
@@ -135,7 +144,7 @@ func TestHandlePostBlob(t *testing.T) {
 		t.Errorf("hash length = %d, want 64", len(response.Hash))
 	}
 
-	reader, err := storage.OpenBlob(server.casDir, response.Hash)
+	reader, err := server.store.OpenBlob(response.Hash)
 	if err != nil {
 		t.Fatalf("blob was not stored: %v", err)
 	}
@@ -146,7 +155,7 @@ func TestHandleGetBlob_Success(t *testing.T) {
 	server := setupTestServer(t)
 
 	content := "content for download test"
-	hash, err := storage.WriteBlobStream(server.casDir, strings.NewReader(content))
+	hash, err := server.store.WriteBlobStream(strings.NewReader(content))
 	if err != nil {
 		t.Fatalf("failed to write blob: %v", err)
 	}
@@ -165,3 +174,231 @@ func TestHandleGetBlob_Success(t *testing.T) {
 		t.Errorf("body = %q, want %q", rec.Body.String(), content)
 	}
 }
+
+func TestAdminHolds(t *testing.T) {
+	server := setupTestServer(t)
+	server.store.SetHolds(server.catalog)
+	handler := server.setupRoutes()
+
+	hash, err := server.store.WriteBlobStream(strings.NewReader("regulated record"))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	// Admin reads need the token, unlike blob reads.
+	req := httptest.NewRequest(http.MethodGet, "/admin/holds", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	body := `{"blob":"` + hash + `","legal":true,"reason":"litigation"}`
+	req = httptest.NewRequest(http.MethodPost, "/admin/holds", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("place status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if err := server.store.Delete(hash); !errors.Is(err, storage.ErrHeld) {
+		t.Errorf("Delete() of held blob error = %v, want ErrHeld", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/holds", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var holds HoldsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&holds); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(holds.Holds) != 1 || !holds.Holds[0].Legal {
+		t.Errorf("holds = %+v, want one legal hold", holds.Holds)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/holds/release", strings.NewReader(`{"blob":"`+hash+`"}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("release status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if err := server.store.Delete(hash); err != nil {
+		t.Errorf("Delete() after release error: %v", err)
+	}
+}
+
+func TestAdminHolds_NoToken(t *testing.T) {
+	server := setupTestServer(t)
+	server.config.AuthToken = ""
+	server.store.SetHolds(server.catalog)
+	handler := server.setupRoutes()
+
+	hash, err := server.store.WriteBlobStream(strings.NewReader("regulated record"))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+	if err := server.catalog.SetLegalHold(catalog.HoldBlob, hash, true, "litigation"); err != nil {
+		t.Fatalf("SetLegalHold() error: %v", err)
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/admin/holds/release", strings.NewReader(`{"blob":"`+hash+`"}`)),
+		httptest.NewRequest(http.MethodGet, "/admin/holds", nil),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s status = %d, want %d", req.Method, req.URL.Path, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	if err := server.store.Delete(hash); !errors.Is(err, storage.ErrHeld) {
+		t.Errorf("Delete() error = %v, want ErrHeld", err)
+	}
+}
+
+func TestGetEntryVersions(t *testing.T) {
+	server := setupTestServer(t)
+	handler := server.setupRoutes()
+
+	for _, hash := range []string{"aaa", "bbb"} {
+		if err := server.catalog.AddEntry(catalog.Entry{Filepath: "doc.txt", Hash: hash, Filesize: 3}); err != nil {
+			t.Fatalf("AddEntry() error: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/catalog/history?filepath=doc.txt", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("history status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var versions []catalog.Version
+	if err := json.NewDecoder(rec.Body).Decode(&versions); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Hash != "bbb" {
+		t.Errorf("history = %+v, want bbb then aaa", versions)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/catalog?filepath=doc.txt&version=1", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var v catalog.Version
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || v.Hash != "aaa" || v.Version != 1 {
+		t.Errorf("version 1 = %d %+v, want aaa", rec.Code, v)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"filepath=doc.txt&version=5", http.StatusNotFound},
+		{"filepath=doc.txt&version=zero", http.StatusBadRequest},
+		{"filepath=doc.txt&at=yesterday", http.StatusBadRequest},
+		{"filepath=doc.txt&at=2000-01-01T00:00:00Z", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req = httptest.NewRequest(http.MethodGet, "/catalog?"+tt.query, nil)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("GET /catalog?%s status = %d, want %d", tt.query, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestDeleteAndRenameCatalog(t *testing.T) {
+	server := setupTestServer(t)
+	handler := server.setupRoutes()
+
+	for _, path := range []string{"dir/a.txt", "dir/b.txt", "single.txt"} {
+		if err := server.catalog.AddEntry(catalog.Entry{Filepath: path, Hash: validTestHash(), Filesize: 1}); err != nil {
+			t.Fatalf("AddEntry() error: %v", err)
+		}
+	}
+
+	send := func(method, target, body string, auth bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if auth {
+			req.Header.Set("Authorization", "Bearer test-token")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodDelete, "/catalog?filepath=single.txt", "", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated delete status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := send(http.MethodDelete, "/catalog?filepath=dir", "", true); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("non-recursive directory delete status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	rec := send(http.MethodPatch, "/catalog", `{"from":"dir","to":"moved"}`, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var change CatalogChangeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || change.Count != 2 {
+		t.Errorf("rename response = %+v, %v, want count 2", change, err)
+	}
+
+	if rec := send(http.MethodPatch, "/catalog", `{"from":"single.txt","to":"moved/a.txt"}`, true); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("rename onto existing entry status = %d, want %d", rec.Code, http.StatusPreconditionFailed)
+	}
+
+	if rec := send(http.MethodDelete, "/catalog?filepath=moved&recursive=true", "", true); rec.Code != http.StatusOK {
+		t.Errorf("recursive delete status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec := send(http.MethodDelete, "/catalog?filepath=moved&recursive=true", "", true); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	entries, err := server.catalog.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries() error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Filepath != "single.txt" {
+		t.Errorf("entries = %+v, want only single.txt", entries)
+	}
+}
+
+func TestShutdown_BeforeStartReleasesRepository(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := path.Init(dir)
+	if err != nil {
+		t.Fatalf("Init() error: %v", err)
+	}
+
+	server, err := NewServer(Config{RepoPath: dir})
+	if err != nil {
+		t.Fatalf("NewServer() error: %v", err)
+	}
+	server.logger = log.New(io.Discard, "", 0)
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+
+	lock, err := path.AcquireServeLock(repo.RootDir, path.LockExclusive)
+	if err != nil {
+		t.Fatalf("AcquireServeLock(exclusive) after Shutdown() error: %v", err)
+	}
+	lock.Unlock()
+
+	if _, err := server.catalog.ListEntries(); err == nil {
+		t.Error("catalog still open after Shutdown()")
+	}
+}
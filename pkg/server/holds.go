@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+// holdRequest names one hold target: a blob digest or a catalog path.
+type holdRequest struct {
+	Blob        string    `json:"blob,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	RetainUntil time.Time `json:"retain_until,omitempty"`
+	Legal       bool      `json:"legal,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+type HoldsResponse struct {
+	Holds []catalog.Hold `json:"holds"`
+}
+
+type HoldEventsResponse struct {
+	Events []catalog.HoldEvent `json:"events"`
+}
+
+func (s *Server) handleListHolds(w http.ResponseWriter, r *http.Request) {
+	holds, err := s.catalog.Holds()
+	if err != nil {
+		s.logger.Printf("Failed to list holds: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to list holds")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, HoldsResponse{Holds: holds})
+}
+
+func (s *Server) handleHoldEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := s.catalog.HoldEvents()
+	if err != nil {
+		s.logger.Printf("Failed to list hold events: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to list hold events")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, HoldEventsResponse{Events: events})
+}
+
+// handlePlaceHold sets a retention period, a legal hold or both.
+func (s *Server) handlePlaceHold(w http.ResponseWriter, r *http.Request) {
+	req, targetType, target, ok := s.decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if req.RetainUntil.IsZero() && !req.Legal {
+		WriteError(w, http.StatusBadRequest, "retain_until or legal is required")
+		return
+	}
+
+	if err := s.catalog.PlaceHold(targetType, target, req.RetainUntil, req.Legal, req.Reason); err != nil {
+		s.writeHoldError(w, err)
+		return
+	}
+
+	s.logger.Printf("Placed hold on %s %s", targetType, target)
+	WriteJSON(w, http.StatusOK, map[string]string{"target_type": targetType, "target": target})
+}
+
+// handleReleaseHold lifts a legal hold. Retention periods cannot be
+// released; they lapse on their own.
+func (s *Server) handleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	req, targetType, target, ok := s.decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.catalog.SetLegalHold(targetType, target, false, req.Reason); err != nil {
+		s.writeHoldError(w, err)
+		return
+	}
+
+	s.logger.Printf("Released legal hold on %s %s", targetType, target)
+	WriteJSON(w, http.StatusOK, map[string]string{"target_type": targetType, "target": target})
+}
+
+func (s *Server) decodeHoldRequest(w http.ResponseWriter, r *http.Request) (holdRequest, string, string, bool) {
+	var req holdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid JSON")
+		return req, "", "", false
+	}
+
+	switch {
+	case (req.Blob == "") == (req.Path == ""):
+		WriteError(w, http.StatusBadRequest, "exactly one of blob and path is required")
+		return req, "", "", false
+	case req.Blob != "":
+		if !isValidHash(req.Blob) {
+			WriteError(w, http.StatusBadRequest, "Invalid hash format")
+			return req, "", "", false
+		}
+		exists, err := s.store.Exists(req.Blob)
+		if err != nil {
+			s.logger.Printf("Error checking blob %s: %v", req.Blob, err)
+			WriteError(w, http.StatusInternalServerError, "Failed to verify blob")
+			return req, "", "", false
+		}
+		if !exists {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("Blob %s not found", objects.ShortDigest(req.Blob)))
+			return req, "", "", false
+		}
+		return req, catalog.HoldBlob, req.Blob, true
+	default:
+		if strings.Contains(req.Path, "..") {
+			WriteError(w, http.StatusBadRequest, "Path traversal not allowed")
+			return req, "", "", false
+		}
+		if _, err := s.catalog.GetEntry(req.Path); err != nil {
+			WriteError(w, http.StatusNotFound, "Entry not found")
+			return req, "", "", false
+		}
+		return req, catalog.HoldPath, req.Path, true
+	}
+}
+
+func (s *Server) writeHoldError(w http.ResponseWriter, err error) {
+	if errors.Is(err, catalog.ErrRetentionActive) {
+		WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+	WriteError(w, http.StatusBadRequest, err.Error())
+}
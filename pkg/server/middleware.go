@@ -3,7 +3,10 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
 )
 
 type Middleware func(http.Handler) http.Handler
@@ -59,8 +62,9 @@ func (s *Server) CORSMiddleware(next http.Handler) http.Handler {
 
 		if allowedOrigin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, "+ProofHeader)
+			w.Header().Set("Access-Control-Expose-Headers", "Content-Range, X-CAS-Stored-Size, X-CAS-Merkle-Root, X-CAS-Merkle-Chunk-Size, X-CAS-Merkle-Proof")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 		}
 
@@ -89,12 +93,20 @@ func (s *Server) getAllowedOrigin(origin string) string {
 
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		// Admin endpoints need the token for reads too, and are closed
+		// altogether on a server without one.
+		admin := r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/")
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if readOnly && !admin {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		if s.config.AuthToken == "" {
+			if admin {
+				WriteError(w, http.StatusForbidden, "Admin endpoints require an auth token to be configured")
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -114,3 +126,25 @@ func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// LockMiddleware holds the repository's shared lock while a request changes
+// the repository, so gc and other commands that take it exclusively run
+// between writes rather than underneath them.
+func (s *Server) LockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		lock, err := path.AcquireLock(s.casDir, path.LockShared, s.lockTimeout)
+		if err != nil {
+			s.logger.Printf("Failed to lock repository: %v", err)
+			WriteError(w, http.StatusServiceUnavailable, "Repository is locked for maintenance")
+			return
+		}
+		defer lock.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
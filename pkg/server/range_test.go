@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/client"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		header string
+		offset int64
+		length int64
+		ok     bool
+	}{
+		{"bytes=0-9", 0, 10, true},
+		{"bytes=90-", 90, 10, true},
+		{"bytes=-5", 95, 5, true},
+		{"bytes=95-200", 95, 5, true},
+		{"bytes=100-", 0, 0, false},
+		{"bytes=5-2", 0, 0, false},
+		{"bytes=0-1,4-5", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+	}
+
+	for _, tc := range testCases {
+		offset, length, err := parseRange(tc.header, 100)
+		if (err == nil) != tc.ok || offset != tc.offset || length != tc.length {
+			t.Errorf("parseRange(%q) = %d, %d, %v", tc.header, offset, length, err)
+		}
+	}
+
+	for _, header := range []string{"bytes=-5", "bytes=0-", "bytes=0-0"} {
+		if offset, length, err := parseRange(header, 0); err == nil {
+			t.Errorf("parseRange(%q) on an empty blob = %d, %d, want an error", header, offset, length)
+		}
+	}
+}
+
+func TestRangeDownload_EmptyBlob(t *testing.T) {
+	server := setupTestServer(t)
+
+	hash, err := server.store.WriteBlobStream(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/blobs/"+hash, nil)
+	req.Header.Set("Range", "bytes=-5")
+	rec := httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestedRangeNotSatisfiable)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes */0" {
+		t.Errorf("Content-Range = %q, want %q", got, "bytes */0")
+	}
+}
+
+func TestVerifiedRangeDownload(t *testing.T) {
+	server := setupTestServer(t)
+
+	data := make([]byte, 3*storage.OutboardChunkSize+1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	hash, err := server.store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	var tamper bool
+	routes := server.setupRoutes()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tamper {
+			routes.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		body[len(body)/2] ^= 0xff
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	c := client.NewHTTPClient(ts.URL, "")
+	ctx := context.Background()
+
+	offset, length := int64(storage.OutboardChunkSize-10), int64(storage.OutboardChunkSize+20)
+	if _, err := c.DownloadRange(ctx, hash, offset, length); !errors.Is(err, client.ErrUntrustedRoot) {
+		t.Fatalf("DownloadRange() before any root is trusted error = %v, want ErrUntrustedRoot", err)
+	}
+
+	// A whole download is checked against the digest and makes its root
+	// trusted for the ranges after it.
+	rc, err := c.DownloadRange(ctx, hash, 0, int64(len(data)))
+	if err != nil {
+		t.Fatalf("DownloadRange(whole) error: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("DownloadRange(whole) = %d bytes, %v", len(got), err)
+	}
+
+	rc, err = c.DownloadRange(ctx, hash, offset, length)
+	if err != nil {
+		t.Fatalf("DownloadRange() error: %v", err)
+	}
+	got, err = io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading range: %v", err)
+	}
+	if !bytes.Equal(got, data[offset:offset+length]) {
+		t.Error("DownloadRange() returned different content")
+	}
+
+	tamper = true
+	rc, err = c.DownloadRange(ctx, hash, offset, length)
+	if err != nil {
+		t.Fatalf("DownloadRange() error: %v", err)
+	}
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if !errors.Is(err, client.ErrVerificationFailed) {
+		t.Errorf("tampered range error = %v, want ErrVerificationFailed", err)
+	}
+}
+
+// TestRangeDownload_FreshClient reads a range on a client that never saw the
+// whole blob: the uploader checked the root, and the roots file carries it.
+func TestRangeDownload_FreshClient(t *testing.T) {
+	server := setupTestServer(t)
+	ts := httptest.NewServer(server.setupRoutes())
+	defer ts.Close()
+
+	data := make([]byte, 2*storage.OutboardChunkSize+500)
+	rand.New(rand.NewSource(4)).Read(data)
+
+	roots := filepath.Join(t.TempDir(), "roots.json")
+	ctx := context.Background()
+
+	uploader := client.NewHTTPClient(ts.URL, "test-token")
+	if err := uploader.SetRootsFile(roots); err != nil {
+		t.Fatalf("SetRootsFile() error: %v", err)
+	}
+	hash, err := uploader.Upload(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Upload() error: %v", err)
+	}
+
+	fresh, err := client.NewClient(client.Config{ServerURL: ts.URL, AuthToken: "test-token", RootsFile: roots})
+	if err != nil {
+		t.Fatalf("NewClient() error: %v", err)
+	}
+
+	offset, length := int64(storage.OutboardChunkSize+7), int64(300)
+	rc, err := fresh.(*client.HTTPClient).DownloadRange(ctx, hash, offset, length)
+	if err != nil {
+		t.Fatalf("DownloadRange() on a fresh client error: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading range: %v", err)
+	}
+	if !bytes.Equal(got, data[offset:offset+length]) {
+		t.Error("DownloadRange() returned different content")
+	}
+}
+
+// TestRangeDownload_HostileServer serves another blob, with a proof that is
+// valid for it, in place of the one requested.
+func TestRangeDownload_HostileServer(t *testing.T) {
+	server := setupTestServer(t)
+
+	data := make([]byte, 2*storage.OutboardChunkSize+100)
+	rand.New(rand.NewSource(2)).Read(data)
+	other := bytes.Repeat([]byte("x"), len(data))
+
+	hash, err := server.store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+	otherHash, err := server.store.WriteBlobStream(bytes.NewReader(other))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+	outboard, err := server.store.Outboard(hash)
+	if err != nil {
+		t.Fatalf("Outboard() error: %v", err)
+	}
+
+	routes := server.setupRoutes()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = "/blobs/" + otherHash
+		routes.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := client.NewHTTPClient(ts.URL, "")
+	ctx := context.Background()
+
+	rc, err := c.DownloadRange(ctx, hash, 0, int64(len(data)))
+	if err != nil {
+		t.Fatalf("DownloadRange(whole) error: %v", err)
+	}
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	if !errors.Is(err, client.ErrVerificationFailed) {
+		t.Errorf("whole download of the wrong blob error = %v, want ErrVerificationFailed", err)
+	}
+
+	if err := c.TrustMerkleRoot(hash, outboard.Root); err != nil {
+		t.Fatalf("TrustMerkleRoot() error: %v", err)
+	}
+	if _, err := c.DownloadRange(ctx, hash, 10, 20); !errors.Is(err, client.ErrVerificationFailed) {
+		t.Errorf("range of the wrong blob error = %v, want ErrVerificationFailed", err)
+	}
+}
+
+func TestRangeDownload_TruncatedContentRange(t *testing.T) {
+	server := setupTestServer(t)
+
+	data := make([]byte, 3*storage.OutboardChunkSize)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	hash, err := server.store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	chunk := int64(storage.OutboardChunkSize)
+	routes := server.setupRoutes()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", chunk-1, len(data)))
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes()[:chunk])
+	}))
+	defer ts.Close()
+
+	c := client.NewHTTPClient(ts.URL, "")
+	if _, err := c.DownloadRange(context.Background(), hash, 2*chunk, 10); err == nil {
+		t.Error("DownloadRange() accepted a response that does not cover the request")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
 )
 
 type ErrorResponse struct {
@@ -14,15 +16,15 @@ type ErrorResponse struct {
 }
 
 type BlobResponse struct {
-	Hash   string `json:"hash"`
-	Size   int64  `json:"size"`
-	Exists bool   `json:"exists,omitempty"`
+	Hash       string `json:"hash"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"stored_size,omitempty"`
+	Exists     bool   `json:"exists,omitempty"`
 }
 
 type HealthResponse struct {
-	Status      string `json:"status"`
-	TotalFiles  int    `json:"total_files"`
-	UniqueBlobs int    `json:"unique_blobs"`
+	Status string `json:"status"`
+	catalog.Stats
 }
 
 func WriteJSON(w http.ResponseWriter, status int, data interface{}) error {
@@ -12,14 +12,22 @@ func (s *Server) setupRoutes() http.Handler {
 	mux.HandleFunc("HEAD /blobs/{hash}", s.handleGetBlob)
 	mux.HandleFunc("GET /blobs/{hash}/stat", s.handleStatBlob)
 	mux.HandleFunc("GET /catalog", s.handleGetCatalog)
+	mux.HandleFunc("GET /catalog/history", s.handleGetHistory)
 	mux.HandleFunc("POST /blobs", s.handlePostBlob)
 	mux.HandleFunc("POST /catalog", s.handlePostCatalog)
+	mux.HandleFunc("DELETE /catalog", s.handleDeleteCatalog)
+	mux.HandleFunc("PATCH /catalog", s.handleRenameCatalog)
+	mux.HandleFunc("GET /admin/holds", s.handleListHolds)
+	mux.HandleFunc("GET /admin/holds/events", s.handleHoldEvents)
+	mux.HandleFunc("POST /admin/holds", s.handlePlaceHold)
+	mux.HandleFunc("POST /admin/holds/release", s.handleReleaseHold)
 
 	handler := Chain(mux,
 		s.RecoveryMiddleware,
 		s.LoggingMiddleware,
 		s.CORSMiddleware,
 		s.AuthMiddleware,
+		s.LockMiddleware,
 	)
 
 	return handler
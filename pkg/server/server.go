@@ -6,10 +6,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+	"github.com/SteliosSpanos/mini-CAS/pkg/snapshot"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
 )
 
 type Config struct {
@@ -22,6 +27,9 @@ type Config struct {
 	RepoPath     string
 	TLSCert      string
 	TLSKey       string
+	// TierInterval is how often idle blobs are demoted to the cold tier;
+	// zero disables the background task.
+	TierInterval time.Duration
 }
 
 func (c Config) TLSEnabled() bool {
@@ -32,8 +40,14 @@ type Server struct {
 	config     Config
 	httpServer *http.Server
 	catalog    *catalog.Catalog
+	store      *storage.Store
 	casDir     string
 	logger     *log.Logger
+	// lockTimeout is how long a write waits for maintenance holding the
+	// repository lock.
+	lockTimeout time.Duration
+	serveLock   *path.Lock
+	releaseOnce sync.Once
 }
 
 func NewServer(config Config) (*Server, error) {
@@ -42,18 +56,53 @@ func NewServer(config Config) (*Server, error) {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
 
+	// Held until Shutdown, so a schema migration cannot start underneath
+	// the catalog this server has open.
+	serveLock, err := path.AcquireServeLock(repo.RootDir, path.LockShared)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := storage.Open(repo.RootDir)
+	if err != nil {
+		serveLock.Unlock()
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
 	cat := catalog.NewCatalog(repo.RootDir)
 	if err := cat.Load(); err != nil {
+		store.Close()
+		serveLock.Unlock()
 		return nil, fmt.Errorf("failed to load catalog: %w", err)
 	}
 
+	store.SetReferences(snapshot.References(cat, store))
+	store.SetHolds(cat)
+
 	logger := log.New(os.Stdout, "[CAS-SERVER]", log.LstdFlags)
 
+	h, err := hooks.Load(repo.RootDir)
+	if err != nil {
+		cat.Close()
+		store.Close()
+		serveLock.Unlock()
+		return nil, fmt.Errorf("failed to load hooks: %w", err)
+	}
+	h.OnError = func(name string, event hooks.Event, err error) {
+		logger.Printf("Hook %s failed for %s %s: %v", name, event.Kind, objects.ShortDigest(event.Hash), err)
+	}
+	store.SetHooks(h)
+	cat.SetHooks(h)
+	cat.SetMaxVersions(repo.Config.MaxVersions)
+
 	server := &Server{
-		config:  config,
-		catalog: cat,
-		casDir:  repo.RootDir,
-		logger:  logger,
+		config:      config,
+		catalog:     cat,
+		store:       store,
+		casDir:      repo.RootDir,
+		logger:      logger,
+		lockTimeout: repo.Config.LockTimeout(),
+		serveLock:   serveLock,
 	}
 
 	return server, nil
@@ -75,6 +124,10 @@ func (s *Server) Start(ctx context.Context) error {
 		s.logger.Printf("Starting HTTP server on %s:%d", s.config.Host, s.config.Port)
 	}
 
+	if s.config.TierInterval > 0 && s.store.Tiered() {
+		go s.runTiering(ctx)
+	}
+
 	errChan := make(chan error, 1)
 	go func() {
 		var err error
@@ -94,23 +147,69 @@ func (s *Server) Start(ctx context.Context) error {
 		s.logger.Println("Shutdown signal received, gracefully stopping server...")
 		return s.Shutdown(context.Background())
 	case err := <-errChan:
+		s.release()
 		return fmt.Errorf("server error: %w", err)
 	}
 }
 
+// Shutdown stops the HTTP server, if it was started, and releases the
+// store, the catalog and the serve lock whether or not it stopped cleanly.
 func (s *Server) Shutdown(ctx context.Context) error {
-	if s.httpServer == nil {
-		return nil
+	var err error
+	if s.httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		s.logger.Println("Shutting down server...")
+		if shutdownErr := s.httpServer.Shutdown(shutdownCtx); shutdownErr != nil {
+			err = fmt.Errorf("server shutdown failed: %w", shutdownErr)
+		}
 	}
 
-	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+	s.release()
 
-	s.logger.Println("Shutting down server...")
-	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
-		return fmt.Errorf("server shutdown failed: %w", err)
+	if err != nil {
+		return err
 	}
-
 	s.logger.Println("Server stopped")
 	return nil
 }
+
+// release closes what NewServer opened. It runs once, however many paths
+// reach it.
+func (s *Server) release() {
+	s.releaseOnce.Do(func() {
+		s.store.Close()
+		s.catalog.Close()
+		if s.serveLock != nil {
+			s.serveLock.Unlock()
+		}
+	})
+}
+
+func (s *Server) runTiering(ctx context.Context) {
+	ticker := time.NewTicker(s.config.TierInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lock, err := path.AcquireLock(s.casDir, path.LockShared, s.lockTimeout)
+			if err != nil {
+				s.logger.Printf("Tiering skipped: %v", err)
+				continue
+			}
+			result, err := s.store.DemoteIdle(s.store.DemoteAfter(), false)
+			lock.Unlock()
+			if err != nil {
+				s.logger.Printf("Tiering failed: %v", err)
+				continue
+			}
+			if len(result.Demoted) > 0 {
+				s.logger.Printf("Demoted %d blobs to the cold tier", len(result.Demoted))
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+const (
+	// Head is the catalog ref naming the latest snapshot.
+	Head = "HEAD"
+
+	objectType = "snapshot"
+)
+
+var (
+	ErrNothingToCommit = errors.New("nothing to commit: catalog matches the latest snapshot")
+	ErrNoSnapshots     = errors.New("no snapshots have been committed")
+	ErrNotSnapshot     = errors.New("object is not a snapshot")
+)
+
+// Snapshot is the whole catalog as it stood when it was committed. It is
+// stored as an ordinary blob, so its hash names exactly that catalog and
+// everything before it.
+type Snapshot struct {
+	// Hash is the snapshot's own digest; it is not part of the object.
+	Hash    string          `json:"-"`
+	Type    string          `json:"type"`
+	Parent  string          `json:"parent,omitempty"`
+	Time    time.Time       `json:"time"`
+	Author  string          `json:"author"`
+	Message string          `json:"message"`
+	Entries []catalog.Entry `json:"entries"`
+}
+
+// Entry returns the entry recorded for path.
+func (s Snapshot) Entry(path string) (catalog.Entry, bool) {
+	i := sort.Search(len(s.Entries), func(i int) bool {
+		return s.Entries[i].Filepath >= path
+	})
+	if i < len(s.Entries) && s.Entries[i].Filepath == path {
+		return s.Entries[i], true
+	}
+	return catalog.Entry{}, false
+}
+
+// Commit stores the current catalog as a snapshot and moves HEAD to it.
+func Commit(cat *catalog.Catalog, store *storage.Store, author, message string) (Snapshot, error) {
+	if strings.TrimSpace(message) == "" {
+		return Snapshot{}, fmt.Errorf("a commit message is required")
+	}
+
+	entries, err := cat.ListEntries()
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list entries: %w", err)
+	}
+	if entries == nil {
+		entries = []catalog.Entry{}
+	}
+
+	parent, err := cat.Ref(Head)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read %s: %w", Head, err)
+	}
+
+	if parent != "" {
+		previous, err := Load(store, parent)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if sameEntries(previous.Entries, entries) {
+			return Snapshot{}, ErrNothingToCommit
+		}
+	}
+
+	snap := Snapshot{
+		Type:    objectType,
+		Parent:  parent,
+		Time:    time.Now().UTC(),
+		Author:  author,
+		Message: message,
+		Entries: entries,
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	snap.Hash, err = store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to store snapshot: %w", err)
+	}
+
+	// A concurrent commit that moved HEAD first wins; this one is left as
+	// an unreferenced object for gc.
+	if err := cat.UpdateRef(Head, parent, snap.Hash); err != nil {
+		return Snapshot{}, err
+	}
+
+	return snap, nil
+}
+
+func sameEntries(a, b []catalog.Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.Filepath != y.Filepath || x.Hash != y.Hash || x.Filesize != y.Filesize ||
+			!x.ModTime.Equal(y.ModTime) || x.Mode != y.Mode || x.Type != y.Type ||
+			x.UID != y.UID || x.GID != y.GID || x.Target != y.Target ||
+			!maps.EqualFunc(x.Xattrs, y.Xattrs, bytes.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+func Load(store *storage.Store, hash string) (Snapshot, error) {
+	data, err := store.ReadBlob(hash)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read snapshot %s: %w", objects.ShortDigest(hash), err)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Type != objectType {
+		return Snapshot{}, fmt.Errorf("%w: %s", ErrNotSnapshot, objects.ShortDigest(hash))
+	}
+
+	snap.Hash = objects.CanonicalDigest(hash)
+	return snap, nil
+}
+
+// Log returns the snapshots reachable from HEAD, newest first.
+func Log(cat *catalog.Catalog, store *storage.Store) ([]Snapshot, error) {
+	head, err := cat.Ref(Head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", Head, err)
+	}
+
+	var history []Snapshot
+	for hash := head; hash != ""; {
+		snap, err := Load(store, hash)
+		if err != nil {
+			return history, err
+		}
+		history = append(history, snap)
+		hash = snap.Parent
+	}
+
+	return history, nil
+}
+
+// Resolve finds the snapshot a ref names: HEAD, HEAD~n for the nth parent of
+// HEAD, a full digest, or a prefix of one that matches a single snapshot in
+// the history.
+func Resolve(cat *catalog.Catalog, store *storage.Store, ref string) (Snapshot, error) {
+	if ref == "" {
+		return Snapshot{}, fmt.Errorf("empty snapshot ref")
+	}
+
+	if rest, ok := strings.CutPrefix(ref, Head); ok {
+		back := 0
+		if rest != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(rest, "~"))
+			if !strings.HasPrefix(rest, "~") || err != nil || n < 0 {
+				return Snapshot{}, fmt.Errorf("invalid snapshot ref: %s", ref)
+			}
+			back = n
+		}
+
+		history, err := Log(cat, store)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if len(history) == 0 {
+			return Snapshot{}, ErrNoSnapshots
+		}
+		if back >= len(history) {
+			return Snapshot{}, fmt.Errorf("%s goes back further than the %d snapshots in the history", ref, len(history))
+		}
+		return history[back], nil
+	}
+
+	if objects.ValidDigest(ref) {
+		return Load(store, ref)
+	}
+
+	history, err := Log(cat, store)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var match *Snapshot
+	for i, snap := range history {
+		_, value, found := strings.Cut(snap.Hash, ":")
+		if strings.HasPrefix(snap.Hash, ref) || (found && strings.HasPrefix(value, ref)) {
+			if match != nil {
+				return Snapshot{}, fmt.Errorf("ambiguous snapshot ref: %s", ref)
+			}
+			match = &history[i]
+		}
+	}
+	if match == nil {
+		return Snapshot{}, fmt.Errorf("no snapshot matches %s", ref)
+	}
+
+	return *match, nil
+}
+
+// Reachable lists the snapshots in the history and every blob their entries
+// point at, which gc must keep even once the catalog has moved on.
+func Reachable(cat *catalog.Catalog, store *storage.Store) ([]string, error) {
+	history, err := Log(cat, store)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var hashes []string
+	add := func(hash string) {
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+
+	for _, snap := range history {
+		add(snap.Hash)
+		for _, entry := range snap.Entries {
+			add(objects.CanonicalDigest(entry.Hash))
+		}
+	}
+
+	return hashes, nil
+}
+
+// References returns a storage.ReferenceSource covering the catalog and its
+// snapshot history, so a size limit never evicts a blob that a snapshot can
+// still restore.
+func References(cat *catalog.Catalog, store *storage.Store) storage.ReferenceSource {
+	return references{cat: cat, store: store}
+}
+
+type references struct {
+	cat   *catalog.Catalog
+	store *storage.Store
+}
+
+func (r references) Hashes() ([]string, error) {
+	hashes, err := r.cat.Hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	reachable, err := Reachable(r.cat, r.store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot history: %w", err)
+	}
+
+	return append(hashes, reachable...), nil
+}
@@ -0,0 +1,234 @@
+package snapshot
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+	"github.com/SteliosSpanos/mini-CAS/pkg/storage"
+)
+
+func setupSnapshot(t *testing.T) (*catalog.Catalog, *storage.Store) {
+	t.Helper()
+
+	casDir := t.TempDir()
+
+	backend, err := storage.NewFSBackend(filepath.Join(casDir, "storage"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	cat := catalog.NewCatalog(casDir)
+	t.Cleanup(func() { cat.Close() })
+
+	return cat, storage.NewStore(backend, storage.Options{})
+}
+
+func addFile(t *testing.T, cat *catalog.Catalog, store *storage.Store, path, content string) string {
+	t.Helper()
+
+	hash, err := store.WriteBlobStream(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	entry := catalog.Entry{Filepath: path, Hash: hash, Filesize: uint64(len(content)), ModTime: time.Now()}
+	if err := cat.AddEntry(entry); err != nil {
+		t.Fatalf("AddEntry() error: %v", err)
+	}
+	return hash
+}
+
+func readAt(t *testing.T, cat *catalog.Catalog, store *storage.Store, ref, path string) string {
+	t.Helper()
+
+	snap, err := Resolve(cat, store, ref)
+	if err != nil {
+		t.Fatalf("Resolve(%q) error: %v", ref, err)
+	}
+	entry, ok := snap.Entry(path)
+	if !ok {
+		t.Fatalf("Entry(%q) not found in %s", path, ref)
+	}
+
+	reader, err := store.OpenBlob(entry.Hash)
+	if err != nil {
+		t.Fatalf("OpenBlob() error: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	return string(data)
+}
+
+func TestCommitAndLog(t *testing.T) {
+	cat, store := setupSnapshot(t)
+
+	if history, err := Log(cat, store); err != nil || len(history) != 0 {
+		t.Fatalf("Log() of new catalog = %v, %v; want empty", history, err)
+	}
+
+	addFile(t, cat, store, "a.txt", "one")
+	first, err := Commit(cat, store, "alice", "first")
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	if first.Parent != "" {
+		t.Errorf("first snapshot parent = %q, want none", first.Parent)
+	}
+
+	if _, err := Commit(cat, store, "alice", "again"); !errors.Is(err, ErrNothingToCommit) {
+		t.Errorf("Commit() of unchanged catalog error = %v, want ErrNothingToCommit", err)
+	}
+
+	addFile(t, cat, store, "a.txt", "two")
+	addFile(t, cat, store, "b.txt", "new")
+	second, err := Commit(cat, store, "bob", "second")
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	if second.Parent != first.Hash {
+		t.Errorf("second snapshot parent = %s, want %s", second.Parent, first.Hash)
+	}
+
+	history, err := Log(cat, store)
+	if err != nil {
+		t.Fatalf("Log() error: %v", err)
+	}
+	if len(history) != 2 || history[0].Hash != second.Hash || history[1].Hash != first.Hash {
+		t.Fatalf("Log() = %v, want second then first", history)
+	}
+	if history[1].Author != "alice" || history[1].Message != "first" || len(history[1].Entries) != 1 {
+		t.Errorf("Log() first snapshot = %+v", history[1])
+	}
+
+	if got := readAt(t, cat, store, "HEAD~1", "a.txt"); got != "one" {
+		t.Errorf("a.txt at HEAD~1 = %q, want %q", got, "one")
+	}
+	if got := readAt(t, cat, store, "HEAD", "a.txt"); got != "two" {
+		t.Errorf("a.txt at HEAD = %q, want %q", got, "two")
+	}
+	if got := readAt(t, cat, store, first.Hash, "a.txt"); got != "one" {
+		t.Errorf("a.txt at %s = %q, want %q", first.Hash, got, "one")
+	}
+	if _, ok := history[1].Entry("b.txt"); ok {
+		t.Error("first snapshot has b.txt, which was added later")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cat, store := setupSnapshot(t)
+
+	if _, err := Resolve(cat, store, "HEAD"); !errors.Is(err, ErrNoSnapshots) {
+		t.Errorf("Resolve(HEAD) with no snapshots error = %v, want ErrNoSnapshots", err)
+	}
+
+	addFile(t, cat, store, "a.txt", "one")
+	snap, err := Commit(cat, store, "alice", "first")
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	for _, ref := range []string{"HEAD", "HEAD~0", snap.Hash, snap.Hash[:8]} {
+		got, err := Resolve(cat, store, ref)
+		if err != nil {
+			t.Errorf("Resolve(%q) error: %v", ref, err)
+			continue
+		}
+		if got.Hash != snap.Hash {
+			t.Errorf("Resolve(%q) = %s, want %s", ref, got.Hash, snap.Hash)
+		}
+	}
+
+	for _, ref := range []string{"HEAD~1", "HEAD^", "HEAD~x", "ffffffff"} {
+		if _, err := Resolve(cat, store, ref); err == nil {
+			t.Errorf("Resolve(%q) succeeded, want error", ref)
+		}
+	}
+
+	blob := addFile(t, cat, store, "b.txt", "not a snapshot")
+	if _, err := Resolve(cat, store, blob); !errors.Is(err, ErrNotSnapshot) {
+		t.Errorf("Resolve() of a plain blob error = %v, want ErrNotSnapshot", err)
+	}
+}
+
+func TestCommit_RefMoved(t *testing.T) {
+	cat, store := setupSnapshot(t)
+
+	addFile(t, cat, store, "a.txt", "one")
+	snap, err := Commit(cat, store, "alice", "first")
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	if err := cat.UpdateRef(Head, "", snap.Hash); !errors.Is(err, catalog.ErrRefMoved) {
+		t.Errorf("UpdateRef() with stale old value error = %v, want ErrRefMoved", err)
+	}
+}
+
+func TestReferences_QuotaKeepsSnapshots(t *testing.T) {
+	casDir := t.TempDir()
+
+	backend, err := storage.NewFSBackend(filepath.Join(casDir, "storage"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+	access, err := storage.OpenAccessLog(filepath.Join(casDir, storage.AccessLogFile))
+	if err != nil {
+		t.Fatalf("OpenAccessLog() error: %v", err)
+	}
+
+	cat := catalog.NewCatalog(casDir)
+	t.Cleanup(func() { cat.Close() })
+	cat.SetMaxVersions(1)
+
+	store := storage.NewStore(backend, storage.Options{MaxSize: 200})
+	if err := store.SetAccessLog(access); err != nil {
+		t.Fatalf("SetAccessLog() error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	store.SetReferences(References(cat, store))
+
+	old := addFile(t, cat, store, "a.txt", strings.Repeat("a", 60))
+	snap, err := Commit(cat, store, "alice", "first")
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	// With one version kept, only the snapshot still points at old.
+	addFile(t, cat, store, "a.txt", strings.Repeat("b", 60))
+
+	junk, err := store.WriteBlobStream(strings.NewReader(strings.Repeat("c", 60)))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	// Age everything past the eviction grace period; Record only ever moves
+	// the access time forward.
+	for _, hash := range []string{old, snap.Hash, junk} {
+		record, ok, err := access.Get(hash)
+		if err != nil || !ok {
+			t.Fatalf("Get(%s) = %v, %v", hash, ok, err)
+		}
+		access.Forget(hash)
+		access.Record(hash, record.StoredSize, time.Now().Add(-time.Hour))
+	}
+
+	if _, err := store.WriteBlobStream(strings.NewReader(strings.Repeat("d", 60))); err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	for hash, want := range map[string]bool{old: true, snap.Hash: true, junk: false} {
+		if exists, _ := store.Exists(hash); exists != want {
+			t.Errorf("Exists(%s) = %v, want %v", hash[:8], exists, want)
+		}
+	}
+	if got := readAt(t, cat, store, "HEAD", "a.txt"); got != strings.Repeat("a", 60) {
+		t.Errorf("a.txt at HEAD = %q, want the committed content", got)
+	}
+}
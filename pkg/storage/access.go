@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// AccessLogFile is kept apart from catalog.db and outside its versioned
+// schema. Its tables are created on open and never migrated; if the file is
+// lost, access times are seeded again from the stored objects, though pins
+// are not.
+const AccessLogFile = "access.db"
+
+// AccessLog remembers when each blob was last written or read, how much space
+// it takes, and which blobs are pinned. It lives beside the catalog in SQLite
+// so every process using the repository shares one view.
+type AccessLog struct {
+	db *sql.DB
+}
+
+type AccessRecord struct {
+	Hash       string
+	StoredSize int64
+	LastAccess time.Time
+}
+
+func OpenAccessLog(dbPath string) (*AccessLog, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+
+	pragmas := []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+		"PRAGMA busy_timeout=5000",
+	}
+
+	for _, p := range pragmas {
+		db.Exec(p)
+	}
+
+	schema := `
+			CREATE TABLE IF NOT EXISTS access (
+					hash TEXT PRIMARY KEY NOT NULL,
+					stored_size INTEGER NOT NULL,
+					atime INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_atime ON access(atime);
+			CREATE TABLE IF NOT EXISTS pins (
+					hash TEXT PRIMARY KEY NOT NULL
+			);
+	`
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create access log schema: %w", err)
+	}
+
+	return &AccessLog{db: db}, nil
+}
+
+func (l *AccessLog) Record(hash string, storedSize int64, at time.Time) error {
+	_, err := l.db.Exec(`
+			INSERT INTO access (hash, stored_size, atime)
+			VALUES (?, ?, ?)
+			ON CONFLICT(hash) DO UPDATE SET
+					stored_size = excluded.stored_size,
+					atime = MAX(atime, excluded.atime)
+	`, hash, storedSize, at.UnixNano())
+	return err
+}
+
+func (l *AccessLog) Forget(hash string) error {
+	_, err := l.db.Exec("DELETE FROM access WHERE hash = ?", hash)
+	return err
+}
+
+func (l *AccessLog) Get(hash string) (AccessRecord, bool, error) {
+	var record AccessRecord
+	var atime int64
+
+	err := l.db.QueryRow(
+		"SELECT hash, stored_size, atime FROM access WHERE hash = ?", hash,
+	).Scan(&record.Hash, &record.StoredSize, &atime)
+
+	if err == sql.ErrNoRows {
+		return AccessRecord{}, false, nil
+	}
+	if err != nil {
+		return AccessRecord{}, false, err
+	}
+
+	record.LastAccess = time.Unix(0, atime)
+	return record, true, nil
+}
+
+// LeastRecent lists tracked blobs from the longest unused to the most
+// recently used.
+func (l *AccessLog) LeastRecent() ([]AccessRecord, error) {
+	rows, err := l.db.Query("SELECT hash, stored_size, atime FROM access ORDER BY atime")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []AccessRecord
+	for rows.Next() {
+		var record AccessRecord
+		var atime int64
+
+		if err := rows.Scan(&record.Hash, &record.StoredSize, &atime); err != nil {
+			return nil, err
+		}
+
+		record.LastAccess = time.Unix(0, atime)
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (l *AccessLog) Usage() (int64, int, error) {
+	var size int64
+	var count int
+
+	err := l.db.QueryRow("SELECT COALESCE(SUM(stored_size), 0), COUNT(*) FROM access").Scan(&size, &count)
+	return size, count, err
+}
+
+func (l *AccessLog) Pin(hash string) error {
+	_, err := l.db.Exec("INSERT OR IGNORE INTO pins (hash) VALUES (?)", hash)
+	return err
+}
+
+func (l *AccessLog) Unpin(hash string) error {
+	_, err := l.db.Exec("DELETE FROM pins WHERE hash = ?", hash)
+	return err
+}
+
+func (l *AccessLog) Pinned() ([]string, error) {
+	rows, err := l.db.Query("SELECT hash FROM pins ORDER BY hash")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
+
+func (l *AccessLog) Close() error {
+	return l.db.Close()
+}
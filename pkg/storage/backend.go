@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Backend interface {
+	Create() (ObjectWriter, error)
+	Open(key string) (io.ReadCloser, error)
+	Stat(key string) (ObjectInfo, error)
+	Delete(key string) error
+	Walk(fn func(ObjectInfo) error) error
+	Touch(key string) error
+}
+
+// ObjectWriter stages the content of a new object. The object only becomes
+// visible under its key once Commit succeeds; committing a key that already
+// exists discards the staged data. WriteAt lets encoders patch a header once
+// the full stream has been written.
+type ObjectWriter interface {
+	io.Writer
+	io.WriterAt
+	Commit(key string) error
+	Abort() error
+}
+
+type ObjectInfo struct {
+	Key        string
+	Size       int64
+	StoredSize int64
+	ModTime    time.Time
+}
+
+// Unwrap finds the backend of type T under any wrapping layers: encryption,
+// inlining, the hot tier of a tiered backend and the loose store of a packed
+// one. Erasure coding spreads objects over several roots, so the search
+// stops there.
+func Unwrap[T Backend](b Backend) (T, bool) {
+	for b != nil {
+		if found, ok := b.(T); ok {
+			return found, true
+		}
+
+		switch layer := b.(type) {
+		case *EncryptedBackend:
+			b = layer.Inner()
+		case *InlineBackend:
+			b = layer.Inner()
+		case *TieredBackend:
+			b = layer.Hot()
+		case *PackBackend:
+			b = layer.Loose()
+		default:
+			b = nil
+		}
+	}
+
+	var none T
+	return none, false
+}
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) == 1 {
+		f.list(w, r)
+		return
+	}
+	key := parts[1]
+
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("x-amz-copy-source"); source != "" {
+			data, ok := f.objects[strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)[1]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.objects[key] = data
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	const pageSize = 2
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(keys, token)
+	}
+
+	var result listBucketResult
+	for i := start; i < len(keys) && i < start+pageSize; i++ {
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{Key: keys[i], Size: int64(len(f.objects[keys[i]])), LastModified: time.Now().UTC()})
+	}
+
+	if start+pageSize < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[start+pageSize]
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func testBackends(t *testing.T) map[string]Backend {
+	t.Helper()
+
+	fsBackend, err := NewFSBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	s3Backend, err := NewS3Backend(S3Config{
+		Endpoint:  newFakeS3(t).URL,
+		Bucket:    "cas",
+		Prefix:    "objects/",
+		AccessKey: "test-key",
+		SecretKey: "test-secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Backend() error: %v", err)
+	}
+
+	return map[string]Backend{
+		"fs":     fsBackend,
+		"memory": NewMemoryBackend(),
+		"s3":     s3Backend,
+	}
+}
+
+func TestBackends_RoundTrip(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := NewStore(backend, Options{})
+
+			contents := []string{"first object", "second object", "third object"}
+			hashes := make(map[string]bool)
+
+			for _, content := range contents {
+				hash, err := store.WriteBlobStream(strings.NewReader(content))
+				if err != nil {
+					t.Fatalf("WriteBlobStream() error: %v", err)
+				}
+				hashes[hash] = true
+
+				data, err := store.ReadBlob(hash)
+				if err != nil {
+					t.Fatalf("ReadBlob() error: %v", err)
+				}
+				if string(data) != content {
+					t.Errorf("ReadBlob() = %q, want %q", data, content)
+				}
+
+				info, err := store.Stat(hash)
+				if err != nil {
+					t.Fatalf("Stat() error: %v", err)
+				}
+				if info.Size != int64(len(content)) {
+					t.Errorf("Stat().Size = %d, want %d", info.Size, len(content))
+				}
+			}
+
+			walked := 0
+			err := store.Walk(func(info ObjectInfo) error {
+				if !hashes[info.Key] {
+					t.Errorf("Walk() returned unexpected key %q", info.Key)
+				}
+				walked++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error: %v", err)
+			}
+			if walked != len(contents) {
+				t.Errorf("Walk() visited %d objects, want %d", walked, len(contents))
+			}
+		})
+	}
+}
+
+func TestBackends_Delete(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := NewStore(backend, Options{})
+
+			hash, err := store.WriteBlobStream(strings.NewReader("delete me"))
+			if err != nil {
+				t.Fatalf("WriteBlobStream() error: %v", err)
+			}
+
+			if err := store.Delete(hash); err != nil {
+				t.Fatalf("Delete() error: %v", err)
+			}
+
+			exists, err := store.Exists(hash)
+			if err != nil {
+				t.Fatalf("Exists() error: %v", err)
+			}
+			if exists {
+				t.Error("Exists() = true after Delete()")
+			}
+
+			if _, err := store.OpenBlob(hash); !errors.Is(err, ErrNotFound) {
+				t.Errorf("OpenBlob() error = %v, want ErrNotFound", err)
+			}
+
+			if err := store.Delete(hash); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestFSBackend_AbortRemovesTempFile(t *testing.T) {
+	backend, err := NewFSBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	writer, err := backend.Create()
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	writer.Write([]byte("partial"))
+
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Abort() error: %v", err)
+	}
+
+	err = backend.Walk(func(info ObjectInfo) error {
+		t.Errorf("unexpected object %q after Abort()", info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error: %v", err)
+	}
+}
+
+func TestFSBackend_DurabilityModes(t *testing.T) {
+	for _, mode := range []string{DurabilityNone, DurabilityFile, DurabilityFull} {
+		t.Run(mode, func(t *testing.T) {
+			backend, err := NewFSBackend(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewFSBackend() error: %v", err)
+			}
+			if err := backend.SetDurability(mode); err != nil {
+				t.Fatalf("SetDurability() error: %v", err)
+			}
+
+			store := NewStore(backend, Options{})
+			hash, err := store.WriteBlobStream(strings.NewReader("durable content"))
+			if err != nil {
+				t.Fatalf("WriteBlobStream() error: %v", err)
+			}
+
+			data, err := store.ReadBlob(hash)
+			if err != nil || string(data) != "durable content" {
+				t.Errorf("ReadBlob() = %q, %v", data, err)
+			}
+		})
+	}
+
+	backend, _ := NewFSBackend(t.TempDir())
+	if err := backend.SetDurability("sometimes"); err == nil {
+		t.Error("SetDurability() accepted an unknown mode")
+	}
+}
+
+func TestOpen_RemovesStaleTempFiles(t *testing.T) {
+	casDir := t.TempDir()
+	storageDir := filepath.Join(casDir, "storage")
+	packDir := filepath.Join(storageDir, packDirName)
+
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		t.Fatalf("os.MkdirAll() error: %v", err)
+	}
+
+	old := time.Now().Add(-2 * StaleTempAge)
+	files := map[string]bool{
+		filepath.Join(storageDir, "tmp-interrupted"):   false,
+		filepath.Join(packDir, "tmp-pack-interrupted"): false,
+		filepath.Join(storageDir, "tmp-in-progress"):   true,
+	}
+
+	for file, fresh := range files {
+		if err := os.WriteFile(file, []byte("partial"), 0644); err != nil {
+			t.Fatalf("os.WriteFile() error: %v", err)
+		}
+		if !fresh {
+			os.Chtimes(file, old, old)
+		}
+	}
+
+	if _, err := Open(casDir); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	for file, fresh := range files {
+		_, err := os.Stat(file)
+		if exists := err == nil; exists != fresh {
+			t.Errorf("%s exists = %v, want %v", filepath.Base(file), exists, fresh)
+		}
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	dir := t.TempDir()
+
+	loose, err := NewFSBackend(filepath.Join(dir, "hot"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+	cold, err := NewFSBackend(filepath.Join(dir, "cold"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+	packs := NewPackBackend(loose)
+	tiers := NewTieredBackend(packs, cold)
+	enc, err := NewEncryptedBackend(tiers, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewEncryptedBackend() error: %v", err)
+	}
+
+	if got, ok := Unwrap[*FSBackend](enc); !ok || got != loose {
+		t.Errorf("Unwrap[*FSBackend]() = %v, %v, want the hot loose store", got, ok)
+	}
+	if got, ok := Unwrap[*PackBackend](enc); !ok || got != packs {
+		t.Errorf("Unwrap[*PackBackend]() = %v, %v, want the pack layer", got, ok)
+	}
+	if got, ok := Unwrap[*TieredBackend](enc); !ok || got != tiers {
+		t.Errorf("Unwrap[*TieredBackend]() = %v, %v, want the tier layer", got, ok)
+	}
+	if _, ok := Unwrap[*InlineBackend](enc); ok {
+		t.Error("Unwrap[*InlineBackend]() found a layer that is not there")
+	}
+	if _, ok := Unwrap[*FSBackend](NewMemoryBackend()); ok {
+		t.Error("Unwrap[*FSBackend]() found a filesystem under a memory backend")
+	}
+}
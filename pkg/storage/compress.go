@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionFlate = "flate"
+
+	compressedSuffix = ".z"
+)
+
+var compressedMagic = []byte("CASZ")
+
+const compressedHeaderSize = 13
+
+const (
+	codecGzip  byte = 1
+	codecFlate byte = 2
+)
+
+type compressedReader struct {
+	io.Reader
+	decompressor io.Closer
+	underlying   io.Closer
+}
+
+func (r *compressedReader) Close() error {
+	r.decompressor.Close()
+	return r.underlying.Close()
+}
+
+func ValidCompression(name string) bool {
+	switch name {
+	case "", CompressionNone, CompressionGzip, CompressionFlate:
+		return true
+	}
+	return false
+}
+
+func compressionEnabled(name string) bool {
+	return name == CompressionGzip || name == CompressionFlate
+}
+
+func encodeCompressedHeader(codec byte, logicalSize int64) []byte {
+	header := make([]byte, compressedHeaderSize)
+	copy(header, compressedMagic)
+	header[4] = codec
+	binary.BigEndian.PutUint64(header[5:], uint64(logicalSize))
+	return header
+}
+
+func readCompressedHeader(r io.Reader) (byte, int64, error) {
+	header := make([]byte, compressedHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, fmt.Errorf("failed to read compressed header: %w", err)
+	}
+
+	if !bytes.Equal(header[:4], compressedMagic) {
+		return 0, 0, fmt.Errorf("invalid compressed object header")
+	}
+
+	return header[4], int64(binary.BigEndian.Uint64(header[5:])), nil
+}
+
+func newCompressor(name string, w io.Writer) (io.WriteCloser, byte, error) {
+	switch name {
+	case CompressionGzip:
+		return gzip.NewWriter(w), codecGzip, nil
+	case CompressionFlate:
+		fw, err := flate.NewWriter(w, flate.DefaultCompression)
+		return fw, codecFlate, err
+	default:
+		return nil, 0, fmt.Errorf("unknown compression: %s", name)
+	}
+}
+
+func newDecompressor(codec byte, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case codecGzip:
+		return gzip.NewReader(r)
+	case codecFlate:
+		return flate.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown compression codec: %d", codec)
+	}
+}
+
+func openCompressed(rc io.ReadCloser) (io.ReadCloser, error) {
+	codec, _, err := readCompressedHeader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	decompressor, err := newDecompressor(codec, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &compressedReader{Reader: decompressor, decompressor: decompressor, underlying: rc}, nil
+}
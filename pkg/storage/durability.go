@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DurabilityNone = "none"
+	DurabilityFile = "file"
+	DurabilityFull = "full"
+)
+
+// StaleTempAge is how long a temp file must go unmodified before it is
+// treated as left behind by an interrupted write. An upload in progress keeps
+// touching its temp file, so only abandoned ones reach this age.
+const StaleTempAge = time.Hour
+
+func ValidDurability(mode string) bool {
+	switch mode {
+	case "", DurabilityNone, DurabilityFile, DurabilityFull:
+		return true
+	default:
+		return false
+	}
+}
+
+func syncsFiles(mode string) bool {
+	return mode == DurabilityFile || mode == DurabilityFull
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode, durability string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if syncsFiles(durability) {
+		if err := tmpFile.Sync(); err != nil {
+			tmpFile.Close()
+			return fmt.Errorf("failed to sync temp file: %w", err)
+		}
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Chmod(tmpFile.Name(), perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+
+	if durability == DurabilityFull {
+		return syncDir(filepath.Dir(path))
+	}
+
+	return nil
+}
+
+// removeStaleTemp deletes temp files in dir that have not been modified for
+// maxAge and returns how many were removed.
+func removeStaleTemp(dir string, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "tmp-") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
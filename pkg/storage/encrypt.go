@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	EncryptionKeyEnv = "CAS_ENCRYPTION_KEY"
+	DefaultKeyFile   = "keyfile"
+
+	encryptionKeySize = 32
+	encryptionVersion = 1
+	encryptionSalt    = 16
+	segmentSize       = 64 * 1024
+	nameSlotSize      = 256
+	tagSize           = 16
+
+	encryptedMagic = "CASE"
+
+	saltOffset  = len(encryptedMagic) + 1
+	slotOffset  = saltOffset + encryptionSalt
+	dataOffset  = slotOffset + nameSlotSize + tagSize
+	sealedChunk = segmentSize + tagSize
+
+	nonceMiddle byte = 0
+	nonceFinal  byte = 1
+	nonceName   byte = 2
+)
+
+// EncryptedBackend seals every object with AES-256-GCM before it reaches the
+// wrapped backend and stores it under an HMAC of its key, so neither the
+// content nor the plaintext SHA-256 is visible at rest.
+//
+// Objects are split into fixed-size segments sealed with a per-object key
+// derived from a random salt. Each nonce carries the segment index and a
+// final-segment flag, which rules out reordering and truncation. The object's
+// own key is sealed into the header so Walk can report logical keys and Open
+// can reject an object that was renamed onto another's name.
+type EncryptedBackend struct {
+	inner   Backend
+	dataKey []byte
+	nameKey []byte
+}
+
+type encryptedWriter struct {
+	backend *EncryptedBackend
+	inner   ObjectWriter
+	aead    cipher.AEAD
+	first   []byte
+	current []byte
+	index   uint64
+	done    bool
+}
+
+type decryptingReader struct {
+	src    *bufio.Reader
+	closer io.Closer
+	aead   cipher.AEAD
+	buf    []byte
+	plain  []byte
+	index  uint64
+	done   bool
+}
+
+func NewEncryptedBackend(inner Backend, key []byte) (*EncryptedBackend, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+
+	return &EncryptedBackend{
+		inner:   inner,
+		dataKey: hmacSHA256(key, "mini-cas data"),
+		nameKey: hmacSHA256(key, "mini-cas names"),
+	}, nil
+}
+
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// KeyID fingerprints a key so a repository can recognise the wrong one
+// before it tries to decrypt anything.
+func KeyID(key []byte) string {
+	return hex.EncodeToString(hmacSHA256(key, "mini-cas key id")[:8])
+}
+
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be hex encoded: %w", err)
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadKey reads the repository key from CAS_ENCRYPTION_KEY, falling back to
+// the keyfile.
+func LoadKey(keyFile string) ([]byte, error) {
+	if env := os.Getenv(EncryptionKeyEnv); env != "" {
+		return ParseKey(env)
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("repository is encrypted but no key was found in %s or %s", EncryptionKeyEnv, keyFile)
+		}
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	return ParseKey(string(data))
+}
+
+func WriteKeyFile(keyFile string, key []byte) error {
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	return nil
+}
+
+func (b *EncryptedBackend) Inner() Backend {
+	return b.inner
+}
+
+func (b *EncryptedBackend) name(key string) string {
+	return hex.EncodeToString(hmacSHA256(b.nameKey, key))
+}
+
+func (b *EncryptedBackend) objectCipher(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(hmacSHA256(b.dataKey, string(salt)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(index uint64, kind byte) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	nonce[8] = kind
+	return nonce
+}
+
+func (b *EncryptedBackend) Create() (ObjectWriter, error) {
+	salt := make([]byte, encryptionSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := b.objectCipher(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := b.inner.Create()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, dataOffset)
+	copy(header, encryptedMagic)
+	header[len(encryptedMagic)] = encryptionVersion
+	copy(header[saltOffset:], salt)
+
+	if _, err := inner.Write(header); err != nil {
+		inner.Abort()
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return &encryptedWriter{backend: b, inner: inner, aead: aead}, nil
+}
+
+// Write buffers plaintext a segment at a time. A full segment is only sealed
+// once more data arrives, so the last segment can be flagged as final. The
+// first segment stays in memory until Commit so WriteAt can still patch it.
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		buf := &w.current
+		if w.index == 0 {
+			buf = &w.first
+		}
+
+		if len(*buf) == segmentSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := min(segmentSize-len(*buf), len(p))
+		*buf = append(*buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *encryptedWriter) flush() error {
+	if w.index == 0 {
+		// Reserve room for the first segment, sealed later in Commit.
+		if _, err := w.inner.Write(make([]byte, sealedChunk)); err != nil {
+			return fmt.Errorf("failed to write segment: %w", err)
+		}
+	} else {
+		sealed := w.aead.Seal(nil, segmentNonce(w.index, nonceMiddle), w.current, nil)
+		if _, err := w.inner.Write(sealed); err != nil {
+			return fmt.Errorf("failed to write segment: %w", err)
+		}
+		w.current = w.current[:0]
+	}
+
+	w.index++
+	return nil
+}
+
+func (w *encryptedWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(w.first)) {
+		return 0, fmt.Errorf("encrypted objects can only rewrite their first %d bytes", segmentSize)
+	}
+
+	return copy(w.first[off:], p), nil
+}
+
+func (w *encryptedWriter) Commit(key string) error {
+	if w.done {
+		return fmt.Errorf("object writer already closed")
+	}
+	w.done = true
+
+	if len(key) > nameSlotSize-2 {
+		w.inner.Abort()
+		return fmt.Errorf("object key too long to encrypt: %s", key)
+	}
+
+	if w.index == 0 {
+		sealed := w.aead.Seal(nil, segmentNonce(0, nonceFinal), w.first, nil)
+		if _, err := w.inner.Write(sealed); err != nil {
+			w.inner.Abort()
+			return fmt.Errorf("failed to write segment: %w", err)
+		}
+	} else {
+		sealed := w.aead.Seal(nil, segmentNonce(w.index, nonceFinal), w.current, nil)
+		if _, err := w.inner.Write(sealed); err != nil {
+			w.inner.Abort()
+			return fmt.Errorf("failed to write segment: %w", err)
+		}
+
+		first := w.aead.Seal(nil, segmentNonce(0, nonceMiddle), w.first, nil)
+		if _, err := w.inner.WriteAt(first, int64(dataOffset)); err != nil {
+			w.inner.Abort()
+			return fmt.Errorf("failed to write segment: %w", err)
+		}
+	}
+
+	slot := make([]byte, nameSlotSize)
+	binary.BigEndian.PutUint16(slot, uint16(len(key)))
+	copy(slot[2:], key)
+
+	sealedName := w.aead.Seal(nil, segmentNonce(math.MaxUint64, nonceName), slot, nil)
+	if _, err := w.inner.WriteAt(sealedName, int64(slotOffset)); err != nil {
+		w.inner.Abort()
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return w.inner.Commit(w.backend.name(key))
+}
+
+func (w *encryptedWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	return w.inner.Abort()
+}
+
+// openHeader reads and authenticates an object header, returning the key the
+// object was committed under.
+func (b *EncryptedBackend) openHeader(src io.Reader) (cipher.AEAD, string, error) {
+	header := make([]byte, dataOffset)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, "", fmt.Errorf("failed to read encryption header: %w", err)
+	}
+
+	if string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, "", fmt.Errorf("object is not encrypted")
+	}
+	if header[len(encryptedMagic)] != encryptionVersion {
+		return nil, "", fmt.Errorf("unsupported encryption version %d", header[len(encryptedMagic)])
+	}
+
+	aead, err := b.objectCipher(header[saltOffset:slotOffset])
+	if err != nil {
+		return nil, "", err
+	}
+
+	slot, err := aead.Open(nil, segmentNonce(math.MaxUint64, nonceName), header[slotOffset:], nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt object header (wrong key?): %w", err)
+	}
+
+	n := int(binary.BigEndian.Uint16(slot))
+	if n > nameSlotSize-2 {
+		return nil, "", fmt.Errorf("corrupt object header")
+	}
+
+	return aead, string(slot[2 : 2+n]), nil
+}
+
+// KeyOf reads the logical key sealed into the object stored under name in
+// the wrapped backend.
+func (b *EncryptedBackend) KeyOf(name string) (string, error) {
+	rc, err := b.inner.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	_, key, err := b.openHeader(rc)
+	return key, err
+}
+
+func (b *EncryptedBackend) Open(key string) (io.ReadCloser, error) {
+	rc, err := b.inner.Open(b.name(key))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, err
+	}
+
+	src := bufio.NewReaderSize(rc, sealedChunk)
+
+	aead, stored, err := b.openHeader(src)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	if stored != key {
+		rc.Close()
+		return nil, fmt.Errorf("object stored as %s does not belong to %s", stored, key)
+	}
+
+	return &decryptingReader{src: src, closer: rc, aead: aead, buf: make([]byte, sealedChunk)}, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) next() error {
+	n, err := io.ReadFull(r.src, r.buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted object is truncated")
+		}
+		return err
+	}
+
+	final := err == io.ErrUnexpectedEOF
+	if !final {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			final = true
+		}
+	}
+
+	kind := nonceMiddle
+	if final {
+		kind = nonceFinal
+	}
+
+	plain, err := r.aead.Open(r.buf[:0], segmentNonce(r.index, kind), r.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt segment %d: %w", r.index, err)
+	}
+
+	r.plain = plain
+	r.index++
+	r.done = final
+	return nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.closer.Close()
+}
+
+// plaintextSize recovers the logical size of an object from its size at rest.
+func plaintextSize(stored int64) int64 {
+	body := stored - int64(dataOffset)
+	if body <= 0 {
+		return 0
+	}
+
+	size := body / sealedChunk * segmentSize
+	if rem := body % sealedChunk; rem > 0 {
+		size += max(rem-int64(tagSize), 0)
+	}
+	return size
+}
+
+func (b *EncryptedBackend) Stat(key string) (ObjectInfo, error) {
+	info, err := b.inner.Stat(b.name(key))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Key:        key,
+		Size:       plaintextSize(info.Size),
+		StoredSize: info.Size,
+		ModTime:    info.ModTime,
+	}, nil
+}
+
+func (b *EncryptedBackend) Delete(key string) error {
+	if err := b.inner.Delete(b.name(key)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *EncryptedBackend) Touch(key string) error {
+	return b.inner.Touch(b.name(key))
+}
+
+// Walk reads each object's header to report the key it was written under.
+// An object that cannot be decrypted aborts the walk rather than being
+// reported under its opaque name, so a wrong key can never make live objects
+// look unreferenced.
+func (b *EncryptedBackend) Walk(fn func(ObjectInfo) error) error {
+	return b.inner.Walk(func(info ObjectInfo) error {
+		rc, err := b.inner.Open(info.Key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		_, key, err := b.openHeader(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", info.Key, err)
+		}
+
+		return fn(ObjectInfo{
+			Key:        key,
+			Size:       plaintextSize(info.Size),
+			StoredSize: info.Size,
+			ModTime:    info.ModTime,
+		})
+	})
+}
+
+func (b *EncryptedBackend) Repack(maxObjectSize int64) (RepackResult, error) {
+	repacker, ok := b.inner.(interface {
+		Repack(maxObjectSize int64) (RepackResult, error)
+	})
+	if !ok {
+		return RepackResult{}, fmt.Errorf("storage backend does not support packs")
+	}
+
+	return repacker.Repack(maxObjectSize)
+}
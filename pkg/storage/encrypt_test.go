@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func newEncryptedStore(t *testing.T, opts Options) (*Store, *MemoryBackend, []byte) {
+	t.Helper()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+
+	inner := NewMemoryBackend()
+	backend, err := NewEncryptedBackend(inner, key)
+	if err != nil {
+		t.Fatalf("NewEncryptedBackend() error: %v", err)
+	}
+
+	return NewStore(backend, opts), inner, key
+}
+
+func TestEncryptedBackend_RoundTrip(t *testing.T) {
+	sizes := []int{0, 100, segmentSize, segmentSize + 1, 3*segmentSize + 7}
+
+	for _, opts := range []Options{{}, {Compression: CompressionGzip}, {Chunking: true, ChunkAvgSize: 16 * 1024}} {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%+v/%d", opts, size), func(t *testing.T) {
+				store, _, _ := newEncryptedStore(t, opts)
+
+				data := make([]byte, size)
+				rand.New(rand.NewSource(int64(size))).Read(data)
+
+				hash, err := store.WriteBlobStream(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("WriteBlobStream() error: %v", err)
+				}
+
+				if want := fmt.Sprintf("%x", sha256.Sum256(data)); hash != want {
+					t.Errorf("hash = %s, want plaintext SHA-256 %s", hash, want)
+				}
+
+				got, err := store.ReadBlob(hash)
+				if err != nil {
+					t.Fatalf("ReadBlob() error: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("ReadBlob() returned %d bytes, want %d", len(got), len(data))
+				}
+
+				info, err := store.Stat(hash)
+				if err != nil {
+					t.Fatalf("Stat() error: %v", err)
+				}
+				if info.Size != int64(size) {
+					t.Errorf("Stat().Size = %d, want %d", info.Size, size)
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptedBackend_HidesNamesAndContent(t *testing.T) {
+	store, inner, _ := newEncryptedStore(t, Options{})
+
+	content := "top secret quarterly numbers"
+	hash, err := store.WriteBlobStream(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	inner.Walk(func(info ObjectInfo) error {
+		if strings.Contains(info.Key, hash) {
+			t.Errorf("stored name %s reveals the content hash", info.Key)
+		}
+
+		rc, _ := inner.Open(info.Key)
+		raw, _ := io.ReadAll(rc)
+		rc.Close()
+		if bytes.Contains(raw, []byte(content)) {
+			t.Error("stored object contains the plaintext")
+		}
+		return nil
+	})
+
+	var walked []string
+	store.Walk(func(info ObjectInfo) error {
+		walked = append(walked, info.Key)
+		return nil
+	})
+	if len(walked) != 1 || walked[0] != hash {
+		t.Errorf("Walk() keys = %v, want [%s]", walked, hash)
+	}
+}
+
+func TestEncryptedBackend_DetectsTampering(t *testing.T) {
+	store, inner, _ := newEncryptedStore(t, Options{})
+
+	data := make([]byte, 2*segmentSize)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	inner.mu.Lock()
+	for _, obj := range inner.objects {
+		obj.data[len(obj.data)-1] ^= 1
+	}
+	inner.mu.Unlock()
+
+	if _, err := store.ReadBlob(hash); err == nil {
+		t.Error("ReadBlob() succeeded on a modified object")
+	}
+}
+
+func TestEncryptedBackend_WrongKey(t *testing.T) {
+	store, inner, _ := newEncryptedStore(t, Options{})
+
+	hash, err := store.WriteBlobStream(strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	otherKey, _ := GenerateKey()
+	other, _ := NewEncryptedBackend(inner, otherKey)
+	otherStore := NewStore(other, Options{})
+
+	if exists, _ := otherStore.Exists(hash); exists {
+		t.Error("Exists() = true under a different key")
+	}
+
+	if err := otherStore.Walk(func(ObjectInfo) error { return nil }); err == nil {
+		t.Error("Walk() succeeded under a different key")
+	}
+}
@@ -0,0 +1,639 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/reedsolomon"
+)
+
+const (
+	erasureMagic   = "CASR"
+	erasureVersion = 1
+
+	// erasureHeaderSize covers magic, version, shard counts, shard index,
+	// object size, segment size and a checksum of the preceding fields.
+	erasureHeaderSize = 24
+
+	// erasureSegmentSize is how much of each shard one stripe holds. Every
+	// segment is followed by its CRC-32C, so damage inside a shard is
+	// detected and the shard treated as lost for that read.
+	erasureSegmentSize = 64 << 10
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErasureBackend spreads every object over several roots, typically on
+// separate disks, as Reed-Solomon data and parity shards. Each root holds one
+// shard of each object under the object's key, and any DataShards of the
+// roots are enough to read it back.
+type ErasureBackend struct {
+	roots   []Backend
+	enc     *reedsolomon.Encoder
+	staging string
+}
+
+type erasureHeader struct {
+	dataShards   int
+	parityShards int
+	index        int
+	size         int64
+	segmentSize  int
+}
+
+type erasureWriter struct {
+	backend *ErasureBackend
+	file    *os.File
+	done    bool
+}
+
+// NewErasureBackend stores objects across roots with parityShards of them
+// holding parity. New objects are assembled in stagingDir before they are
+// split, since the object size is only known once the write completes.
+func NewErasureBackend(roots []Backend, parityShards int, stagingDir string) (*ErasureBackend, error) {
+	enc, err := reedsolomon.New(len(roots)-parityShards, parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard layout for %d roots: %w", len(roots), err)
+	}
+
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return &ErasureBackend{roots: roots, enc: enc, staging: stagingDir}, nil
+}
+
+func (b *ErasureBackend) Roots() []Backend {
+	return b.roots
+}
+
+func (b *ErasureBackend) DataShards() int {
+	return b.enc.DataShards()
+}
+
+func (b *ErasureBackend) ParityShards() int {
+	return b.enc.ParityShards()
+}
+
+func (b *ErasureBackend) RemoveStaleTemp(maxAge time.Duration) (int, error) {
+	removed, err := removeStaleTemp(b.staging, maxAge)
+	if err != nil {
+		return removed, err
+	}
+
+	for _, root := range b.roots {
+		if cleaner, ok := root.(interface {
+			RemoveStaleTemp(time.Duration) (int, error)
+		}); ok {
+			n, err := cleaner.RemoveStaleTemp(maxAge)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+func (b *ErasureBackend) Create() (ObjectWriter, error) {
+	file, err := os.CreateTemp(b.staging, "tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	return &erasureWriter{backend: b, file: file}, nil
+}
+
+func (w *erasureWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *erasureWriter) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, off)
+}
+
+// Commit encodes the staged object and writes one shard to every root. All
+// shards must be written, so an object is never stored with less redundancy
+// than configured.
+func (w *erasureWriter) Commit(key string) error {
+	if w.done {
+		return fmt.Errorf("object writer already closed")
+	}
+	w.done = true
+
+	defer os.Remove(w.file.Name())
+	defer w.file.Close()
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat temp file: %w", err)
+	}
+
+	b := w.backend
+	writers := make([]ObjectWriter, len(b.roots))
+	abort := func() {
+		for _, writer := range writers {
+			if writer != nil {
+				writer.Abort()
+			}
+		}
+	}
+
+	for i, root := range b.roots {
+		if writers[i], err = root.Create(); err != nil {
+			abort()
+			return fmt.Errorf("failed to create shard %d: %w", i, err)
+		}
+	}
+
+	src := io.NewSectionReader(w.file, 0, info.Size())
+	if err := b.encode(src, info.Size(), writers); err != nil {
+		abort()
+		return err
+	}
+
+	for i, writer := range writers {
+		if err := writer.Commit(key); err != nil {
+			writers[i] = nil
+			abort()
+			return fmt.Errorf("failed to commit shard %d: %w", i, err)
+		}
+		writers[i] = nil
+	}
+
+	return nil
+}
+
+func (w *erasureWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+func (b *ErasureBackend) encode(src io.Reader, size int64, writers []ObjectWriter) error {
+	k := b.enc.DataShards()
+
+	for i, writer := range writers {
+		header := encodeErasureHeader(erasureHeader{
+			dataShards:   k,
+			parityShards: b.enc.ParityShards(),
+			index:        i,
+			size:         size,
+			segmentSize:  erasureSegmentSize,
+		})
+		if _, err := writer.Write(header); err != nil {
+			return fmt.Errorf("failed to write shard header: %w", err)
+		}
+	}
+
+	stripe := make([]byte, k*erasureSegmentSize)
+	shards := make([][]byte, len(writers))
+
+	for remaining := size; remaining > 0; {
+		n, err := io.ReadFull(src, stripe[:min(int64(len(stripe)), remaining)])
+		if err != nil {
+			return fmt.Errorf("failed to read staged object: %w", err)
+		}
+		clear(stripe[n:])
+		remaining -= int64(n)
+
+		for i := 0; i < k; i++ {
+			shards[i] = stripe[i*erasureSegmentSize : (i+1)*erasureSegmentSize]
+		}
+		if err := b.enc.Encode(shards); err != nil {
+			return err
+		}
+
+		for i, writer := range writers {
+			if err := writeSegment(writer, shards[i]); err != nil {
+				return fmt.Errorf("failed to write shard %d: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeSegment(w io.Writer, segment []byte) error {
+	if _, err := w.Write(segment); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(segment, crcTable)))
+	return err
+}
+
+func encodeErasureHeader(h erasureHeader) []byte {
+	buf := make([]byte, erasureHeaderSize)
+	copy(buf, erasureMagic)
+	buf[4] = erasureVersion
+	buf[5] = byte(h.dataShards)
+	buf[6] = byte(h.parityShards)
+	buf[7] = byte(h.index)
+	binary.BigEndian.PutUint64(buf[8:], uint64(h.size))
+	binary.BigEndian.PutUint32(buf[16:], uint32(h.segmentSize))
+	binary.BigEndian.PutUint32(buf[20:], crc32.Checksum(buf[:20], crcTable))
+	return buf
+}
+
+func readErasureHeader(r io.Reader) (erasureHeader, error) {
+	buf := make([]byte, erasureHeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return erasureHeader{}, fmt.Errorf("failed to read shard header: %w", err)
+	}
+
+	if string(buf[:4]) != erasureMagic || buf[4] != erasureVersion {
+		return erasureHeader{}, fmt.Errorf("not a shard")
+	}
+	if binary.BigEndian.Uint32(buf[20:]) != crc32.Checksum(buf[:20], crcTable) {
+		return erasureHeader{}, fmt.Errorf("shard header checksum mismatch")
+	}
+
+	return erasureHeader{
+		dataShards:   int(buf[5]),
+		parityShards: int(buf[6]),
+		index:        int(buf[7]),
+		size:         int64(binary.BigEndian.Uint64(buf[8:])),
+		segmentSize:  int(binary.BigEndian.Uint32(buf[16:])),
+	}, nil
+}
+
+// stripeReader reads an object back one stripe at a time, dropping shards
+// that are missing or fail their checksums and rebuilding them from the rest.
+type stripeReader struct {
+	enc       *reedsolomon.Encoder
+	shards    []io.ReadCloser
+	header    erasureHeader
+	remaining int64
+	// damaged marks shards that were missing or unreadable at any point.
+	damaged []bool
+	segment []byte
+}
+
+func (b *ErasureBackend) openStripes(key string) (*stripeReader, error) {
+	r := &stripeReader{
+		enc:     b.enc,
+		shards:  make([]io.ReadCloser, len(b.roots)),
+		damaged: make([]bool, len(b.roots)),
+	}
+
+	found := false
+	var header *erasureHeader
+
+	for i, root := range b.roots {
+		rc, err := root.Open(key)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				found = true
+			}
+			r.damaged[i] = true
+			continue
+		}
+		found = true
+
+		h, err := readErasureHeader(rc)
+		if err != nil || h.index != i || h.dataShards != b.enc.DataShards() || h.parityShards != b.enc.ParityShards() ||
+			(header != nil && (h.size != header.size || h.segmentSize != header.segmentSize)) {
+			rc.Close()
+			r.damaged[i] = true
+			continue
+		}
+
+		header = &h
+		r.shards[i] = rc
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if header == nil || r.live() < b.enc.DataShards() {
+		r.Close()
+		return nil, fmt.Errorf("%s: %w", key, reedsolomon.ErrTooFewShards)
+	}
+
+	r.header = *header
+	r.remaining = header.size
+	r.segment = make([]byte, header.segmentSize+4)
+	return r, nil
+}
+
+func (r *stripeReader) live() int {
+	n := 0
+	for _, rc := range r.shards {
+		if rc != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *stripeReader) drop(i int) {
+	r.shards[i].Close()
+	r.shards[i] = nil
+	r.damaged[i] = true
+}
+
+// next returns every shard of the next stripe and how many of its data bytes
+// belong to the object, or io.EOF after the last stripe.
+func (r *stripeReader) next() ([][]byte, int, error) {
+	if r.remaining <= 0 {
+		return nil, 0, io.EOF
+	}
+
+	seg := r.header.segmentSize
+	shards := make([][]byte, len(r.shards))
+	complete := true
+
+	for i, rc := range r.shards {
+		if rc == nil {
+			complete = false
+			continue
+		}
+
+		if _, err := io.ReadFull(rc, r.segment); err != nil {
+			r.drop(i)
+			complete = false
+			continue
+		}
+		if binary.BigEndian.Uint32(r.segment[seg:]) != crc32.Checksum(r.segment[:seg], crcTable) {
+			r.drop(i)
+			complete = false
+			continue
+		}
+
+		shards[i] = append([]byte(nil), r.segment[:seg]...)
+	}
+
+	if !complete {
+		if err := r.enc.Reconstruct(shards); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	n := int(min(r.remaining, int64(r.enc.DataShards()*seg)))
+	r.remaining -= int64(n)
+	return shards, n, nil
+}
+
+func (r *stripeReader) Close() error {
+	for i, rc := range r.shards {
+		if rc != nil {
+			rc.Close()
+			r.shards[i] = nil
+		}
+	}
+	return nil
+}
+
+type erasureObject struct {
+	stripes *stripeReader
+	buf     []byte
+}
+
+func (o *erasureObject) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		shards, n, err := o.stripes.next()
+		if err != nil {
+			return 0, err
+		}
+
+		data := make([]byte, 0, n)
+		for i := 0; i < o.stripes.enc.DataShards() && len(data) < n; i++ {
+			data = append(data, shards[i][:min(len(shards[i]), n-len(data))]...)
+		}
+		o.buf = data
+	}
+
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+func (o *erasureObject) Close() error {
+	return o.stripes.Close()
+}
+
+func (b *ErasureBackend) Open(key string) (io.ReadCloser, error) {
+	stripes, err := b.openStripes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &erasureObject{stripes: stripes}, nil
+}
+
+// Stat reports the object size recorded in the shard headers, and in
+// StoredSize the space taken by every shard together.
+func (b *ErasureBackend) Stat(key string) (ObjectInfo, error) {
+	info := ObjectInfo{Key: key, Size: -1}
+	found := false
+
+	for _, root := range b.roots {
+		shard, err := root.Stat(key)
+		if err != nil {
+			continue
+		}
+		found = true
+
+		info.StoredSize += shard.Size
+		if shard.ModTime.After(info.ModTime) {
+			info.ModTime = shard.ModTime
+		}
+
+		if info.Size >= 0 {
+			continue
+		}
+		if rc, err := root.Open(key); err == nil {
+			if h, err := readErasureHeader(rc); err == nil {
+				info.Size = h.size
+			}
+			rc.Close()
+		}
+	}
+
+	if !found {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if info.Size < 0 {
+		return ObjectInfo{}, fmt.Errorf("%s: no readable shard header", key)
+	}
+
+	return info, nil
+}
+
+func (b *ErasureBackend) Delete(key string) error {
+	found := false
+	for _, root := range b.roots {
+		err := root.Delete(key)
+		if err == nil {
+			found = true
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return nil
+}
+
+func (b *ErasureBackend) Touch(key string) error {
+	found := false
+	for _, root := range b.roots {
+		err := root.Touch(key)
+		if err == nil {
+			found = true
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return nil
+}
+
+// Walk visits every object that has a shard in at least one root. A root
+// that is missing entirely is skipped, so a lost disk does not hide the
+// objects the other roots can still rebuild.
+func (b *ErasureBackend) Walk(fn func(ObjectInfo) error) error {
+	seen := make(map[string]bool)
+	var keys []string
+
+	for _, root := range b.roots {
+		err := root.Walk(func(info ObjectInfo) error {
+			if !seen[info.Key] {
+				seen[info.Key] = true
+				keys = append(keys, info.Key)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		info, err := b.Stat(key)
+		if err != nil {
+			// Shards without a readable header still exist, so report them
+			// for gc and fsck rather than hiding them.
+			info = ObjectInfo{Key: key}
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Repair reads every stripe of an object and lists the shards that are
+// missing or damaged. Unless dryRun is set, those shards are rebuilt from the
+// others and written back to their roots.
+func (b *ErasureBackend) Repair(key string, dryRun bool) ([]int, error) {
+	damaged, err := b.scan(key, nil)
+	if err != nil || len(damaged) == 0 || dryRun {
+		return damaged, err
+	}
+
+	writers := make(map[int]ObjectWriter)
+	abort := func() {
+		for _, writer := range writers {
+			writer.Abort()
+		}
+	}
+
+	for _, i := range damaged {
+		writer, err := b.roots[i].Create()
+		if err != nil {
+			abort()
+			return damaged, fmt.Errorf("failed to create shard %d: %w", i, err)
+		}
+		writers[i] = writer
+	}
+
+	if _, err := b.scan(key, writers); err != nil {
+		abort()
+		return damaged, err
+	}
+
+	for _, i := range damaged {
+		// Commit keeps an existing file, so a damaged one has to go first.
+		if err := b.roots[i].Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+			abort()
+			return damaged, err
+		}
+		if err := writers[i].Commit(key); err != nil {
+			delete(writers, i)
+			abort()
+			return damaged, fmt.Errorf("failed to commit shard %d: %w", i, err)
+		}
+		delete(writers, i)
+	}
+
+	return damaged, nil
+}
+
+// scan decodes the whole object, optionally writing rebuilt shards to
+// writers, and returns the indexes of shards that could not be read.
+func (b *ErasureBackend) scan(key string, writers map[int]ObjectWriter) ([]int, error) {
+	stripes, err := b.openStripes(key)
+	if err != nil {
+		return nil, err
+	}
+	defer stripes.Close()
+
+	for i, writer := range writers {
+		header := stripes.header
+		header.index = i
+		if _, err := writer.Write(encodeErasureHeader(header)); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		shards, _, err := stripes.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for i, writer := range writers {
+			if err := writeSegment(writer, shards[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Shards with trailing data after the last stripe are damaged too.
+	for i, rc := range stripes.shards {
+		if rc == nil {
+			continue
+		}
+		if n, _ := rc.Read(make([]byte, 1)); n > 0 {
+			stripes.damaged[i] = true
+		}
+	}
+
+	var damaged []int
+	for i, bad := range stripes.damaged {
+		if bad {
+			damaged = append(damaged, i)
+		}
+	}
+	return damaged, nil
+}
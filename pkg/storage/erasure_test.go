@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/reedsolomon"
+)
+
+func newErasureStore(t *testing.T, roots, parity int) (*Store, *ErasureBackend, []string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	dirs := make([]string, roots)
+	backends := make([]Backend, roots)
+	for i := range dirs {
+		dirs[i] = filepath.Join(dir, "disk"+string(rune('a'+i)))
+		root, err := NewFSBackend(dirs[i])
+		if err != nil {
+			t.Fatalf("NewFSBackend() error: %v", err)
+		}
+		backends[i] = root
+	}
+
+	backend, err := NewErasureBackend(backends, parity, filepath.Join(dir, "staging"))
+	if err != nil {
+		t.Fatalf("NewErasureBackend() error: %v", err)
+	}
+
+	return NewStore(backend, Options{}), backend, dirs
+}
+
+func randomBlob(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func TestErasure_RoundTrip(t *testing.T) {
+	store, _, _ := newErasureStore(t, 4, 2)
+
+	for _, size := range []int{0, 1, 1000, 2*erasureSegmentSize + 17, 5 * erasureSegmentSize} {
+		data := randomBlob(size)
+
+		hash, err := store.WriteBlobStream(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("WriteBlobStream(%d bytes) error: %v", size, err)
+		}
+
+		got, err := store.ReadBlob(hash)
+		if err != nil {
+			t.Fatalf("ReadBlob(%d bytes) error: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("ReadBlob(%d bytes) returned different content", size)
+		}
+
+		info, err := store.Stat(hash)
+		if err != nil || info.Size != int64(size) {
+			t.Errorf("Stat(%d bytes) = %+v, %v", size, info, err)
+		}
+	}
+}
+
+func TestErasure_SurvivesLostRoots(t *testing.T) {
+	store, _, dirs := newErasureStore(t, 5, 2)
+
+	data := randomBlob(3*erasureSegmentSize + 5)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	os.RemoveAll(dirs[0])
+	os.RemoveAll(dirs[3])
+
+	got, err := store.ReadBlob(hash)
+	if err != nil {
+		t.Fatalf("ReadBlob() with two lost roots error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("ReadBlob() rebuilt different content")
+	}
+
+	count := 0
+	if err := store.Walk(func(ObjectInfo) error { count++; return nil }); err != nil {
+		t.Fatalf("Walk() error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Walk() visited %d objects, want 1", count)
+	}
+
+	os.RemoveAll(dirs[1])
+
+	if _, err := store.ReadBlob(hash); !errors.Is(err, reedsolomon.ErrTooFewShards) {
+		t.Errorf("ReadBlob() with three lost roots error = %v, want ErrTooFewShards", err)
+	}
+}
+
+func TestErasure_RepairRegeneratesShards(t *testing.T) {
+	store, backend, dirs := newErasureStore(t, 4, 1)
+
+	data := randomBlob(2*erasureSegmentSize + 100)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	lost := backend.Roots()[1].(*FSBackend).Path(hash)
+	original, err := os.ReadFile(lost)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error: %v", err)
+	}
+	os.RemoveAll(dirs[1])
+
+	damaged, err := backend.Repair(hash, true)
+	if err != nil || len(damaged) != 1 || damaged[0] != 1 {
+		t.Fatalf("Repair(dry run) = %v, %v, want shard 1", damaged, err)
+	}
+	if _, err := os.Stat(lost); !os.IsNotExist(err) {
+		t.Fatal("dry run rewrote the shard")
+	}
+
+	if _, err := backend.Repair(hash, false); err != nil {
+		t.Fatalf("Repair() error: %v", err)
+	}
+
+	rebuilt, err := os.ReadFile(lost)
+	if err != nil {
+		t.Fatalf("shard not regenerated: %v", err)
+	}
+	if !bytes.Equal(rebuilt, original) {
+		t.Error("regenerated shard differs from the original")
+	}
+
+	if damaged, err := backend.Repair(hash, true); err != nil || len(damaged) != 0 {
+		t.Errorf("Repair() after repair = %v, %v, want no damage", damaged, err)
+	}
+}
+
+func TestErasure_DetectsCorruptSegments(t *testing.T) {
+	store, backend, _ := newErasureStore(t, 3, 1)
+
+	data := randomBlob(erasureSegmentSize * 3)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	path := backend.Roots()[0].(*FSBackend).Path(hash)
+	shard, _ := os.ReadFile(path)
+	shard[erasureHeaderSize+erasureSegmentSize+4+10] ^= 0xff
+	os.Chmod(path, 0644)
+	if err := os.WriteFile(path, shard, 0444); err != nil {
+		t.Fatalf("os.WriteFile() error: %v", err)
+	}
+
+	got, err := store.ReadBlob(hash)
+	if err != nil {
+		t.Fatalf("ReadBlob() error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("ReadBlob() returned corrupt content")
+	}
+
+	damaged, err := backend.Repair(hash, false)
+	if err != nil || len(damaged) != 1 || damaged[0] != 0 {
+		t.Fatalf("Repair() = %v, %v, want shard 0", damaged, err)
+	}
+	if damaged, _ := backend.Repair(hash, true); len(damaged) != 0 {
+		t.Errorf("shards still damaged after repair: %v", damaged)
+	}
+}
@@ -0,0 +1,563 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+)
+
+type FSBackend struct {
+	root       string
+	durability string
+
+	mu     sync.RWMutex
+	layout fsLayout
+	// casDir, when set, is the repository whose format file the layout
+	// follows, so a migration started elsewhere is picked up while open.
+	casDir      string
+	formatStamp time.Time
+}
+
+// fsLayout is how deep objects are sharded. While a migration runs, objects
+// not yet moved are still found at the legacy depth.
+type fsLayout struct {
+	depth  int
+	legacy int
+}
+
+type fsWriter struct {
+	backend *FSBackend
+	file    *os.File
+	done    bool
+}
+
+func NewFSBackend(root string) (*FSBackend, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return &FSBackend{
+		root:       root,
+		durability: DurabilityFull,
+		layout:     fsLayout{depth: path.DefaultShardDepth},
+	}, nil
+}
+
+func (b *FSBackend) Root() string {
+	return b.root
+}
+
+func (b *FSBackend) Durability() string {
+	return b.durability
+}
+
+// SetDurability selects how hard Commit works to make an object survive a
+// crash: DurabilityNone relies on the atomic rename alone, DurabilityFile
+// also fsyncs the object, and DurabilityFull fsyncs its directory as well.
+func (b *FSBackend) SetDurability(mode string) error {
+	if !ValidDurability(mode) {
+		return fmt.Errorf("unknown durability: %s", mode)
+	}
+	if mode == "" {
+		mode = DurabilityFull
+	}
+
+	b.durability = mode
+	return nil
+}
+
+// RemoveStaleTemp deletes temp files left in the storage root by writes that
+// never committed.
+func (b *FSBackend) RemoveStaleTemp(maxAge time.Duration) (int, error) {
+	return removeStaleTemp(b.root, maxAge)
+}
+
+// SetShardDepth fixes the layout objects are written in. A non-zero legacy
+// depth is also searched on reads, for repositories part way through a
+// migration.
+func (b *FSBackend) SetShardDepth(depth, legacy int) error {
+	if !path.ValidShardDepth(depth) || (legacy != 0 && !path.ValidShardDepth(legacy)) {
+		return fmt.Errorf("invalid shard depth: %d", depth)
+	}
+	if legacy == depth {
+		legacy = 0
+	}
+
+	b.mu.Lock()
+	b.layout = fsLayout{depth: depth, legacy: legacy}
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *FSBackend) ShardDepth() (int, int) {
+	layout := b.currentLayout()
+	return layout.depth, layout.legacy
+}
+
+// FollowFormat takes the layout from a repository's format file and keeps
+// following it: the file is checked again before each write and whenever an
+// object is not found, so processes that stay open across cas migrate keep
+// writing where readers look.
+func (b *FSBackend) FollowFormat(casDir string) error {
+	b.mu.Lock()
+	b.casDir = casDir
+	b.formatStamp = time.Time{}
+	b.mu.Unlock()
+
+	_, err := b.refreshLayout()
+	return err
+}
+
+// refreshLayout reloads the format file if it changed since it was last
+// read, and reports whether the layout did.
+func (b *FSBackend) refreshLayout() (bool, error) {
+	b.mu.RLock()
+	casDir, stamp, old := b.casDir, b.formatStamp, b.layout
+	b.mu.RUnlock()
+
+	if casDir == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(filepath.Join(casDir, path.FormatFile))
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to stat format: %w", err)
+	}
+	var modTime time.Time
+	if err == nil {
+		modTime = info.ModTime()
+	}
+	if !stamp.IsZero() && modTime.Equal(stamp) {
+		return false, nil
+	}
+
+	format, err := path.LoadFormat(casDir)
+	if err != nil {
+		return false, err
+	}
+
+	layout := fsLayout{depth: format.ShardDepth}
+	if m := format.Migration; m != nil && m.FromShardDepth != 0 && m.FromShardDepth != format.ShardDepth {
+		layout.legacy = m.FromShardDepth
+	}
+
+	b.mu.Lock()
+	b.layout = layout
+	b.formatStamp = modTime
+	b.mu.Unlock()
+
+	return layout != old, nil
+}
+
+func (b *FSBackend) currentLayout() fsLayout {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.layout
+}
+
+// Path shards objects by the first hex characters of their digest, two per
+// directory level. Keys of algorithm-prefixed digests (sha1:<hex>) are
+// stored with the colon replaced, since not every filesystem allows it in
+// file names.
+func (b *FSBackend) Path(key string) string {
+	return b.PathAt(key, b.currentLayout().depth)
+}
+
+// PathAt is Path for a given shard depth.
+func (b *FSBackend) PathAt(key string, depth int) string {
+	shard := key
+	if _, value, found := strings.Cut(key, ":"); found {
+		shard = value
+	}
+
+	name := strings.Replace(key, ":", "_", 1)
+	if len(shard) < 2*depth {
+		return filepath.Join(b.root, name)
+	}
+
+	parts := []string{b.root}
+	for i := 0; i < depth; i++ {
+		parts = append(parts, shard[2*i:2*i+2])
+	}
+	return filepath.Join(append(parts, name)...)
+}
+
+// Locate returns the file holding an object, at whichever depth it is
+// currently stored.
+func (b *FSBackend) Locate(key string) (string, error) {
+	var located string
+	err := b.lookup(key, func(p string) error {
+		if _, err := os.Stat(p); err != nil {
+			return err
+		}
+		located = p
+		return nil
+	})
+	return located, err
+}
+
+// lookup runs op on the object's path, falling back to the legacy depth
+// when the object is not there. If it is in neither place the layout is
+// reloaded, since a migration may have moved the object in the meantime,
+// and op tried once more. op's not-exist errors become ErrNotFound.
+func (b *FSBackend) lookup(key string, op func(path string) error) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		layout := b.currentLayout()
+
+		err := op(b.PathAt(key, layout.depth))
+		if err == nil || !os.IsNotExist(err) {
+			return err
+		}
+
+		if layout.legacy != 0 {
+			err = op(b.PathAt(key, layout.legacy))
+			if err == nil || !os.IsNotExist(err) {
+				return err
+			}
+			// The object may have been moved between the two attempts.
+			err = op(b.PathAt(key, layout.depth))
+			if err == nil || !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if changed, _ := b.refreshLayout(); !changed {
+			break
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+// KeyFromName reverses the file naming done by Path.
+func (b *FSBackend) KeyFromName(name string) string {
+	return strings.Replace(name, "_", ":", 1)
+}
+
+func (b *FSBackend) Create() (ObjectWriter, error) {
+	tmpFile, err := os.CreateTemp(b.root, "tmp-")
+	if os.IsNotExist(err) {
+		// The root was removed while the repository was open, as when a
+		// disk fails; recreate it so repairs can write shards again.
+		if err := os.MkdirAll(b.root, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+		tmpFile, err = os.CreateTemp(b.root, "tmp-")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	return &fsWriter{backend: b, file: tmpFile}, nil
+}
+
+func (w *fsWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *fsWriter) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, off)
+}
+
+func (w *fsWriter) Commit(key string) error {
+	if w.done {
+		return fmt.Errorf("object writer already closed")
+	}
+	w.done = true
+
+	tmpPath := w.file.Name()
+	defer os.Remove(tmpPath)
+
+	if syncsFiles(w.backend.durability) {
+		if err := w.file.Sync(); err != nil {
+			w.file.Close()
+			return fmt.Errorf("failed to sync temp file: %w", err)
+		}
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if _, err := w.backend.refreshLayout(); err != nil {
+		return err
+	}
+
+	if _, err := w.backend.Locate(key); err == nil {
+		return nil
+	}
+
+	objectPath := w.backend.Path(key)
+
+	shardDir := filepath.Dir(objectPath)
+
+	// Every directory created here needs its entry synced in its parent.
+	dirs := []string{shardDir}
+	for dir := shardDir; dir != w.backend.root; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			break
+		}
+		dirs = append(dirs, filepath.Dir(dir))
+	}
+
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, 0444); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, objectPath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+
+	if w.backend.durability != DurabilityFull {
+		return nil
+	}
+
+	for _, dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *fsWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+func (b *FSBackend) Open(key string) (io.ReadCloser, error) {
+	var file *os.File
+	err := b.lookup(key, func(p string) error {
+		var err error
+		file, err = os.Open(p)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+
+	return file, nil
+}
+
+func (b *FSBackend) Stat(key string) (ObjectInfo, error) {
+	var info os.FileInfo
+	err := b.lookup(key, func(p string) error {
+		var err error
+		info, err = os.Stat(p)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ObjectInfo{}, err
+		}
+		return ObjectInfo{}, fmt.Errorf("stat failed: %w", err)
+	}
+
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (b *FSBackend) Delete(key string) error {
+	if err := b.lookup(key, os.Remove); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	// Mid-migration an object can briefly exist at both depths; do not let
+	// the other copy bring it back.
+	if layout := b.currentLayout(); layout.legacy != 0 {
+		os.Remove(b.PathAt(key, layout.legacy))
+		os.Remove(b.PathAt(key, layout.depth))
+	}
+
+	return nil
+}
+
+func (b *FSBackend) Touch(key string) error {
+	now := time.Now()
+	err := b.lookup(key, func(p string) error {
+		return os.Chtimes(p, now, now)
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to touch blob: %w", err)
+	}
+
+	return nil
+}
+
+// Walk visits every object at the current depth and, during a migration,
+// at the legacy one.
+func (b *FSBackend) Walk(fn func(ObjectInfo) error) error {
+	if _, err := b.refreshLayout(); err != nil {
+		return err
+	}
+	layout := b.currentLayout()
+	deepest := max(layout.depth, layout.legacy)
+
+	var seen map[string]bool
+	if layout.legacy != 0 {
+		seen = make(map[string]bool)
+	}
+
+	return filepath.WalkDir(b.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		depth := len(strings.Split(rel, string(filepath.Separator)))
+
+		if d.IsDir() {
+			if rel != "." && (depth > deepest || d.Name() == packDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if depth != layout.depth+1 && (layout.legacy == 0 || depth != layout.legacy+1) {
+			return nil
+		}
+
+		key := b.KeyFromName(d.Name())
+		if seen != nil {
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Moved by a concurrent migration; it is visited at its
+				// new depth or was already.
+				return nil
+			}
+			return err
+		}
+
+		return fn(ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// Relayout moves every object still stored at the legacy depth to its
+// current path and removes the shard directories left empty. It is safe to
+// interrupt and to run alongside readers and writers.
+func (b *FSBackend) Relayout() (int, error) {
+	if _, err := b.refreshLayout(); err != nil {
+		return 0, err
+	}
+	layout := b.currentLayout()
+	if layout.legacy == 0 {
+		return 0, nil
+	}
+
+	var keys []string
+	err := filepath.WalkDir(b.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		depth := len(strings.Split(rel, string(filepath.Separator)))
+
+		if d.IsDir() {
+			if rel != "." && (depth > layout.legacy || d.Name() == packDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if depth == layout.legacy+1 && p == b.PathAt(b.KeyFromName(d.Name()), layout.legacy) {
+			keys = append(keys, b.KeyFromName(d.Name()))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk storage: %w", err)
+	}
+
+	moved := 0
+	for _, key := range keys {
+		from, to := b.PathAt(key, layout.legacy), b.PathAt(key, layout.depth)
+
+		if _, err := os.Stat(to); err == nil {
+			// Same address, same content: the copy in place wins.
+			os.Remove(from)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return moved, fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return moved, fmt.Errorf("failed to move %s: %w", key, err)
+		}
+		moved++
+
+		if b.durability == DurabilityFull {
+			if err := syncDir(filepath.Dir(to)); err != nil {
+				return moved, err
+			}
+		}
+	}
+
+	b.removeEmptyShards(b.root, 0, layout.legacy)
+	return moved, nil
+}
+
+// removeEmptyShards deletes shard directories down to maxDepth that no
+// longer hold anything. Directories still in use fail to delete and stay.
+func (b *FSBackend) removeEmptyShards(dir string, depth, maxDepth int) {
+	if depth == maxDepth {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == packDirName || len(entry.Name()) != 2 {
+			continue
+		}
+		sub := filepath.Join(dir, entry.Name())
+		b.removeEmptyShards(sub, depth+1, maxDepth)
+		os.Remove(sub)
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+var ErrHeld = errors.New("blob is under a retention or legal hold")
+
+// HoldSource lists the blobs under a retention or legal hold, such as those
+// recorded in the catalog. The store refuses to delete them or their chunks.
+type HoldSource interface {
+	HeldHashes() ([]string, error)
+}
+
+func (s *Store) SetHolds(holds HoldSource) {
+	s.holds = holds
+}
+
+// FreezeHolds reads the held blobs once and has deletes check against that
+// set until the returned function is called, instead of reading holds again
+// for every object. Callers must keep holds from changing meanwhile, as gc
+// does by holding the repository lock exclusively.
+func (s *Store) FreezeHolds() (func(), error) {
+	held, err := s.heldHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.frozen.set(held)
+	return func() { s.frozen.set(nil) }, nil
+}
+
+// frozenHolds is the held set FreezeHolds read, or nil when holds are read
+// on every check.
+type frozenHolds struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func (f *frozenHolds) set(held map[string]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.held = held
+}
+
+func (f *frozenHolds) get() map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.held
+}
+
+// checkHold fails with ErrHeld if hash, or a chunked blob containing it, is
+// held. Unless FreezeHolds is in effect, holds are read again on every call,
+// so one placed while a long operation runs still takes effect.
+func (s *Store) checkHold(hash string) error {
+	if s.holds == nil {
+		return nil
+	}
+
+	held := s.frozen.get()
+	if held == nil {
+		var err error
+		if held, err = s.heldHashes(); err != nil {
+			return err
+		}
+	}
+	if held[objects.CanonicalDigest(hash)] {
+		return fmt.Errorf("%w: %s", ErrHeld, objects.ShortDigest(hash))
+	}
+	return nil
+}
+
+func (s *Store) heldHashes() (map[string]bool, error) {
+	held := make(map[string]bool)
+	if s.holds == nil {
+		return held, nil
+	}
+
+	hashes, err := s.holds.HeldHashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list held blobs: %w", err)
+	}
+
+	s.addWithChunks(held, hashes)
+	return held, nil
+}
+
+// addWithChunks adds hashes to set, along with the chunks of any that are
+// manifests.
+func (s *Store) addWithChunks(set map[string]bool, hashes []string) {
+	for _, hash := range hashes {
+		hash = objects.CanonicalDigest(hash)
+		if set[hash] {
+			continue
+		}
+		set[hash] = true
+
+		if manifest, err := s.ReadManifest(hash); err == nil {
+			for _, chunk := range manifest.Chunks {
+				set[chunk.Hash] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/catalog"
+)
+
+var ErrNoInlining = errors.New("repository does not inline small blobs")
+
+// InlineBackend keeps objects smaller than a threshold as rows in SQLite,
+// where a few hundred bytes cost a few hundred bytes rather than a file, an
+// inode and a directory entry. Larger objects go to the inner backend.
+type InlineBackend struct {
+	inner     Backend
+	db        *sql.DB
+	threshold int64
+}
+
+type InlineUsage struct {
+	Threshold int64
+	Objects   int
+	Bytes     int64
+}
+
+// inlineWriter buffers in memory until the object reaches the threshold, then
+// spills everything written so far to the inner backend.
+type inlineWriter struct {
+	backend *InlineBackend
+	buf     []byte
+	spill   ObjectWriter
+	done    bool
+}
+
+// NewInlineBackend keeps objects in the inline_objects table of the catalog
+// database in casDir, so a tiny blob and the entry naming it are committed
+// through the same log. The table is part of the catalog schema.
+func NewInlineBackend(inner Backend, casDir string, threshold int64) (*InlineBackend, error) {
+	db, err := catalog.OpenDatabase(casDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open inline store: %w", err)
+	}
+
+	return &InlineBackend{inner: inner, db: db, threshold: threshold}, nil
+}
+
+func (b *InlineBackend) Inner() Backend {
+	return b.inner
+}
+
+func (b *InlineBackend) Threshold() int64 {
+	return b.threshold
+}
+
+func (b *InlineBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *InlineBackend) Create() (ObjectWriter, error) {
+	return &inlineWriter{backend: b}, nil
+}
+
+func (w *inlineWriter) Write(p []byte) (int, error) {
+	if w.spill == nil && int64(len(w.buf)+len(p)) >= w.backend.threshold {
+		if err := w.spillover(); err != nil {
+			return 0, err
+		}
+	}
+	if w.spill != nil {
+		return w.spill.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *inlineWriter) WriteAt(p []byte, off int64) (int, error) {
+	if w.spill == nil && off+int64(len(p)) >= w.backend.threshold {
+		if err := w.spillover(); err != nil {
+			return 0, err
+		}
+	}
+	if w.spill != nil {
+		return w.spill.WriteAt(p, off)
+	}
+
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func (w *inlineWriter) spillover() error {
+	spill, err := w.backend.inner.Create()
+	if err != nil {
+		return err
+	}
+
+	if _, err := spill.Write(w.buf); err != nil {
+		spill.Abort()
+		return err
+	}
+
+	w.spill = spill
+	w.buf = nil
+	return nil
+}
+
+func (w *inlineWriter) Commit(key string) error {
+	if w.done {
+		return fmt.Errorf("object writer already closed")
+	}
+	w.done = true
+
+	if w.spill != nil {
+		if ok, err := w.backend.has(key); err != nil || ok {
+			w.spill.Abort()
+			return err
+		}
+		return w.spill.Commit(key)
+	}
+
+	if _, err := w.backend.inner.Stat(key); err == nil {
+		return nil
+	}
+
+	_, err := w.backend.db.Exec(
+		"INSERT OR IGNORE INTO inline_objects (key, data, mtime) VALUES (?, ?, ?)",
+		key, w.buf, time.Now().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store inline object: %w", err)
+	}
+
+	return nil
+}
+
+func (w *inlineWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.buf = nil
+
+	if w.spill != nil {
+		return w.spill.Abort()
+	}
+	return nil
+}
+
+func (b *InlineBackend) has(key string) (bool, error) {
+	var exists bool
+	err := b.db.QueryRow("SELECT EXISTS(SELECT 1 FROM inline_objects WHERE key = ?)", key).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to query inline objects: %w", err)
+	}
+	return exists, nil
+}
+
+func (b *InlineBackend) Open(key string) (io.ReadCloser, error) {
+	var data []byte
+	err := b.db.QueryRow("SELECT data FROM inline_objects WHERE key = ?", key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return b.inner.Open(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inline object: %w", err)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *InlineBackend) Stat(key string) (ObjectInfo, error) {
+	var size, mtime int64
+	err := b.db.QueryRow("SELECT length(data), mtime FROM inline_objects WHERE key = ?", key).Scan(&size, &mtime)
+	if err == sql.ErrNoRows {
+		return b.inner.Stat(key)
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat failed: %w", err)
+	}
+
+	return ObjectInfo{Key: key, Size: size, ModTime: time.Unix(0, mtime)}, nil
+}
+
+func (b *InlineBackend) Delete(key string) error {
+	res, err := b.db.Exec("DELETE FROM inline_objects WHERE key = ?", key)
+	if err != nil {
+		return fmt.Errorf("failed to delete inline object: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	return b.inner.Delete(key)
+}
+
+func (b *InlineBackend) Touch(key string) error {
+	res, err := b.db.Exec("UPDATE inline_objects SET mtime = ? WHERE key = ?", time.Now().UnixNano(), key)
+	if err != nil {
+		return fmt.Errorf("failed to touch inline object: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	return b.inner.Touch(key)
+}
+
+func (b *InlineBackend) Walk(fn func(ObjectInfo) error) error {
+	if err := b.inner.Walk(fn); err != nil {
+		return err
+	}
+
+	rows, err := b.db.Query("SELECT key, length(data), mtime FROM inline_objects ORDER BY key")
+	if err != nil {
+		return fmt.Errorf("failed to list inline objects: %w", err)
+	}
+
+	var infos []ObjectInfo
+	for rows.Next() {
+		var info ObjectInfo
+		var mtime int64
+		if err := rows.Scan(&info.Key, &info.Size, &mtime); err != nil {
+			rows.Close()
+			return err
+		}
+		info.ModTime = time.Unix(0, mtime)
+		infos = append(infos, info)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The rows are read up front so fn may delete objects as it goes.
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *InlineBackend) Usage() (InlineUsage, error) {
+	usage := InlineUsage{Threshold: b.threshold}
+	err := b.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(length(data)), 0) FROM inline_objects").Scan(&usage.Objects, &usage.Bytes)
+	if err != nil {
+		return InlineUsage{}, fmt.Errorf("failed to count inline objects: %w", err)
+	}
+	return usage, nil
+}
+
+func (b *InlineBackend) Repack(maxObjectSize int64) (RepackResult, error) {
+	repacker, ok := b.inner.(interface {
+		Repack(maxObjectSize int64) (RepackResult, error)
+	})
+	if !ok {
+		return RepackResult{}, fmt.Errorf("storage backend does not support packs")
+	}
+
+	return repacker.Repack(maxObjectSize)
+}
+
+// inlineLayer finds the InlineBackend under the store's encryption, if any.
+func inlineLayer(b Backend) (*InlineBackend, bool) {
+	return Unwrap[*InlineBackend](b)
+}
+
+// InlineUsage reports how many objects are stored inline in the catalog.
+func (s *Store) InlineUsage() (InlineUsage, error) {
+	inline, ok := inlineLayer(s.backend)
+	if !ok {
+		return InlineUsage{}, ErrNoInlining
+	}
+	return inline.Usage()
+}
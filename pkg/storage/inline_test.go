@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newInlineStore(t *testing.T, threshold int64, opts Options) (*Store, *FSBackend) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	fs, err := NewFSBackend(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	inline, err := NewInlineBackend(fs, dir, threshold)
+	if err != nil {
+		t.Fatalf("NewInlineBackend() error: %v", err)
+	}
+
+	store := NewStore(inline, opts)
+	t.Cleanup(func() { store.Close() })
+
+	return store, fs
+}
+
+func TestInline_SmallBlobsStayInDatabase(t *testing.T) {
+	store, fs := newInlineStore(t, 512, Options{})
+
+	small := []byte("tiny blob")
+	large := bytes.Repeat([]byte("x"), 4096)
+
+	smallHash, err := store.WriteBlobStream(bytes.NewReader(small))
+	if err != nil {
+		t.Fatalf("WriteBlobStream(small) error: %v", err)
+	}
+	largeHash, err := store.WriteBlobStream(bytes.NewReader(large))
+	if err != nil {
+		t.Fatalf("WriteBlobStream(large) error: %v", err)
+	}
+
+	if _, err := os.Stat(fs.Path(smallHash)); !os.IsNotExist(err) {
+		t.Error("small blob was written to its own file")
+	}
+	if _, err := os.Stat(fs.Path(largeHash)); err != nil {
+		t.Errorf("large blob not written to the inner backend: %v", err)
+	}
+
+	for hash, want := range map[string][]byte{smallHash: small, largeHash: large} {
+		got, err := store.ReadBlob(hash)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("ReadBlob(%s) = %d bytes, %v", hash[:8], len(got), err)
+		}
+		if info, err := store.Stat(hash); err != nil || info.Size != int64(len(want)) {
+			t.Errorf("Stat(%s) = %+v, %v", hash[:8], info, err)
+		}
+	}
+
+	usage, err := store.InlineUsage()
+	if err != nil || usage.Objects != 1 || usage.Bytes != int64(len(small)) {
+		t.Errorf("InlineUsage() = %+v, %v, want one object", usage, err)
+	}
+
+	count := 0
+	store.Walk(func(ObjectInfo) error { count++; return nil })
+	if count != 2 {
+		t.Errorf("Walk() visited %d objects, want 2", count)
+	}
+
+	if err := store.DeleteObject(smallHash); err != nil {
+		t.Fatalf("DeleteObject() error: %v", err)
+	}
+	if ok, _ := store.Exists(smallHash); ok {
+		t.Error("Exists() = true after delete")
+	}
+}
+
+func TestInline_CompressedHeaderRewrite(t *testing.T) {
+	store, _ := newInlineStore(t, 1024, Options{Compression: CompressionGzip})
+
+	data := bytes.Repeat([]byte("abc"), 200)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	got, err := store.ReadBlob(hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadBlob() = %d bytes, %v", len(got), err)
+	}
+
+	if usage, _ := store.InlineUsage(); usage.Objects != 1 {
+		t.Errorf("InlineUsage().Objects = %d, want 1", usage.Objects)
+	}
+}
+
+func TestInline_Disabled(t *testing.T) {
+	store := NewStore(NewMemoryBackend(), Options{})
+
+	if _, err := store.InlineUsage(); !errors.Is(err, ErrNoInlining) {
+		t.Errorf("InlineUsage() error = %v, want ErrNoInlining", err)
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+// fsBackends lists every filesystem root objects are stored under: the loose
+// store, a cold tier and each erasure root.
+func fsBackends(b Backend) []*FSBackend {
+	if tiers, ok := Unwrap[*TieredBackend](b); ok {
+		return append(fsBackends(tiers.Hot()), fsBackends(tiers.Cold())...)
+	}
+
+	if erasure, ok := Unwrap[*ErasureBackend](b); ok {
+		var roots []*FSBackend
+		for _, root := range erasure.Roots() {
+			roots = append(roots, fsBackends(root)...)
+		}
+		return roots
+	}
+
+	if loose, ok := Unwrap[*FSBackend](b); ok {
+		return []*FSBackend{loose}
+	}
+	return nil
+}
+
+// Relayout moves objects stored at a legacy shard depth to the current one
+// in every filesystem root, returning how many it moved.
+func (s *Store) Relayout() (int, error) {
+	moved := 0
+	for _, fsb := range fsBackends(s.backend) {
+		n, err := fsb.Relayout()
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// Reencode rewrites an object, as reported by Walk, in the encoding the
+// repository currently writes. The old copy is removed only once the new one
+// is committed, so readers always find one of the two. The content is
+// checked against its digest on the way, so a corrupt object is left alone
+// rather than re-encoded. It reports whether the object needed rewriting.
+func (s *Store) Reencode(key string) (bool, error) {
+	if isManifestKey(key) || IsOutboardKey(key) {
+		return false, nil
+	}
+
+	// Objects already compressed stay as they are even if the codec has
+	// changed: both decode, and the key would not change.
+	if strings.HasSuffix(key, compressedSuffix) == compressionEnabled(s.opts.Compression) {
+		return false, nil
+	}
+
+	hash := HashFromKey(key)
+	algo, _, err := objects.ParseDigest(hash)
+	if err != nil {
+		return false, err
+	}
+
+	rc, err := s.OpenObject(key)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	writer, got, newKey, err := s.encode(rc, algo)
+	if err != nil {
+		return false, err
+	}
+
+	if got != objects.CanonicalDigest(hash) {
+		writer.Abort()
+		return false, fmt.Errorf("object %s content hashes to %s", key, objects.ShortDigest(got))
+	}
+
+	if err := writer.Commit(newKey); err != nil {
+		return false, err
+	}
+
+	if err := s.backend.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return true, fmt.Errorf("failed to remove %s: %w", key, err)
+	}
+
+	return true, nil
+}
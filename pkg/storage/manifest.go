@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/chunker"
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+const manifestSuffix = ".m"
+
+type Manifest struct {
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+type Chunk struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+type manifestReader struct {
+	store   *Store
+	chunks  []Chunk
+	current io.ReadCloser
+}
+
+func (s *Store) writeChunked(reader io.Reader) (string, error) {
+	avgSize := s.opts.ChunkAvgSize
+	if avgSize == 0 {
+		avgSize = chunker.DefaultAvgSize
+	}
+
+	c, err := chunker.New(reader, avgSize)
+	if err != nil {
+		return "", err
+	}
+
+	fileHasher, err := objects.NewHasher(s.opts.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	var manifest Manifest
+	outboard := newOutboardBuilder()
+
+	// Each chunk is held back until the next one is read, so that a blob
+	// turning out to be a single chunk can be written, and announced to
+	// hooks, as a plain blob.
+	var pending []byte
+
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read chunk: %w", err)
+		}
+
+		fileHasher.Write(chunk)
+		outboard.Write(chunk)
+
+		if pending != nil {
+			if err := s.writeChunk(&manifest, pending); err != nil {
+				return "", err
+			}
+		}
+		pending = bytes.Clone(chunk)
+	}
+
+	if len(manifest.Chunks) == 0 {
+		hash, err := s.writeEncoded(bytes.NewReader(pending), true)
+		if err != nil {
+			return "", err
+		}
+		if pending != nil {
+			s.storeOutboard(hash, outboard)
+		}
+		return hash, nil
+	}
+
+	if err := s.writeChunk(&manifest, pending); err != nil {
+		return "", err
+	}
+
+	fileHash := objects.FormatDigest(s.opts.HashAlgorithm, fileHasher.Sum(nil))
+	s.storeOutboard(fileHash, outboard)
+
+	// A vetoed blob's chunks are left unreferenced for gc to sweep.
+	event := hooks.Event{Kind: hooks.BlobWritten, Hash: fileHash, Size: manifest.Size}
+
+	if existing, info, err := s.locate(fileHash); err == nil {
+		event.Kind = hooks.BlobDeduplicated
+		if err := s.hooks.Pre(event); err != nil {
+			return "", err
+		}
+
+		s.backend.Touch(existing)
+		s.recordAccess(fileHash, info)
+
+		s.hooks.Post(event)
+		return fileHash, nil
+	}
+
+	if err := s.hooks.Pre(event); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	writer, err := s.backend.Create()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		writer.Abort()
+		return "", fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := writer.Commit(fileHash + manifestSuffix); err != nil {
+		return "", err
+	}
+
+	s.recordAccess(fileHash, ObjectInfo{Size: int64(len(data))})
+
+	s.hooks.Post(event)
+	return fileHash, nil
+}
+
+func (s *Store) writeChunk(manifest *Manifest, chunk []byte) error {
+	hash, err := s.writeEncoded(bytes.NewReader(chunk), false)
+	if err != nil {
+		return fmt.Errorf("failed to store chunk: %w", err)
+	}
+
+	manifest.Chunks = append(manifest.Chunks, Chunk{Hash: hash, Size: int64(len(chunk))})
+	manifest.Size += int64(len(chunk))
+	return nil
+}
+
+func (s *Store) ReadManifest(hash string) (*Manifest, error) {
+	key, _, err := s.locate(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isManifestKey(key) {
+		return nil, fmt.Errorf("blob %s is not chunked", hash)
+	}
+
+	return s.readManifestKey(key)
+}
+
+func (s *Store) readManifestKey(key string) (*Manifest, error) {
+	rc, err := s.backend.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix)
+}
+
+func (r *manifestReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := r.store.OpenBlob(r.chunks[0].Hash)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					return 0, fmt.Errorf("missing chunk %s: %w", r.chunks[0].Hash, err)
+				}
+				return 0, err
+			}
+			r.current = rc
+			r.chunks = r.chunks[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (r *manifestReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
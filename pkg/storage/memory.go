@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memoryWriter struct {
+	backend *MemoryBackend
+	buf     []byte
+	done    bool
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (b *MemoryBackend) Create() (ObjectWriter, error) {
+	return &memoryWriter{backend: b}, nil
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *memoryWriter) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func (w *memoryWriter) Commit(key string) error {
+	if w.done {
+		return fmt.Errorf("object writer already closed")
+	}
+	w.done = true
+
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+
+	if _, ok := w.backend.objects[key]; ok {
+		return nil
+	}
+
+	w.backend.objects[key] = memoryObject{
+		data:    w.buf,
+		modTime: time.Now(),
+	}
+
+	return nil
+}
+
+func (w *memoryWriter) Abort() error {
+	w.done = true
+	w.buf = nil
+	return nil
+}
+
+func (b *MemoryBackend) Open(key string) (io.ReadCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (b *MemoryBackend) Stat(key string) (ObjectInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	obj, ok := b.objects[key]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return ObjectInfo{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+func (b *MemoryBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.objects[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *MemoryBackend) Touch(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	obj, ok := b.objects[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	obj.modTime = time.Now()
+	b.objects[key] = obj
+	return nil
+}
+
+func (b *MemoryBackend) Walk(fn func(ObjectInfo) error) error {
+	b.mu.RLock()
+	infos := make([]ObjectInfo, 0, len(b.objects))
+	for key, obj := range b.objects {
+		infos = append(infos, ObjectInfo{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime})
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/merkle"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+const (
+	outboardSuffix = ".o"
+
+	// OutboardChunkSize is the span of blob content under each Merkle leaf,
+	// and so the most a verified range read has to read beyond what was
+	// asked for at either end.
+	OutboardChunkSize = 256 << 10
+
+	// OutboardMinSize is the size from which writes store an outboard up
+	// front. Smaller blobs get one the first time a verified range is read.
+	OutboardMinSize = 4 * OutboardChunkSize
+)
+
+var (
+	ErrRangeCorrupt = errors.New("range does not match the blob's merkle outboard")
+	ErrInvalidRange = errors.New("range outside the blob")
+)
+
+// Outboard is a Merkle tree over the fixed-size chunks of a blob, kept
+// beside it as <hash>.o so a range can be checked without hashing the whole
+// blob. Leaves are SHA-256 digests of the chunks, whatever algorithm names
+// the blob.
+type Outboard struct {
+	Size      int64    `json:"size"`
+	ChunkSize int64    `json:"chunk_size"`
+	Root      string   `json:"root"`
+	Leaves    []string `json:"leaves"`
+}
+
+// OutboardHash hashes a chunk or a pair of nodes of an outboard tree.
+func OutboardHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Tree rebuilds the Merkle tree and checks it against the recorded root, so
+// a damaged outboard is never trusted.
+func (o *Outboard) Tree() (*merkle.Tree, error) {
+	if o.ChunkSize <= 0 || len(o.Leaves) != outboardLeafCount(o.Size, o.ChunkSize) {
+		return nil, fmt.Errorf("outboard does not describe a %d byte blob", o.Size)
+	}
+
+	tree := merkle.NewTree(OutboardHash)
+	if err := tree.Build(o.Leaves); err != nil {
+		return nil, err
+	}
+
+	if root, _ := tree.RootHash(); root != o.Root {
+		return nil, fmt.Errorf("outboard leaves do not match its root")
+	}
+
+	return tree, nil
+}
+
+// Chunks returns the first and last leaf covering length bytes at offset.
+func (o *Outboard) Chunks(offset, length int64) (int, int) {
+	if length == 0 {
+		first := int(offset / o.ChunkSize)
+		return first, first
+	}
+	return int(offset / o.ChunkSize), int((offset + length - 1) / o.ChunkSize)
+}
+
+// Align widens a range to whole chunks, the unit a remote reader can verify.
+func (o *Outboard) Align(offset, length int64) (int64, int64) {
+	first, last := o.Chunks(offset, length)
+	start := int64(first) * o.ChunkSize
+	end := min(int64(last+1)*o.ChunkSize, o.Size)
+	return start, end - start
+}
+
+// RangeProof proves the leaves under a range to the outboard's root.
+func (o *Outboard) RangeProof(offset, length int64) (*merkle.RangeProof, error) {
+	tree, err := o.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := o.Chunks(offset, length)
+	return tree.GenerateRangeProof(first, last)
+}
+
+func outboardLeafCount(size, chunkSize int64) int {
+	if size == 0 {
+		return 1
+	}
+	return int((size + chunkSize - 1) / chunkSize)
+}
+
+func IsOutboardKey(key string) bool {
+	return strings.HasSuffix(key, outboardSuffix)
+}
+
+// outboardBuilder hashes content into leaves as it is written.
+type outboardBuilder struct {
+	buf    []byte
+	leaves []string
+	size   int64
+}
+
+func newOutboardBuilder() *outboardBuilder {
+	return &outboardBuilder{buf: make([]byte, 0, OutboardChunkSize)}
+}
+
+func (b *outboardBuilder) Write(p []byte) (int, error) {
+	n := len(p)
+	b.size += int64(n)
+
+	for len(p) > 0 {
+		take := min(len(p), OutboardChunkSize-len(b.buf))
+		b.buf = append(b.buf, p[:take]...)
+		p = p[take:]
+
+		if len(b.buf) == OutboardChunkSize {
+			b.leaves = append(b.leaves, OutboardHash(b.buf))
+			b.buf = b.buf[:0]
+		}
+	}
+
+	return n, nil
+}
+
+func (b *outboardBuilder) finish() (*Outboard, error) {
+	leaves := b.leaves
+	if len(b.buf) > 0 || len(leaves) == 0 {
+		leaves = append(leaves, OutboardHash(b.buf))
+	}
+
+	tree := merkle.NewTree(OutboardHash)
+	if err := tree.Build(leaves); err != nil {
+		return nil, err
+	}
+	root, _ := tree.RootHash()
+
+	return &Outboard{Size: b.size, ChunkSize: OutboardChunkSize, Root: root, Leaves: leaves}, nil
+}
+
+// OutboardWriter computes the outboard Merkle root of everything written to
+// it, for clients that want the root of content they send elsewhere.
+type OutboardWriter struct {
+	builder *outboardBuilder
+}
+
+func NewOutboardWriter() *OutboardWriter {
+	return &OutboardWriter{builder: newOutboardBuilder()}
+}
+
+func (w *OutboardWriter) Write(p []byte) (int, error) {
+	return w.builder.Write(p)
+}
+
+// Root returns the root of the outboard of what has been written so far.
+func (w *OutboardWriter) Root() (string, error) {
+	outboard, err := w.builder.finish()
+	if err != nil {
+		return "", err
+	}
+	return outboard.Root, nil
+}
+
+// storeOutboard saves the outboard built while writing a large blob. It is
+// derived data that a verified read rebuilds when missing, so a failure here
+// does not fail the write.
+func (s *Store) storeOutboard(hash string, builder *outboardBuilder) {
+	if builder.size < OutboardMinSize {
+		return
+	}
+
+	key := objects.CanonicalDigest(hash) + outboardSuffix
+	if _, err := s.backend.Stat(key); err == nil {
+		return
+	}
+
+	if outboard, err := builder.finish(); err == nil {
+		s.writeOutboard(key, outboard)
+	}
+}
+
+func (s *Store) writeOutboard(key string, outboard *Outboard) error {
+	data, err := json.Marshal(outboard)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outboard: %w", err)
+	}
+
+	writer, err := s.backend.Create()
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		writer.Abort()
+		return fmt.Errorf("failed to write outboard: %w", err)
+	}
+
+	return writer.Commit(key)
+}
+
+func (s *Store) readOutboard(hash string) (*Outboard, error) {
+	rc, err := s.backend.Open(objects.CanonicalDigest(hash) + outboardSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var outboard Outboard
+	if err := json.NewDecoder(rc).Decode(&outboard); err != nil {
+		return nil, fmt.Errorf("failed to parse outboard: %w", err)
+	}
+
+	return &outboard, nil
+}
+
+// Outboard returns the Merkle outboard of a blob, building it from the full
+// content when the blob has none yet or the stored one is damaged.
+func (s *Store) Outboard(hash string) (*Outboard, error) {
+	outboard, err := s.readOutboard(hash)
+	if err == nil {
+		if _, err = outboard.Tree(); err == nil {
+			return outboard, nil
+		}
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		s.backend.Delete(objects.CanonicalDigest(hash) + outboardSuffix)
+	}
+
+	return s.BuildOutboard(hash)
+}
+
+// BuildOutboard hashes a blob into a new outboard and stores it. The content
+// is checked against the blob's digest first, so an outboard never vouches
+// for corrupt data.
+func (s *Store) BuildOutboard(hash string) (*Outboard, error) {
+	algo, _, err := objects.ParseDigest(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher, err := objects.NewHasher(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := s.OpenBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	builder := newOutboardBuilder()
+	if _, err := io.Copy(io.MultiWriter(hasher, builder), rc); err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	if got := objects.FormatDigest(algo, hasher.Sum(nil)); got != objects.CanonicalDigest(hash) {
+		return nil, fmt.Errorf("blob %s content hashes to %s", objects.ShortDigest(hash), objects.ShortDigest(got))
+	}
+
+	outboard, err := builder.finish()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.writeOutboard(objects.CanonicalDigest(hash)+outboardSuffix, outboard); err != nil {
+		return nil, fmt.Errorf("failed to store outboard: %w", err)
+	}
+
+	return outboard, nil
+}
+
+// VerifyOutboard checks a stored outboard against the full blob, for fsck.
+func (s *Store) VerifyOutboard(hash string) error {
+	outboard, err := s.readOutboard(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := outboard.Tree(); err != nil {
+		return err
+	}
+
+	rc, err := s.OpenBlob(hash)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	builder := newOutboardBuilder()
+	if _, err := io.Copy(builder, rc); err != nil {
+		return fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	rebuilt, err := builder.finish()
+	if err != nil {
+		return err
+	}
+	if rebuilt.Root != outboard.Root || rebuilt.Size != outboard.Size {
+		return fmt.Errorf("outboard root does not match the blob")
+	}
+
+	return nil
+}
+
+// OpenVerifiedRange reads length bytes of a blob from offset, checking every
+// chunk it touches against the blob's outboard before returning any of its
+// bytes. A length running past the end is cut short.
+func (s *Store) OpenVerifiedRange(hash string, offset, length int64) (io.ReadCloser, error) {
+	outboard, err := s.Outboard(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || length < 0 || offset > outboard.Size {
+		return nil, fmt.Errorf("%w: %d+%d of %d bytes", ErrInvalidRange, offset, length, outboard.Size)
+	}
+	length = min(length, outboard.Size-offset)
+
+	rc, err := s.OpenBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	first, _ := outboard.Chunks(offset, length)
+	start := int64(first) * outboard.ChunkSize
+
+	if seeker, ok := rc.(io.Seeker); ok {
+		_, err = seeker.Seek(start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, rc, start)
+	}
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to seek to offset %d: %w", start, err)
+	}
+
+	return &verifiedReader{
+		src:       rc,
+		outboard:  outboard,
+		chunk:     first,
+		skip:      offset - start,
+		remaining: length,
+	}, nil
+}
+
+type verifiedReader struct {
+	src       io.ReadCloser
+	outboard  *Outboard
+	chunk     int
+	skip      int64
+	remaining int64
+	ready     []byte
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	if len(r.ready) == 0 {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.ready)
+	r.ready = r.ready[n:]
+	return n, nil
+}
+
+// next reads and checks one whole chunk, then exposes the part of it that
+// lies inside the range.
+func (r *verifiedReader) next() error {
+	start := int64(r.chunk) * r.outboard.ChunkSize
+	size := min(r.outboard.ChunkSize, r.outboard.Size-start)
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.src, data); err != nil {
+		return fmt.Errorf("failed to read chunk %d: %w", r.chunk, err)
+	}
+
+	if OutboardHash(data) != r.outboard.Leaves[r.chunk] {
+		return fmt.Errorf("%w: chunk %d", ErrRangeCorrupt, r.chunk)
+	}
+
+	data = data[r.skip:]
+	r.skip = 0
+	if int64(len(data)) > r.remaining {
+		data = data[:r.remaining]
+	}
+
+	r.ready = data
+	r.remaining -= int64(len(data))
+	r.chunk++
+	return nil
+}
+
+func (r *verifiedReader) Close() error {
+	return r.src.Close()
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newOutboardStore(t *testing.T) (*Store, *FSBackend) {
+	t.Helper()
+
+	backend, err := NewFSBackend(filepath.Join(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+	return NewStore(backend, Options{}), backend
+}
+
+func readRange(t *testing.T, store *Store, hash string, offset, length int64) ([]byte, error) {
+	t.Helper()
+
+	rc, err := store.OpenVerifiedRange(hash, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func TestOutboard_VerifiedRanges(t *testing.T) {
+	store, backend := newOutboardStore(t)
+
+	data := randomBlob(5*OutboardChunkSize + 123)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	if _, err := os.Stat(backend.Path(hash + outboardSuffix)); err != nil {
+		t.Fatalf("outboard not written with a large blob: %v", err)
+	}
+
+	ranges := [][2]int64{
+		{0, 10},
+		{OutboardChunkSize - 5, 10},
+		{2 * OutboardChunkSize, OutboardChunkSize},
+		{int64(len(data)) - 50, 50},
+		{int64(len(data)) - 50, 1000},
+		{0, int64(len(data))},
+	}
+	for _, r := range ranges {
+		got, err := readRange(t, store, hash, r[0], r[1])
+		if err != nil {
+			t.Fatalf("range %d+%d error: %v", r[0], r[1], err)
+		}
+		end := min(r[0]+r[1], int64(len(data)))
+		if !bytes.Equal(got, data[r[0]:end]) {
+			t.Errorf("range %d+%d returned different content", r[0], r[1])
+		}
+	}
+
+	if _, err := store.OpenVerifiedRange(hash, int64(len(data))+1, 1); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("range past the end error = %v, want ErrInvalidRange", err)
+	}
+}
+
+func TestOutboard_DetectsCorruptChunk(t *testing.T) {
+	store, backend := newOutboardStore(t)
+
+	data := randomBlob(4 * OutboardChunkSize)
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	path := backend.Path(hash)
+	damaged := append([]byte(nil), data...)
+	damaged[3*OutboardChunkSize+7] ^= 0xff
+	os.Chmod(path, 0644)
+	if err := os.WriteFile(path, damaged, 0444); err != nil {
+		t.Fatalf("os.WriteFile() error: %v", err)
+	}
+
+	if got, err := readRange(t, store, hash, 100, OutboardChunkSize); err != nil || !bytes.Equal(got, data[100:100+OutboardChunkSize]) {
+		t.Errorf("range over intact chunks = %v", err)
+	}
+
+	if _, err := readRange(t, store, hash, 3*OutboardChunkSize, 10); !errors.Is(err, ErrRangeCorrupt) {
+		t.Errorf("range over the damaged chunk error = %v, want ErrRangeCorrupt", err)
+	}
+
+	if err := store.VerifyOutboard(hash); err == nil {
+		t.Error("VerifyOutboard() accepted a damaged blob")
+	}
+}
+
+func TestOutboard_BuiltOnDemand(t *testing.T) {
+	store, backend := newOutboardStore(t)
+
+	data := []byte("small enough to skip an outboard on write")
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	outboardPath := backend.Path(hash + outboardSuffix)
+	if _, err := os.Stat(outboardPath); !os.IsNotExist(err) {
+		t.Fatal("small blob was written with an outboard")
+	}
+
+	got, err := readRange(t, store, hash, 6, 6)
+	if err != nil || string(got) != "enough" {
+		t.Fatalf("verified range = %q, %v", got, err)
+	}
+	if _, err := os.Stat(outboardPath); err != nil {
+		t.Fatalf("outboard not built on demand: %v", err)
+	}
+
+	if err := store.DeleteObject(hash); err != nil {
+		t.Fatalf("DeleteObject() error: %v", err)
+	}
+	if _, err := os.Stat(outboardPath); !os.IsNotExist(err) {
+		t.Error("outboard outlived its blob")
+	}
+}
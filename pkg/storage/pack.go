@@ -0,0 +1,571 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	packDirName       = "packs"
+	packTombstoneFile = "tombstones"
+
+	DefaultPackMaxObjectSize = 64 * 1024
+)
+
+var (
+	packMagic  = []byte("CASP\x00\x01")
+	indexMagic = []byte("CASI\x00\x01")
+)
+
+type PackBackend struct {
+	loose *FSBackend
+	dir   string
+	// repackMu keeps Delete out for the whole of a Repack, whose swap would
+	// otherwise lose a tombstone or resurrect a loose object deleted after
+	// it was copied into the new pack.
+	repackMu sync.Mutex
+	mu       sync.RWMutex
+	packs    []*packIndex
+	deleted  map[string]bool
+	loaded   bool
+	state    packState
+}
+
+type packState struct {
+	dirModTime    time.Time
+	tombstoneSize int64
+}
+
+type packIndex struct {
+	name    string
+	modTime time.Time
+	entries []packEntry
+}
+
+type packEntry struct {
+	key    string
+	offset int64
+	length int64
+}
+
+type packReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+type RepackResult struct {
+	PackedObjects  int
+	RemovedPacks   int
+	RemovedObjects int
+}
+
+func (r *packReader) Close() error {
+	return r.file.Close()
+}
+
+// NewPackBackend layers packfile lookups over loose objects. New objects are
+// always written loose; Repack moves small ones into packs.
+func NewPackBackend(loose *FSBackend) *PackBackend {
+	return &PackBackend{
+		loose: loose,
+		dir:   filepath.Join(loose.Root(), packDirName),
+	}
+}
+
+func (b *PackBackend) Loose() *FSBackend {
+	return b.loose
+}
+
+// RemoveStaleTemp clears abandoned temp files from both the loose object root
+// and the pack directory, where an interrupted repack leaves its own.
+func (b *PackBackend) RemoveStaleTemp(maxAge time.Duration) (int, error) {
+	removed, err := b.loose.RemoveStaleTemp(maxAge)
+	if err != nil {
+		return removed, err
+	}
+
+	n, err := removeStaleTemp(b.dir, maxAge)
+	return removed + n, err
+}
+
+func (b *PackBackend) Create() (ObjectWriter, error) {
+	return b.loose.Create()
+}
+
+func (b *PackBackend) Open(key string) (io.ReadCloser, error) {
+	return b.open(key, true)
+}
+
+func (b *PackBackend) open(key string, retry bool) (io.ReadCloser, error) {
+	rc, err := b.loose.Open(key)
+	if !errors.Is(err, ErrNotFound) {
+		return rc, err
+	}
+
+	pack, entry, err := b.find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(b.packPath(pack.name))
+	if err != nil {
+		if os.IsNotExist(err) && retry {
+			// A concurrent repack replaced the pack after we loaded its index.
+			b.invalidate()
+			return b.open(key, false)
+		}
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+
+	return &packReader{SectionReader: io.NewSectionReader(file, entry.offset, entry.length), file: file}, nil
+}
+
+func (b *PackBackend) Stat(key string) (ObjectInfo, error) {
+	info, err := b.loose.Stat(key)
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+
+	pack, entry, err := b.find(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Key: key, Size: entry.length, ModTime: pack.modTime}, nil
+}
+
+// Delete removes a loose object directly. Packs are append-only, so a
+// packed object is recorded in the tombstone list and dropped by the next
+// Repack.
+func (b *PackBackend) Delete(key string) error {
+	b.repackMu.Lock()
+	defer b.repackMu.Unlock()
+
+	err := b.loose.Delete(key)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if _, _, err := b.find(key); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(b.dir, packTombstoneFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open tombstones: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, key); err != nil {
+		return fmt.Errorf("failed to record tombstone: %w", err)
+	}
+
+	return nil
+}
+
+// Touch refreshes a loose object, or the index of the pack holding it, so
+// the object looks recently written to garbage collection.
+func (b *PackBackend) Touch(key string) error {
+	err := b.loose.Touch(key)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	pack, _, err := b.find(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(b.indexPath(pack.name), now, now); err != nil {
+		return fmt.Errorf("failed to touch pack: %w", err)
+	}
+
+	b.mu.Lock()
+	pack.modTime = now
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *PackBackend) Walk(fn func(ObjectInfo) error) error {
+	seen := make(map[string]bool)
+
+	err := b.loose.Walk(func(info ObjectInfo) error {
+		seen[info.Key] = true
+		return fn(info)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := b.load(); err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	packs := b.packs
+	deleted := b.deleted
+	b.mu.RUnlock()
+
+	for _, pack := range packs {
+		for _, entry := range pack.entries {
+			if seen[entry.key] || deleted[entry.key] {
+				continue
+			}
+			seen[entry.key] = true
+
+			if err := fn(ObjectInfo{Key: entry.key, Size: entry.length, ModTime: pack.modTime}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Repack writes every live packed object plus loose objects no larger than
+// maxObjectSize into a single new pack, then removes the packs and loose
+// files it replaced.
+func (b *PackBackend) Repack(maxObjectSize int64) (RepackResult, error) {
+	b.repackMu.Lock()
+	defer b.repackMu.Unlock()
+
+	if err := b.load(); err != nil {
+		return RepackResult{}, err
+	}
+
+	b.mu.RLock()
+	oldPacks := b.packs
+	deleted := b.deleted
+	b.mu.RUnlock()
+
+	var looseKeys []string
+	err := b.loose.Walk(func(info ObjectInfo) error {
+		if info.Size <= maxObjectSize {
+			looseKeys = append(looseKeys, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return RepackResult{}, err
+	}
+
+	if len(looseKeys) == 0 && len(oldPacks) <= 1 && len(deleted) == 0 {
+		return RepackResult{}, nil
+	}
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return RepackResult{}, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	tmpPack, err := os.CreateTemp(b.dir, "tmp-pack-")
+	if err != nil {
+		return RepackResult{}, fmt.Errorf("failed to create pack: %w", err)
+	}
+	defer os.Remove(tmpPack.Name())
+	defer tmpPack.Close()
+
+	packHasher := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(tmpPack, packHasher))
+	offset := int64(len(packMagic))
+	out.Write(packMagic)
+
+	var entries []packEntry
+	written := make(map[string]bool)
+
+	appendObject := func(key string, packed bool, open func() (io.ReadCloser, error)) error {
+		if written[key] || (packed && deleted[key]) {
+			return nil
+		}
+
+		rc, err := open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		n, err := io.Copy(out, rc)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s into pack: %w", key, err)
+		}
+
+		entries = append(entries, packEntry{key: key, offset: offset, length: n})
+		offset += n
+		written[key] = true
+		return nil
+	}
+
+	for _, key := range looseKeys {
+		if err := appendObject(key, false, func() (io.ReadCloser, error) { return b.loose.Open(key) }); err != nil {
+			return RepackResult{}, err
+		}
+	}
+
+	for _, pack := range oldPacks {
+		file, err := os.Open(b.packPath(pack.name))
+		if err != nil {
+			return RepackResult{}, fmt.Errorf("failed to open pack: %w", err)
+		}
+
+		for _, entry := range pack.entries {
+			err := appendObject(entry.key, true, func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(file, entry.offset, entry.length)), nil
+			})
+			if err != nil {
+				file.Close()
+				return RepackResult{}, err
+			}
+		}
+		file.Close()
+	}
+
+	if err := out.Flush(); err != nil {
+		return RepackResult{}, fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	if err := tmpPack.Sync(); err != nil {
+		return RepackResult{}, fmt.Errorf("failed to sync pack: %w", err)
+	}
+
+	name := fmt.Sprintf("pack-%x", packHasher.Sum(nil))
+	result := RepackResult{PackedObjects: len(entries)}
+
+	if len(entries) > 0 {
+		if err := os.Rename(tmpPack.Name(), b.packPath(name)); err != nil {
+			return RepackResult{}, fmt.Errorf("failed to install pack: %w", err)
+		}
+
+		if err := writePackIndex(b.indexPath(name), entries, b.loose.durability); err != nil {
+			// Without an index nothing can find the pack. Only remove it if
+			// it is new: identical content renames over an old pack, whose
+			// index is still in place.
+			if !slices.ContainsFunc(oldPacks, func(p *packIndex) bool { return p.name == name }) {
+				os.Remove(b.packPath(name))
+			}
+			return RepackResult{}, err
+		}
+	}
+
+	for _, pack := range oldPacks {
+		if pack.name == name {
+			continue
+		}
+		os.Remove(b.indexPath(pack.name))
+		os.Remove(b.packPath(pack.name))
+		result.RemovedPacks++
+	}
+
+	os.Remove(filepath.Join(b.dir, packTombstoneFile))
+
+	for _, key := range looseKeys {
+		if written[key] {
+			if err := b.loose.Delete(key); err == nil {
+				result.RemovedObjects++
+			}
+		}
+	}
+
+	b.invalidate()
+	return result, nil
+}
+
+func (b *PackBackend) find(key string) (*packIndex, packEntry, error) {
+	if err := b.load(); err != nil {
+		return nil, packEntry{}, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.deleted[key] {
+		for _, pack := range b.packs {
+			if entry, ok := pack.lookup(key); ok {
+				return pack, entry, nil
+			}
+		}
+	}
+
+	return nil, packEntry{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+func (b *PackBackend) invalidate() {
+	b.mu.Lock()
+	b.loaded = false
+	b.mu.Unlock()
+}
+
+func (b *PackBackend) load() error {
+	state := b.currentState()
+
+	b.mu.RLock()
+	fresh := b.loaded && b.state == state
+	b.mu.RUnlock()
+
+	if fresh {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	packs := []*packIndex{}
+	deleted := make(map[string]bool)
+
+	names, err := filepath.Glob(filepath.Join(b.dir, "pack-*.idx"))
+	if err != nil {
+		return err
+	}
+
+	for _, idxPath := range names {
+		name := strings.TrimSuffix(filepath.Base(idxPath), ".idx")
+
+		pack, err := readPackIndex(idxPath, name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		packs = append(packs, pack)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(b.dir, packTombstoneFile)); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				deleted[line] = true
+			}
+		}
+	}
+
+	b.packs = packs
+	b.deleted = deleted
+	b.loaded = true
+	b.state = state
+	return nil
+}
+
+// currentState captures what another process changes when it installs a
+// pack or records a tombstone, so cached indexes can be reused until then.
+func (b *PackBackend) currentState() packState {
+	var state packState
+
+	if info, err := os.Stat(b.dir); err == nil {
+		state.dirModTime = info.ModTime()
+	}
+
+	if info, err := os.Stat(filepath.Join(b.dir, packTombstoneFile)); err == nil {
+		state.tombstoneSize = info.Size()
+	}
+
+	return state
+}
+
+func (b *PackBackend) packPath(name string) string {
+	return filepath.Join(b.dir, name+".pack")
+}
+
+func (b *PackBackend) indexPath(name string) string {
+	return filepath.Join(b.dir, name+".idx")
+}
+
+func (p *packIndex) lookup(key string) (packEntry, bool) {
+	i := sort.Search(len(p.entries), func(i int) bool {
+		return p.entries[i].key >= key
+	})
+
+	if i < len(p.entries) && p.entries[i].key == key {
+		return p.entries[i], true
+	}
+
+	return packEntry{}, false
+}
+
+func writePackIndex(path string, entries []packEntry, durability string) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	var buf bytes.Buffer
+	buf.Write(indexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+
+	for _, entry := range entries {
+		binary.Write(&buf, binary.BigEndian, uint16(len(entry.key)))
+		buf.WriteString(entry.key)
+		binary.Write(&buf, binary.BigEndian, uint64(entry.offset))
+		binary.Write(&buf, binary.BigEndian, uint64(entry.length))
+	}
+
+	if err := writeFileAtomic(path, buf.Bytes(), 0444, durability); err != nil {
+		return fmt.Errorf("failed to install pack index: %w", err)
+	}
+
+	return nil
+}
+
+func readPackIndex(path, name string) (*packIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, indexMagic) {
+		return nil, fmt.Errorf("invalid pack index: %s", path)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("invalid pack index: %s", path)
+	}
+
+	pack := &packIndex{name: name, modTime: info.ModTime(), entries: make([]packEntry, 0, count)}
+
+	for i := uint32(0); i < count; i++ {
+		var keyLen uint16
+		if err := binary.Read(r, binary.BigEndian, &keyLen); err != nil {
+			return nil, fmt.Errorf("truncated pack index: %s", path)
+		}
+
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, fmt.Errorf("truncated pack index: %s", path)
+		}
+
+		var offset, length uint64
+		if err := binary.Read(r, binary.BigEndian, &offset); err != nil {
+			return nil, fmt.Errorf("truncated pack index: %s", path)
+		}
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("truncated pack index: %s", path)
+		}
+
+		pack.entries = append(pack.entries, packEntry{key: string(key), offset: int64(offset), length: int64(length)})
+	}
+
+	return pack, nil
+}
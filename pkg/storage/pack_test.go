@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupPackStore(t *testing.T) (*Store, *PackBackend) {
+	t.Helper()
+
+	loose, err := NewFSBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	backend := NewPackBackend(loose)
+	return NewStore(backend, Options{}), backend
+}
+
+func TestRepack_MovesLooseObjectsIntoPack(t *testing.T) {
+	store, backend := setupPackStore(t)
+
+	var hashes []string
+	for i := 0; i < 5; i++ {
+		hash, err := store.WriteBlobStream(strings.NewReader(fmt.Sprintf("small object %d", i)))
+		if err != nil {
+			t.Fatalf("WriteBlobStream() error: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	large, err := store.WriteBlobStream(strings.NewReader(strings.Repeat("x", 1024)))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	result, err := store.Repack(512)
+	if err != nil {
+		t.Fatalf("Repack() error: %v", err)
+	}
+
+	if result.PackedObjects != 5 {
+		t.Errorf("PackedObjects = %d, want 5", result.PackedObjects)
+	}
+
+	for i, hash := range hashes {
+		if _, err := os.Stat(backend.Loose().Path(hash)); !os.IsNotExist(err) {
+			t.Errorf("loose object %s still present after repack", hash)
+		}
+
+		data, err := store.ReadBlob(hash)
+		if err != nil {
+			t.Fatalf("ReadBlob() error: %v", err)
+		}
+		if want := fmt.Sprintf("small object %d", i); string(data) != want {
+			t.Errorf("ReadBlob() = %q, want %q", data, want)
+		}
+	}
+
+	if _, err := os.Stat(backend.Loose().Path(large)); err != nil {
+		t.Errorf("large object should stay loose: %v", err)
+	}
+
+	count := 0
+	store.Walk(func(info ObjectInfo) error {
+		count++
+		return nil
+	})
+	if count != 6 {
+		t.Errorf("Walk() visited %d objects, want 6", count)
+	}
+}
+
+func TestRepack_ConsolidatesPacks(t *testing.T) {
+	store, backend := setupPackStore(t)
+
+	for round := 0; round < 3; round++ {
+		if _, err := store.WriteBlobStream(strings.NewReader(fmt.Sprintf("round %d", round))); err != nil {
+			t.Fatalf("WriteBlobStream() error: %v", err)
+		}
+		if _, err := store.Repack(DefaultPackMaxObjectSize); err != nil {
+			t.Fatalf("Repack() error: %v", err)
+		}
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(backend.dir, "pack-*.idx"))
+	if len(packs) != 1 {
+		t.Errorf("found %d packs, want 1 after consolidation", len(packs))
+	}
+
+	for round := 0; round < 3; round++ {
+		hash, _ := NewStore(NewMemoryBackend(), Options{}).WriteBlobStream(strings.NewReader(fmt.Sprintf("round %d", round)))
+		if exists, _ := store.Exists(hash); !exists {
+			t.Errorf("object from round %d missing after consolidation", round)
+		}
+	}
+}
+
+func TestPackBackend_DeletePackedObject(t *testing.T) {
+	store, _ := setupPackStore(t)
+
+	keep, _ := store.WriteBlobStream(strings.NewReader("keep"))
+	drop, _ := store.WriteBlobStream(strings.NewReader("drop"))
+
+	if _, err := store.Repack(DefaultPackMaxObjectSize); err != nil {
+		t.Fatalf("Repack() error: %v", err)
+	}
+
+	if err := store.Delete(drop); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+
+	if _, err := store.OpenBlob(drop); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OpenBlob() error = %v, want ErrNotFound", err)
+	}
+
+	result, err := store.Repack(DefaultPackMaxObjectSize)
+	if err != nil {
+		t.Fatalf("Repack() error: %v", err)
+	}
+	if result.PackedObjects != 1 {
+		t.Errorf("PackedObjects = %d, want 1", result.PackedObjects)
+	}
+
+	if exists, _ := store.Exists(keep); !exists {
+		t.Error("kept object missing after repack")
+	}
+	if exists, _ := store.Exists(drop); exists {
+		t.Error("deleted object resurrected by repack")
+	}
+}
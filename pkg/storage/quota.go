@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+// EvictionGrace protects blobs touched this recently from eviction, so a blob
+// written by an add is not evicted before its catalog entry exists.
+const EvictionGrace = time.Minute
+
+var ErrNoQuota = errors.New("repository has no size limit")
+
+// ReferenceSource lists the blobs something outside the store still needs,
+// such as the hashes named by catalog entries. Referenced blobs and their
+// chunks are never evicted.
+type ReferenceSource interface {
+	Hashes() ([]string, error)
+}
+
+type CacheUsage struct {
+	MaxSize    int64
+	Used       int64
+	Objects    int
+	Pinned     int
+	PinnedSize int64
+}
+
+func (s *Store) SetReferences(refs ReferenceSource) {
+	s.refs = refs
+}
+
+func (s *Store) recordAccess(hash string, info ObjectInfo) {
+	if s.access == nil {
+		return
+	}
+
+	size := info.StoredSize
+	if size == 0 {
+		size = info.Size
+	}
+
+	s.access.Record(hash, size, time.Now())
+}
+
+func (s *Store) Pin(hash string) error {
+	if s.access == nil {
+		return ErrNoQuota
+	}
+
+	hash = objects.CanonicalDigest(hash)
+	if _, _, err := s.locate(hash); err != nil {
+		return err
+	}
+
+	return s.access.Pin(hash)
+}
+
+func (s *Store) Unpin(hash string) error {
+	if s.access == nil {
+		return ErrNoQuota
+	}
+
+	return s.access.Unpin(objects.CanonicalDigest(hash))
+}
+
+func (s *Store) Pinned() ([]string, error) {
+	if s.access == nil {
+		return nil, ErrNoQuota
+	}
+
+	return s.access.Pinned()
+}
+
+func (s *Store) CacheUsage() (CacheUsage, error) {
+	if s.access == nil || s.opts.MaxSize <= 0 {
+		return CacheUsage{}, ErrNoQuota
+	}
+
+	used, count, err := s.access.Usage()
+	if err != nil {
+		return CacheUsage{}, fmt.Errorf("failed to read access log: %w", err)
+	}
+
+	usage := CacheUsage{MaxSize: s.opts.MaxSize, Used: used, Objects: count}
+
+	pinned, err := s.access.Pinned()
+	if err != nil {
+		return CacheUsage{}, fmt.Errorf("failed to read pins: %w", err)
+	}
+
+	usage.Pinned = len(pinned)
+	for _, hash := range pinned {
+		if record, ok, err := s.access.Get(hash); err == nil && ok {
+			usage.PinnedSize += record.StoredSize
+		}
+	}
+
+	return usage, nil
+}
+
+// EnforceQuota evicts least recently used blobs until the repository fits in
+// its size limit. Blobs that are referenced, pinned, held or were used within
+// EvictionGrace are kept even if that leaves the repository over quota.
+// Without a ReferenceSource nothing is evicted, since the store cannot tell
+// which blobs are still needed.
+func (s *Store) EnforceQuota() ([]string, error) {
+	if s.access == nil || s.opts.MaxSize <= 0 || s.refs == nil {
+		return nil, nil
+	}
+
+	used, _, err := s.access.Usage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access log: %w", err)
+	}
+	if used <= s.opts.MaxSize {
+		return nil, nil
+	}
+
+	keep, err := s.protectedHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.access.LeastRecent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access log: %w", err)
+	}
+
+	cutoff := time.Now().Add(-EvictionGrace)
+	var evicted []string
+
+	for _, record := range records {
+		if used <= s.opts.MaxSize {
+			break
+		}
+		if keep[record.Hash] || record.LastAccess.After(cutoff) {
+			continue
+		}
+
+		if err := s.Delete(record.Hash); err != nil && !errors.Is(err, ErrNotFound) {
+			return evicted, fmt.Errorf("failed to evict %s: %w", record.Hash, err)
+		}
+		s.access.Forget(record.Hash)
+
+		used -= record.StoredSize
+		evicted = append(evicted, record.Hash)
+	}
+
+	return evicted, nil
+}
+
+func (s *Store) protectedHashes() (map[string]bool, error) {
+	hashes, err := s.refs.Hashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referenced blobs: %w", err)
+	}
+
+	pinned, err := s.access.Pinned()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pins: %w", err)
+	}
+
+	keep, err := s.heldHashes()
+	if err != nil {
+		return nil, err
+	}
+	s.addWithChunks(keep, append(hashes, pinned...))
+
+	return keep, nil
+}
+
+// seedAccessLog starts tracking objects that were stored before the access
+// log existed, using their modification time as the last access.
+func (s *Store) seedAccessLog() error {
+	_, count, err := s.access.Usage()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	return s.backend.Walk(func(info ObjectInfo) error {
+		if IsOutboardKey(info.Key) {
+			return nil
+		}
+
+		size := info.StoredSize
+		if size == 0 {
+			size = info.Size
+		}
+		return s.access.Record(HashFromKey(info.Key), size, info.ModTime)
+	})
+}
+
+func (s *Store) Close() error {
+	var err error
+	if inline, ok := inlineLayer(s.backend); ok {
+		err = inline.Close()
+	}
+	if s.access != nil {
+		err = errors.Join(err, s.access.Close())
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type staticRefs []string
+
+func (r staticRefs) Hashes() ([]string, error) {
+	return r, nil
+}
+
+func newCacheStore(t *testing.T, maxSize int64) *Store {
+	t.Helper()
+
+	access, err := OpenAccessLog(filepath.Join(t.TempDir(), AccessLogFile))
+	if err != nil {
+		t.Fatalf("OpenAccessLog() error: %v", err)
+	}
+
+	store := NewStore(NewMemoryBackend(), Options{MaxSize: maxSize})
+	if err := store.SetAccessLog(access); err != nil {
+		t.Fatalf("SetAccessLog() error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func writeSized(t *testing.T, store *Store, fill string, size int) string {
+	t.Helper()
+
+	hash, err := store.WriteBlobStream(strings.NewReader(strings.Repeat(fill, size)))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	return hash
+}
+
+func backdate(t *testing.T, store *Store, hash string, age time.Duration) {
+	t.Helper()
+
+	_, err := store.access.db.Exec("UPDATE access SET atime = ? WHERE hash = ?", time.Now().Add(-age).UnixNano(), hash)
+	if err != nil {
+		t.Fatalf("failed to backdate access: %v", err)
+	}
+}
+
+func TestStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	store := newCacheStore(t, 150)
+	store.SetReferences(staticRefs{})
+
+	oldest := writeSized(t, store, "a", 60)
+	older := writeSized(t, store, "b", 60)
+	backdate(t, store, oldest, 3*time.Hour)
+	backdate(t, store, older, 2*time.Hour)
+
+	newest := writeSized(t, store, "c", 60)
+
+	for hash, want := range map[string]bool{oldest: false, older: true, newest: true} {
+		if exists, _ := store.Exists(hash); exists != want {
+			t.Errorf("Exists(%s) = %v, want %v", hash[:8], exists, want)
+		}
+	}
+
+	usage, err := store.CacheUsage()
+	if err != nil {
+		t.Fatalf("CacheUsage() error: %v", err)
+	}
+	if usage.Used != 120 || usage.Objects != 2 {
+		t.Errorf("CacheUsage() = %+v, want 120 bytes in 2 objects", usage)
+	}
+}
+
+func TestStore_EvictionKeepsPinnedAndReferenced(t *testing.T) {
+	store := newCacheStore(t, 100)
+
+	pinned := writeSized(t, store, "a", 60)
+	referenced := writeSized(t, store, "b", 60)
+	store.SetReferences(staticRefs{referenced})
+
+	if err := store.Pin(pinned); err != nil {
+		t.Fatalf("Pin() error: %v", err)
+	}
+	backdate(t, store, pinned, time.Hour)
+	backdate(t, store, referenced, time.Hour)
+
+	writeSized(t, store, "c", 60)
+
+	for _, hash := range []string{pinned, referenced} {
+		if exists, _ := store.Exists(hash); !exists {
+			t.Errorf("blob %s was evicted", hash[:8])
+		}
+	}
+
+	if err := store.Unpin(pinned); err != nil {
+		t.Fatalf("Unpin() error: %v", err)
+	}
+	if evicted, err := store.EnforceQuota(); err != nil || len(evicted) != 1 || evicted[0] != pinned {
+		t.Errorf("EnforceQuota() = %v, %v, want the unpinned blob evicted", evicted, err)
+	}
+}
+
+func TestStore_ReadRefreshesAccess(t *testing.T) {
+	store := newCacheStore(t, 100)
+	store.SetReferences(staticRefs{})
+
+	read := writeSized(t, store, "a", 60)
+	unread := writeSized(t, store, "b", 30)
+	backdate(t, store, read, 3*time.Hour)
+	backdate(t, store, unread, 2*time.Hour)
+
+	if _, err := store.ReadBlob(read); err != nil {
+		t.Fatalf("ReadBlob() error: %v", err)
+	}
+	backdate(t, store, read, time.Hour)
+
+	writeSized(t, store, "c", 30)
+
+	if exists, _ := store.Exists(unread); exists {
+		t.Error("least recently read blob was kept")
+	}
+	if exists, _ := store.Exists(read); !exists {
+		t.Error("recently read blob was evicted")
+	}
+}
+
+func TestStore_PinWithoutQuota(t *testing.T) {
+	store := NewStore(NewMemoryBackend(), Options{})
+
+	if err := store.Pin("anything"); !errors.Is(err, ErrNoQuota) {
+		t.Errorf("Pin() error = %v, want ErrNoQuota", err)
+	}
+}
@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+type S3Backend struct {
+	config S3Config
+	client *http.Client
+}
+
+type s3Writer struct {
+	backend *S3Backend
+	file    *os.File
+	done    bool
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("s3 backend requires an endpoint and a bucket")
+	}
+
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	config.Endpoint = strings.TrimRight(config.Endpoint, "/")
+
+	return &S3Backend{
+		config: config,
+		client: &http.Client{Timeout: 0},
+	}, nil
+}
+
+func (b *S3Backend) objectName(key string) string {
+	return b.config.Prefix + key
+}
+
+func (b *S3Backend) Create() (ObjectWriter, error) {
+	tmpFile, err := os.CreateTemp("", "cas-s3-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	return &s3Writer{backend: b, file: tmpFile}, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *s3Writer) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, off)
+}
+
+func (w *s3Writer) Commit(key string) error {
+	if w.done {
+		return fmt.Errorf("object writer already closed")
+	}
+	w.done = true
+
+	defer os.Remove(w.file.Name())
+	defer w.file.Close()
+
+	if _, err := w.backend.Stat(key); err == nil {
+		return nil
+	}
+
+	size, err := w.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to size upload: %w", err)
+	}
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload: %w", err)
+	}
+
+	resp, err := w.backend.do(http.MethodPut, w.backend.objectName(key), nil, io.NopCloser(w.file), size)
+	if err != nil {
+		return fmt.Errorf("s3 put failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+func (b *S3Backend) Open(key string) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, b.objectName(key), nil, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("s3 get failed: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+
+	return resp.Body, nil
+}
+
+func (b *S3Backend) Stat(key string) (ObjectInfo, error) {
+	resp, err := b.do(http.MethodHead, b.objectName(key), nil, nil, 0)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("s3 head failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return ObjectInfo{}, s3Error(resp)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (b *S3Backend) Delete(key string) error {
+	if _, err := b.Stat(key); err != nil {
+		return err
+	}
+
+	resp, err := b.do(http.MethodDelete, b.objectName(key), nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("s3 delete failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+// Touch refreshes LastModified by copying the object onto itself.
+func (b *S3Backend) Touch(key string) error {
+	name := b.objectName(key)
+
+	resp, err := b.doWithHeaders(http.MethodPut, name, nil, nil, 0, map[string]string{
+		"x-amz-copy-source":        "/" + b.config.Bucket + "/" + name,
+		"x-amz-metadata-directive": "REPLACE",
+	})
+	if err != nil {
+		return fmt.Errorf("s3 copy failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+func (b *S3Backend) Walk(fn func(ObjectInfo) error) error {
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if b.config.Prefix != "" {
+			query.Set("prefix", b.config.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := b.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return fmt.Errorf("s3 list failed: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse s3 listing: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			info := ObjectInfo{
+				Key:     strings.TrimPrefix(obj.Key, b.config.Prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (b *S3Backend) do(method, name string, query url.Values, body io.ReadCloser, size int64) (*http.Response, error) {
+	return b.doWithHeaders(method, name, query, body, size, nil)
+}
+
+func (b *S3Backend) doWithHeaders(method, name string, query url.Values, body io.ReadCloser, size int64, extra map[string]string) (*http.Response, error) {
+	path := "/" + b.config.Bucket
+	if name != "" {
+		path += "/" + name
+	}
+
+	rawQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	reqURL := b.config.Endpoint + path
+	if rawQuery != "" {
+		reqURL += "?" + rawQuery
+	}
+
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	for name, value := range extra {
+		req.Header.Set(name, value)
+	}
+
+	b.sign(req, path, rawQuery, time.Now().UTC())
+
+	return b.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header. Payloads are
+// sent unsigned so uploads can be streamed from disk.
+func (b *S3Backend) sign(req *http.Request, path, rawQuery string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+
+	if b.config.AccessKey == "" {
+		return
+	}
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		rawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, b.config.Region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.config.SecretKey), date)
+	key = hmacSHA256(key, b.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.config.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
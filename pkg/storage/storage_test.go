@@ -10,13 +10,27 @@ import (
 	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
 )
 
+// openStore opens the repository at casDir once for the whole test, the way
+// callers are expected to hold a single Store.
+func openStore(t *testing.T, casDir string) *Store {
+	t.Helper()
+
+	store, err := Open(casDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
 func TestWriteBlob(t *testing.T) {
 	casDir := t.TempDir()
+	store := openStore(t, casDir)
 
 	content := []byte("hello, this is the mini-CAS")
 	blob := objects.NewBlob(content)
 
-	hash, err := WriteBlob(casDir, *blob)
+	hash, err := store.WriteBlob(*blob)
 
 	if err != nil {
 		t.Fatalf("WriteBlob() error: %v", err)
@@ -43,11 +57,12 @@ func TestWriteBlob(t *testing.T) {
 
 func TestWriteBlob_Deduplication(t *testing.T) {
 	casDir := t.TempDir()
+	store := openStore(t, casDir)
 
 	content := []byte("duplicate")
 	blob := objects.NewBlob(content)
 
-	hash1, err := WriteBlob(casDir, *blob)
+	hash1, err := store.WriteBlob(*blob)
 	if err != nil {
 		t.Fatalf("first WriteBlob() error: %v", err)
 	}
@@ -57,9 +72,8 @@ func TestWriteBlob_Deduplication(t *testing.T) {
 	if err != nil {
 		t.Fatalf("os.Stat() error: %v", err)
 	}
-	modTime1 := stat1.ModTime()
 
-	hash2, err := WriteBlob(casDir, *blob)
+	hash2, err := store.WriteBlob(*blob)
 	if err != nil {
 		t.Fatalf("second WriteBlob() error: %v", err)
 	}
@@ -72,10 +86,9 @@ func TestWriteBlob_Deduplication(t *testing.T) {
 	if err != nil {
 		t.Fatalf("os.Stat() error: %v", err)
 	}
-	modTime2 := stat2.ModTime()
 
-	if !modTime1.Equal(modTime2) {
-		t.Errorf("file was rewritten: modTime1=%v, modTime2=%v", modTime1, modTime2)
+	if !os.SameFile(stat1, stat2) {
+		t.Error("file was rewritten on duplicate write")
 	}
 }
 
@@ -83,11 +96,12 @@ func TestWriteBlobStream(t *testing.T) {
 	casDir := t.TempDir()
 
 	os.MkdirAll(filepath.Join(casDir, "storage"), 0755)
+	store := openStore(t, casDir)
 
 	content := "streaming content test"
 	reader := strings.NewReader(content)
 
-	hash, err := WriteBlobStream(casDir, reader)
+	hash, err := store.WriteBlobStream(reader)
 	if err != nil {
 		t.Fatalf("WriteBlobStream() error: %v", err)
 	}
@@ -114,15 +128,16 @@ func TestWriteBlobStream(t *testing.T) {
 
 func TestReadBlob(t *testing.T) {
 	casDir := t.TempDir()
+	store := openStore(t, casDir)
 	content := []byte("data to be read back")
 	blob := objects.NewBlob(content)
 
-	hash, err := WriteBlob(casDir, *blob)
+	hash, err := store.WriteBlob(*blob)
 	if err != nil {
 		t.Fatalf("setup: WriteBlob() error: %v", err)
 	}
 
-	data, err := ReadBlob(casDir, hash)
+	data, err := store.ReadBlob(hash)
 	if err != nil {
 		t.Fatalf("ReadBlob() error: %v", err)
 	}
@@ -136,10 +151,11 @@ func TestReadBlob_NotFound(t *testing.T) {
 	casDir := t.TempDir()
 
 	os.MkdirAll(filepath.Join(casDir, "storage"), 0755)
+	store := openStore(t, casDir)
 
 	fakeHash := "abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890"
 
-	_, err := ReadBlob(casDir, fakeHash)
+	_, err := store.ReadBlob(fakeHash)
 
 	if err == nil {
 		t.Error("ReadBlob() expected error for non-existent blob, got nil")
@@ -148,16 +164,17 @@ func TestReadBlob_NotFound(t *testing.T) {
 
 func TestLoadBlob(t *testing.T) {
 	casDir := t.TempDir()
+	store := openStore(t, casDir)
 
 	content := []byte("content for LoadBlob test")
 	blob := objects.NewBlob(content)
 
-	hash, err := WriteBlob(casDir, *blob)
+	hash, err := store.WriteBlob(*blob)
 	if err != nil {
 		t.Fatalf("setup: WriteBlob() error: %v", err)
 	}
 
-	loadedBlob, err := LoadBlob(casDir, hash)
+	loadedBlob, err := store.LoadBlob(hash)
 	if err != nil {
 		t.Fatalf("LoadBlob() error: %v", err)
 	}
@@ -173,16 +190,17 @@ func TestLoadBlob(t *testing.T) {
 
 func TestOpenBlob(t *testing.T) {
 	casDir := t.TempDir()
+	store := openStore(t, casDir)
 
 	content := []byte("content for OpenBlob test")
 	blob := objects.NewBlob(content)
 
-	hash, err := WriteBlob(casDir, *blob)
+	hash, err := store.WriteBlob(*blob)
 	if err != nil {
 		t.Fatalf("setup: WriteBlob() error: %v", err)
 	}
 
-	reader, err := OpenBlob(casDir, hash)
+	reader, err := store.OpenBlob(hash)
 	if err != nil {
 		t.Fatalf("OpenBlob() error: %v", err)
 	}
@@ -201,10 +219,11 @@ func TestOpenBlob(t *testing.T) {
 func TestOpenBlob_NotFound(t *testing.T) {
 	casDir := t.TempDir()
 	os.MkdirAll(filepath.Join(casDir, "storage"), 0755)
+	store := openStore(t, casDir)
 
 	fakeHash := "abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890"
 
-	reader, err := OpenBlob(casDir, fakeHash)
+	reader, err := store.OpenBlob(fakeHash)
 	if err == nil {
 		t.Error("OpenBlob() expected error, got nil")
 	}
@@ -218,15 +237,16 @@ func TestOpenBlob_NotFound(t *testing.T) {
 func TestWriteBlobStream_OpenBlob_RoundTrip(t *testing.T) {
 	casDir := t.TempDir()
 	os.MkdirAll(filepath.Join(casDir, "storage"), 0755)
+	store := openStore(t, casDir)
 
 	content := "round-trip streaming content"
 
-	hash, err := WriteBlobStream(casDir, strings.NewReader(content))
+	hash, err := store.WriteBlobStream(strings.NewReader(content))
 	if err != nil {
 		t.Fatalf("WriteBlobStream() error: %v", err)
 	}
 
-	reader, err := OpenBlob(casDir, hash)
+	reader, err := store.OpenBlob(hash)
 	if err != nil {
 		t.Fatalf("OpenBlob() error: %v", err)
 	}
@@ -0,0 +1,639 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+	"github.com/SteliosSpanos/mini-CAS/pkg/path"
+)
+
+type Store struct {
+	backend Backend
+	opts    Options
+	access  *AccessLog
+	refs    ReferenceSource
+	holds   HoldSource
+	hooks   *hooks.Hooks
+	// frozen is shared with clones, so FreezeHolds covers them too.
+	frozen *frozenHolds
+}
+
+type Options struct {
+	Compression   string
+	Chunking      bool
+	ChunkAvgSize  int
+	HashAlgorithm string
+	MaxSize       int64
+	DemoteAfter   time.Duration
+}
+
+func NewStore(backend Backend, opts Options) *Store {
+	return &Store{backend: backend, opts: opts, frozen: &frozenHolds{}}
+}
+
+func Open(casDir string) (*Store, error) {
+	cfg, err := path.LoadConfig(casDir)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := NewBackend(casDir, cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ValidCompression(cfg.Storage.Compression) {
+		return nil, fmt.Errorf("unknown compression: %s", cfg.Storage.Compression)
+	}
+
+	if !objects.ValidAlgorithm(cfg.HashAlgorithm) {
+		return nil, fmt.Errorf("unknown hash algorithm: %s", cfg.HashAlgorithm)
+	}
+
+	store := NewStore(backend, Options{
+		Compression:   cfg.Storage.Compression,
+		Chunking:      cfg.Storage.Chunking,
+		ChunkAvgSize:  cfg.Storage.ChunkAvgSize,
+		HashAlgorithm: cfg.HashAlgorithm,
+		MaxSize:       cfg.Storage.MaxSize,
+		DemoteAfter:   time.Duration(cfg.Storage.DemoteAfter) * 24 * time.Hour,
+	})
+
+	if cfg.Storage.MaxSize > 0 || cfg.Storage.ColdDir != "" {
+		access, err := OpenAccessLog(filepath.Join(casDir, AccessLogFile))
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetAccessLog(access); err != nil {
+			access.Close()
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// SetAccessLog starts recording blob accesses, which size-limited
+// repositories use to pick eviction candidates and tiered ones to pick blobs
+// to demote.
+func (s *Store) SetAccessLog(access *AccessLog) error {
+	s.access = access
+	if err := s.seedAccessLog(); err != nil {
+		return fmt.Errorf("failed to seed access log: %w", err)
+	}
+	return nil
+}
+
+// NewBackend builds the backend described by cfg, wrapped in an
+// InlineBackend when small blobs are inlined and in an EncryptedBackend when
+// the repository is encrypted.
+func NewBackend(casDir string, cfg path.StorageConfig) (Backend, error) {
+	backend, err := newBaseBackend(casDir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.InlineThreshold > 0 {
+		backend, err = NewInlineBackend(backend, casDir, cfg.InlineThreshold)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !cfg.Encryption {
+		return backend, nil
+	}
+
+	keyFile := firstNonEmpty(cfg.KeyFile, DefaultKeyFile)
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(casDir, keyFile)
+	}
+
+	key, err := LoadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.KeyID != "" && KeyID(key) != cfg.KeyID {
+		return nil, fmt.Errorf("encryption key does not match this repository")
+	}
+
+	return NewEncryptedBackend(backend, key)
+}
+
+func newBaseBackend(casDir string, cfg path.StorageConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "", "fs":
+		fsBackend, err := openFSBackend(casDir, firstNonEmpty(cfg.Dir, "storage"), cfg.Durability)
+		if err != nil {
+			return nil, err
+		}
+
+		backend := NewPackBackend(fsBackend)
+		if _, err := backend.RemoveStaleTemp(StaleTempAge); err != nil {
+			return nil, fmt.Errorf("failed to clean up temp files: %w", err)
+		}
+
+		if cfg.ColdDir == "" {
+			return backend, nil
+		}
+
+		cold, err := openFSBackend(casDir, cfg.ColdDir, cfg.Durability)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := cold.RemoveStaleTemp(StaleTempAge); err != nil {
+			return nil, fmt.Errorf("failed to clean up temp files: %w", err)
+		}
+
+		return NewTieredBackend(backend, cold), nil
+	case "erasure":
+		roots := make([]Backend, len(cfg.Roots))
+		for i, dir := range cfg.Roots {
+			root, err := openFSBackend(casDir, dir, cfg.Durability)
+			if err != nil {
+				return nil, err
+			}
+			roots[i] = root
+		}
+
+		backend, err := NewErasureBackend(roots, max(cfg.ParityShards, 1), filepath.Join(casDir, "staging"))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := backend.RemoveStaleTemp(StaleTempAge); err != nil {
+			return nil, fmt.Errorf("failed to clean up temp files: %w", err)
+		}
+		return backend, nil
+	case "memory":
+		return NewMemoryBackend(), nil
+	case "s3":
+		return NewS3Backend(S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Bucket:    cfg.S3.Bucket,
+			Region:    cfg.S3.Region,
+			Prefix:    cfg.S3.Prefix,
+			AccessKey: firstNonEmpty(os.Getenv("CAS_S3_ACCESS_KEY"), cfg.S3.AccessKey),
+			SecretKey: firstNonEmpty(os.Getenv("CAS_S3_SECRET_KEY"), cfg.S3.SecretKey),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
+
+func openFSBackend(casDir, dir, durability string) (*FSBackend, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(casDir, dir)
+	}
+
+	backend, err := NewFSBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.SetDurability(durability); err != nil {
+		return nil, err
+	}
+	if err := backend.FollowFormat(casDir); err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+// SetHooks sends blob events to h.
+func (s *Store) SetHooks(h *hooks.Hooks) {
+	s.hooks = h
+}
+
+func (s *Store) Backend() Backend {
+	return s.backend
+}
+
+func (s *Store) WriteBlob(blob objects.Blob) (string, error) {
+	return s.WriteBlobStream(bytes.NewReader(blob.Data))
+}
+
+func (s *Store) WriteBlobStream(reader io.Reader) (string, error) {
+	write := s.writeObject
+	if s.opts.Chunking {
+		write = s.writeChunked
+	}
+
+	hash, err := write(reader)
+	if err != nil {
+		return "", err
+	}
+
+	// The blob is safely stored either way; a failed eviction only leaves
+	// the repository over quota until the next write.
+	s.EnforceQuota()
+
+	return hash, nil
+}
+
+// writeObject stores a whole blob as a single object, with a Merkle outboard
+// when it is large.
+func (s *Store) writeObject(reader io.Reader) (string, error) {
+	outboard := newOutboardBuilder()
+
+	hash, err := s.writeEncoded(io.TeeReader(reader, outboard), true)
+	if err != nil {
+		return "", err
+	}
+
+	s.storeOutboard(hash, outboard)
+	return hash, nil
+}
+
+// writeEncoded stores content as a single object. With announce set, write
+// hooks hear about it as a blob, and a pre hook can veto it before it is
+// committed; chunks are not announced, the blob they make up is.
+func (s *Store) writeEncoded(reader io.Reader, announce bool) (string, error) {
+	counter := &countingReader{r: reader}
+
+	writer, hash, key, err := s.encode(counter, s.opts.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	event := hooks.Event{Kind: hooks.BlobWritten, Hash: hash, Size: counter.n}
+
+	if existing, info, err := s.locate(hash); err == nil {
+		writer.Abort()
+
+		event.Kind = hooks.BlobDeduplicated
+		if announce {
+			if err := s.hooks.Pre(event); err != nil {
+				return "", err
+			}
+		}
+
+		// Refresh the existing copy so a concurrent gc treats it as new
+		// until the caller has had time to reference it.
+		s.backend.Touch(existing)
+		s.recordAccess(hash, info)
+
+		if announce {
+			s.hooks.Post(event)
+		}
+		return hash, nil
+	}
+
+	if announce {
+		if err := s.hooks.Pre(event); err != nil {
+			writer.Abort()
+			return "", err
+		}
+	}
+
+	if err := writer.Commit(key); err != nil {
+		return "", err
+	}
+
+	if s.access != nil {
+		if info, err := s.backend.Stat(key); err == nil {
+			s.recordAccess(hash, info)
+		}
+	}
+
+	if announce {
+		s.hooks.Post(event)
+	}
+
+	return hash, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// encode writes content to a new backend object in the repository's
+// encoding and hashes it with algo. The object is left for the caller to
+// commit under the returned key or abort.
+func (s *Store) encode(reader io.Reader, algo string) (ObjectWriter, string, string, error) {
+	writer, err := s.backend.Create()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	var dst io.Writer = writer
+	var compressor io.WriteCloser
+	var codec byte
+
+	if compressionEnabled(s.opts.Compression) {
+		if _, err := writer.Write(make([]byte, compressedHeaderSize)); err != nil {
+			writer.Abort()
+			return nil, "", "", fmt.Errorf("failed to reserve header: %w", err)
+		}
+
+		compressor, codec, err = newCompressor(s.opts.Compression, writer)
+		if err != nil {
+			writer.Abort()
+			return nil, "", "", err
+		}
+		dst = compressor
+	}
+
+	hasher, err := objects.NewHasher(algo)
+	if err != nil {
+		writer.Abort()
+		return nil, "", "", err
+	}
+	multiWriter := io.MultiWriter(dst, hasher)
+
+	size, err := io.Copy(multiWriter, reader)
+	if err != nil {
+		writer.Abort()
+		return nil, "", "", fmt.Errorf("failed to copy: %w", err)
+	}
+
+	hash := objects.FormatDigest(algo, hasher.Sum(nil))
+	key := hash
+
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			writer.Abort()
+			return nil, "", "", fmt.Errorf("failed to compress: %w", err)
+		}
+
+		if _, err := writer.WriteAt(encodeCompressedHeader(codec, size), 0); err != nil {
+			writer.Abort()
+			return nil, "", "", fmt.Errorf("failed to write header: %w", err)
+		}
+		key = hash + compressedSuffix
+	}
+
+	return writer, hash, key, nil
+}
+
+// locate finds the stored representation of a blob, preferring the encoding
+// the repository currently writes so mixed repositories resolve quickly.
+func (s *Store) locate(hash string) (string, ObjectInfo, error) {
+	hash = objects.CanonicalDigest(hash)
+	keys := []string{hash, hash + compressedSuffix, hash + manifestSuffix}
+	if compressionEnabled(s.opts.Compression) {
+		keys[0], keys[1] = keys[1], keys[0]
+	}
+
+	for _, key := range keys {
+		info, err := s.backend.Stat(key)
+		if err == nil {
+			return key, info, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", ObjectInfo{}, err
+		}
+	}
+
+	return "", ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, hash)
+}
+
+func (s *Store) OpenBlob(hash string) (io.ReadCloser, error) {
+	key, info, err := s.locate(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordAccess(objects.CanonicalDigest(hash), info)
+	s.promote(key)
+
+	return s.OpenObject(key)
+}
+
+// OpenObject decodes one stored object by its backend key, whichever
+// encoding the key names. Unlike OpenBlob it does not choose between copies,
+// which lets fsck check each of them.
+func (s *Store) OpenObject(key string) (io.ReadCloser, error) {
+	if isManifestKey(key) {
+		manifest, err := s.readManifestKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &manifestReader{store: s, chunks: manifest.Chunks}, nil
+	}
+
+	rc, err := s.backend.Open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(key, compressedSuffix) {
+		return openCompressed(rc)
+	}
+
+	return rc, nil
+}
+
+// ObjectPath returns the file that holds a blob's content byte for byte, if
+// there is one: a loose object that is neither compressed, chunked, packed
+// nor encrypted. Callers may link to the file but must never modify it.
+func (s *Store) ObjectPath(hash string) (string, bool) {
+	key, _, err := s.locate(hash)
+	if err != nil || key != objects.CanonicalDigest(hash) {
+		return "", false
+	}
+
+	// Cold objects may live on another filesystem; bring them back first.
+	s.promote(key)
+
+	loose, ok := plainLoose(s.backend)
+	if !ok {
+		return "", false
+	}
+
+	path, err := loose.Locate(key)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// plainLoose finds the filesystem backend holding objects as written, which
+// encryption rules out.
+func plainLoose(b Backend) (*FSBackend, bool) {
+	if _, ok := Unwrap[*EncryptedBackend](b); ok {
+		return nil, false
+	}
+	return Unwrap[*FSBackend](b)
+}
+
+// WriteBlobStreamAs stores a blob hashed with algo rather than the
+// repository's configured algorithm, for restoring a blob under a digest it
+// already has.
+func (s *Store) WriteBlobStreamAs(algo string, reader io.Reader) (string, error) {
+	clone := *s
+	clone.opts.HashAlgorithm = algo
+	return clone.WriteBlobStream(reader)
+}
+
+func (s *Store) ReadBlob(hash string) ([]byte, error) {
+	reader, err := s.OpenBlob(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return data, nil
+}
+
+// Stat reports the logical (uncompressed) size of a blob in Size and the
+// number of bytes it occupies in the backend in StoredSize. For chunked blobs
+// StoredSize includes every chunk, even those shared with other blobs.
+func (s *Store) Stat(hash string) (ObjectInfo, error) {
+	key, info, err := s.locate(hash)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info.Key = hash
+	if info.StoredSize == 0 {
+		info.StoredSize = info.Size
+	}
+
+	if strings.HasSuffix(key, compressedSuffix) {
+		rc, err := s.backend.Open(key)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		defer rc.Close()
+
+		_, size, err := readCompressedHeader(rc)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		info.Size = size
+	}
+
+	if isManifestKey(key) {
+		manifest, err := s.readManifestKey(key)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+
+		info.Size = manifest.Size
+		for _, chunk := range manifest.Chunks {
+			chunkInfo, err := s.Stat(chunk.Hash)
+			if err != nil {
+				return ObjectInfo{}, fmt.Errorf("missing chunk %s: %w", chunk.Hash, err)
+			}
+			info.StoredSize += chunkInfo.StoredSize
+		}
+	}
+
+	return info, nil
+}
+
+func (s *Store) Exists(hash string) (bool, error) {
+	if _, _, err := s.locate(hash); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *Store) Delete(hash string) error {
+	if err := s.checkHold(hash); err != nil {
+		return err
+	}
+
+	key, _, err := s.locate(hash)
+	if err != nil {
+		return err
+	}
+
+	if err := s.backend.Delete(key); err != nil {
+		return err
+	}
+
+	if s.access != nil {
+		s.access.Forget(objects.CanonicalDigest(hash))
+	}
+
+	return nil
+}
+
+// DeleteObject removes a single backend object, as reported by Walk.
+func (s *Store) DeleteObject(key string) error {
+	if !IsOutboardKey(key) {
+		if err := s.checkHold(HashFromKey(key)); err != nil {
+			return err
+		}
+	}
+
+	if err := s.backend.Delete(key); err != nil {
+		return err
+	}
+
+	// An outboard is only meaningful next to its blob.
+	if !IsOutboardKey(key) {
+		s.backend.Delete(HashFromKey(key) + outboardSuffix)
+	}
+
+	if s.access != nil {
+		s.access.Forget(HashFromKey(key))
+	}
+
+	return nil
+}
+
+// Walk visits every object in the backend. Keys are reported as stored, so
+// encoded objects keep their suffix; use HashFromKey to recover the address.
+func (s *Store) Walk(fn func(ObjectInfo) error) error {
+	return s.backend.Walk(fn)
+}
+
+func (s *Store) Repack(maxObjectSize int64) (RepackResult, error) {
+	repacker, ok := s.backend.(interface {
+		Repack(maxObjectSize int64) (RepackResult, error)
+	})
+	if !ok {
+		return RepackResult{}, fmt.Errorf("storage backend does not support packs")
+	}
+
+	return repacker.Repack(maxObjectSize)
+}
+
+// SupportsPacks reports whether Repack can succeed, which the wrapping
+// backends cannot tell by their methods alone.
+func (s *Store) SupportsPacks() bool {
+	_, ok := Unwrap[*PackBackend](s.backend)
+	return ok
+}
+
+func HashFromKey(key string) string {
+	key = strings.TrimSuffix(key, compressedSuffix)
+	key = strings.TrimSuffix(key, outboardSuffix)
+	return strings.TrimSuffix(key, manifestSuffix)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/hooks"
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+func TestStore_CompressionRoundTrip(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionFlate} {
+		t.Run(compression, func(t *testing.T) {
+			backend := NewMemoryBackend()
+			store := NewStore(backend, Options{Compression: compression})
+
+			content := strings.Repeat("compressible text line\n", 500)
+
+			hash, err := store.WriteBlobStream(strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("WriteBlobStream() error: %v", err)
+			}
+
+			plainHash, err := NewStore(NewMemoryBackend(), Options{}).WriteBlobStream(strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("WriteBlobStream() error: %v", err)
+			}
+
+			if hash != plainHash {
+				t.Errorf("compressed hash = %s, want %s", hash, plainHash)
+			}
+
+			reader, err := store.OpenBlob(hash)
+			if err != nil {
+				t.Fatalf("OpenBlob() error: %v", err)
+			}
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("io.ReadAll() error: %v", err)
+			}
+
+			if string(data) != content {
+				t.Errorf("decompressed content mismatch: got %d bytes, want %d", len(data), len(content))
+			}
+
+			info, err := store.Stat(hash)
+			if err != nil {
+				t.Fatalf("Stat() error: %v", err)
+			}
+
+			if info.Size != int64(len(content)) {
+				t.Errorf("Stat().Size = %d, want %d", info.Size, len(content))
+			}
+
+			if info.StoredSize >= info.Size {
+				t.Errorf("Stat().StoredSize = %d, want less than %d", info.StoredSize, info.Size)
+			}
+		})
+	}
+}
+
+func TestStore_MixedCompression(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	plain := NewStore(backend, Options{})
+	hash, err := plain.WriteBlobStream(strings.NewReader("written before compression"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	compressed := NewStore(backend, Options{Compression: CompressionGzip})
+
+	data, err := compressed.ReadBlob(hash)
+	if err != nil {
+		t.Fatalf("ReadBlob() error: %v", err)
+	}
+
+	if string(data) != "written before compression" {
+		t.Errorf("ReadBlob() = %q", data)
+	}
+
+	if _, err := compressed.WriteBlobStream(strings.NewReader("written before compression")); err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	count := 0
+	backend.Walk(func(info ObjectInfo) error {
+		count++
+		return nil
+	})
+
+	if count != 1 {
+		t.Errorf("backend holds %d objects, want 1 (dedup across encodings)", count)
+	}
+}
+
+func TestStore_ChunkedRoundTrip(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewStore(backend, Options{Chunking: true, ChunkAvgSize: 1024})
+
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(7)).Read(data)
+
+	hash, err := store.WriteBlobStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	want := fmt.Sprintf("%x", sha256.Sum256(data))
+	if hash != want {
+		t.Errorf("hash = %s, want whole-file SHA-256 %s", hash, want)
+	}
+
+	manifest, err := store.ReadManifest(hash)
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %v", err)
+	}
+	if len(manifest.Chunks) < 2 {
+		t.Errorf("manifest has %d chunks, want several", len(manifest.Chunks))
+	}
+
+	got, err := store.ReadBlob(hash)
+	if err != nil {
+		t.Fatalf("ReadBlob() error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("reassembled content differs from original")
+	}
+
+	info, err := store.Stat(hash)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Stat().Size = %d, want %d", info.Size, len(data))
+	}
+}
+
+func TestStore_ChunkedVersionsShareChunks(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewStore(backend, Options{Chunking: true, ChunkAvgSize: 1024})
+
+	original := make([]byte, 64*1024)
+	rand.New(rand.NewSource(8)).Read(original)
+
+	edited := bytes.Clone(original)
+	edited[32*1024] ^= 0xff
+
+	if _, err := store.WriteBlobStream(bytes.NewReader(original)); err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	var before int64
+	backend.Walk(func(info ObjectInfo) error {
+		before += info.Size
+		return nil
+	})
+
+	if _, err := store.WriteBlobStream(bytes.NewReader(edited)); err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	var after int64
+	backend.Walk(func(info ObjectInfo) error {
+		after += info.Size
+		return nil
+	})
+
+	if growth := after - before; growth > int64(len(original))/4 {
+		t.Errorf("second version added %d bytes, want far less than %d", growth, len(original))
+	}
+}
+
+func TestStore_ChunkedSmallBlobStoredWhole(t *testing.T) {
+	store := NewStore(NewMemoryBackend(), Options{Chunking: true, ChunkAvgSize: 1024})
+
+	hash, err := store.WriteBlobStream(strings.NewReader("tiny"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	if _, err := store.ReadManifest(hash); err == nil {
+		t.Error("ReadManifest() succeeded for a single-chunk blob")
+	}
+
+	data, err := store.ReadBlob(hash)
+	if err != nil || string(data) != "tiny" {
+		t.Errorf("ReadBlob() = %q, %v", data, err)
+	}
+}
+
+func TestStore_HashAlgorithms(t *testing.T) {
+	backend, err := NewFSBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	legacy := NewStore(backend, Options{})
+	legacyHash, err := legacy.WriteBlobStream(strings.NewReader("written with sha256"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+
+	store := NewStore(backend, Options{HashAlgorithm: objects.AlgoSHA1})
+
+	hash, err := store.WriteBlobStream(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("WriteBlobStream() error: %v", err)
+	}
+	if hash != "sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d" {
+		t.Errorf("hash = %q, want sha1:<hex>", hash)
+	}
+
+	for _, digest := range []string{hash, legacyHash, "sha256:" + legacyHash} {
+		if _, err := store.ReadBlob(digest); err != nil {
+			t.Errorf("ReadBlob(%q) error: %v", digest, err)
+		}
+	}
+
+	keys := make(map[string]bool)
+	backend.Walk(func(info ObjectInfo) error {
+		keys[info.Key] = true
+		return nil
+	})
+	if !keys[hash] || !keys[legacyHash] {
+		t.Errorf("Walk() keys = %v, want %s and %s", keys, hash, legacyHash)
+	}
+}
+
+func TestStore_WriteHooks(t *testing.T) {
+	for _, chunking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("chunking=%v", chunking), func(t *testing.T) {
+			store := NewStore(NewMemoryBackend(), Options{Chunking: chunking, ChunkAvgSize: 1024})
+
+			h := hooks.New()
+			var events []hooks.Event
+			h.Register(hooks.Post, hooks.BlobWritten, func(e hooks.Event) error {
+				events = append(events, e)
+				return nil
+			})
+			h.Register(hooks.Post, hooks.BlobDeduplicated, func(e hooks.Event) error {
+				events = append(events, e)
+				return nil
+			})
+			h.Register(hooks.Pre, hooks.BlobWritten, func(e hooks.Event) error {
+				if e.Size == 3 {
+					return fmt.Errorf("rejected")
+				}
+				return nil
+			})
+			store.SetHooks(h)
+
+			data := make([]byte, 32*1024)
+			rand.New(rand.NewSource(3)).Read(data)
+
+			hash, err := store.WriteBlobStream(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("WriteBlobStream() error: %v", err)
+			}
+			if _, err := store.WriteBlobStream(bytes.NewReader(data)); err != nil {
+				t.Fatalf("WriteBlobStream() error: %v", err)
+			}
+
+			// One event per blob, however many chunks it was cut into.
+			if len(events) != 2 || events[0].Kind != hooks.BlobWritten || events[1].Kind != hooks.BlobDeduplicated {
+				t.Fatalf("events = %+v, want written then deduplicated", events)
+			}
+			if events[0].Hash != hash || events[0].Size != int64(len(data)) {
+				t.Errorf("event = %+v, want hash %s and size %d", events[0], hash, len(data))
+			}
+
+			if _, err := store.WriteBlobStream(strings.NewReader("bad")); !errors.Is(err, hooks.ErrVetoed) {
+				t.Fatalf("WriteBlobStream() vetoed error = %v, want ErrVetoed", err)
+			}
+			vetoed := objects.FormatDigest(objects.AlgoSHA256, sha256Sum([]byte("bad")))
+			if exists, _ := store.Exists(vetoed); exists {
+				t.Error("vetoed blob was stored")
+			}
+		})
+	}
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
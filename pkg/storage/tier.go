@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SteliosSpanos/mini-CAS/pkg/objects"
+)
+
+const (
+	TierHot  = "hot"
+	TierCold = "cold"
+
+	// DefaultDemoteAfter is how long a blob may go unread before demotion
+	// when the repository does not configure it.
+	DefaultDemoteAfter = 30 * 24 * time.Hour
+)
+
+var ErrNoTiering = errors.New("repository has no cold tier")
+
+// TieredBackend keeps objects in one of two backends: a fast hot tier that
+// receives every new object, and a slower cold tier for objects nobody has
+// read in a while. Reads and stats look in the hot tier first, so callers
+// never need to know where an object lives; moving objects between tiers is
+// left to Promote and Demote.
+type TieredBackend struct {
+	hot  Backend
+	cold Backend
+}
+
+type TierResult struct {
+	Demoted      []string
+	DemotedBytes int64
+	Hot          int
+	Cold         int
+}
+
+func NewTieredBackend(hot, cold Backend) *TieredBackend {
+	return &TieredBackend{hot: hot, cold: cold}
+}
+
+func (b *TieredBackend) Hot() Backend {
+	return b.hot
+}
+
+func (b *TieredBackend) Cold() Backend {
+	return b.cold
+}
+
+func (b *TieredBackend) Create() (ObjectWriter, error) {
+	return b.hot.Create()
+}
+
+func (b *TieredBackend) Open(key string) (io.ReadCloser, error) {
+	rc, err := b.hot.Open(key)
+	if !errors.Is(err, ErrNotFound) {
+		return rc, err
+	}
+	return b.cold.Open(key)
+}
+
+func (b *TieredBackend) Stat(key string) (ObjectInfo, error) {
+	info, err := b.hot.Stat(key)
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+	return b.cold.Stat(key)
+}
+
+// Delete removes the object from both tiers, since an interrupted move can
+// leave a copy in each.
+func (b *TieredBackend) Delete(key string) error {
+	hotErr := b.hot.Delete(key)
+	if hotErr != nil && !errors.Is(hotErr, ErrNotFound) {
+		return hotErr
+	}
+
+	coldErr := b.cold.Delete(key)
+	if coldErr != nil && !errors.Is(coldErr, ErrNotFound) {
+		return coldErr
+	}
+
+	if hotErr != nil && coldErr != nil {
+		return hotErr
+	}
+	return nil
+}
+
+func (b *TieredBackend) Touch(key string) error {
+	err := b.hot.Touch(key)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return b.cold.Touch(key)
+}
+
+func (b *TieredBackend) Walk(fn func(ObjectInfo) error) error {
+	seen := make(map[string]bool)
+
+	err := b.hot.Walk(func(info ObjectInfo) error {
+		seen[info.Key] = true
+		return fn(info)
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.cold.Walk(func(info ObjectInfo) error {
+		if seen[info.Key] {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+func (b *TieredBackend) Tier(key string) (string, error) {
+	if _, err := b.hot.Stat(key); err == nil {
+		return TierHot, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+
+	if _, err := b.cold.Stat(key); err != nil {
+		return "", err
+	}
+	return TierCold, nil
+}
+
+// Promote moves an object into the hot tier. The copy is committed before
+// the cold one is removed, so the object stays readable throughout.
+func (b *TieredBackend) Promote(key string) error {
+	if _, err := b.hot.Stat(key); err == nil {
+		return nil
+	}
+	return moveObject(b.cold, b.hot, key)
+}
+
+func (b *TieredBackend) Demote(key string) error {
+	if _, err := b.hot.Stat(key); errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return moveObject(b.hot, b.cold, key)
+}
+
+func (b *TieredBackend) Repack(maxObjectSize int64) (RepackResult, error) {
+	repacker, ok := b.hot.(interface {
+		Repack(maxObjectSize int64) (RepackResult, error)
+	})
+	if !ok {
+		return RepackResult{}, fmt.Errorf("storage backend does not support packs")
+	}
+
+	return repacker.Repack(maxObjectSize)
+}
+
+func moveObject(from, to Backend, key string) error {
+	rc, err := from.Open(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	writer, err := to.Create()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, rc); err != nil {
+		writer.Abort()
+		return fmt.Errorf("failed to copy %s: %w", key, err)
+	}
+
+	if err := writer.Commit(key); err != nil {
+		return err
+	}
+
+	return from.Delete(key)
+}
+
+// promote moves a blob that is being read back into the hot tier. Failure
+// only costs speed, since the cold copy is still served.
+func (s *Store) promote(key string) {
+	if tiers, name, ok := s.tiers(); ok {
+		tiers.Promote(name(key))
+	}
+}
+
+// tiers finds the TieredBackend under the store, along with the mapping from
+// logical keys to the names it stores them under.
+func (s *Store) tiers() (*TieredBackend, func(string) string, bool) {
+	name := func(key string) string { return key }
+	if enc, ok := Unwrap[*EncryptedBackend](s.backend); ok {
+		name = enc.name
+	}
+
+	tiers, ok := Unwrap[*TieredBackend](s.backend)
+	return tiers, name, ok
+}
+
+// DemoteIdle moves blobs that have not been read within idle to the cold
+// tier, using the access log to find them. Pinned blobs stay hot.
+func (s *Store) DemoteIdle(idle time.Duration, dryRun bool) (TierResult, error) {
+	tiers, name, ok := s.tiers()
+	if !ok || s.access == nil {
+		return TierResult{}, ErrNoTiering
+	}
+
+	pinned, err := s.access.Pinned()
+	if err != nil {
+		return TierResult{}, fmt.Errorf("failed to read pins: %w", err)
+	}
+
+	keep := make(map[string]bool)
+	for _, hash := range pinned {
+		keep[hash] = true
+	}
+
+	records, err := s.access.LeastRecent()
+	if err != nil {
+		return TierResult{}, fmt.Errorf("failed to read access log: %w", err)
+	}
+
+	cutoff := time.Now().Add(-idle)
+	var result TierResult
+
+	for _, record := range records {
+		if !record.LastAccess.Before(cutoff) || keep[record.Hash] {
+			continue
+		}
+
+		key, _, err := s.locate(record.Hash)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		tier, err := tiers.Tier(name(key))
+		if err != nil || tier != TierHot {
+			continue
+		}
+
+		if !dryRun {
+			if err := tiers.Demote(name(key)); err != nil {
+				return result, fmt.Errorf("failed to demote %s: %w", objects.ShortDigest(record.Hash), err)
+			}
+		}
+
+		result.Demoted = append(result.Demoted, record.Hash)
+		result.DemotedBytes += record.StoredSize
+	}
+
+	err = tiers.Hot().Walk(func(ObjectInfo) error {
+		result.Hot++
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to walk hot tier: %w", err)
+	}
+
+	err = tiers.Cold().Walk(func(ObjectInfo) error {
+		result.Cold++
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to walk cold tier: %w", err)
+	}
+
+	return result, nil
+}
+
+// Tiered reports whether the store has a cold tier to demote blobs to.
+func (s *Store) Tiered() bool {
+	_, _, ok := s.tiers()
+	return ok && s.access != nil
+}
+
+func (s *Store) DemoteAfter() time.Duration {
+	if s.opts.DemoteAfter > 0 {
+		return s.opts.DemoteAfter
+	}
+	return DefaultDemoteAfter
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTieredStore(t *testing.T) (*Store, *TieredBackend) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	hot, err := NewFSBackend(filepath.Join(dir, "hot"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+	cold, err := NewFSBackend(filepath.Join(dir, "cold"))
+	if err != nil {
+		t.Fatalf("NewFSBackend() error: %v", err)
+	}
+
+	access, err := OpenAccessLog(filepath.Join(dir, AccessLogFile))
+	if err != nil {
+		t.Fatalf("OpenAccessLog() error: %v", err)
+	}
+
+	tiers := NewTieredBackend(NewPackBackend(hot), cold)
+	store := NewStore(tiers, Options{})
+	if err := store.SetAccessLog(access); err != nil {
+		t.Fatalf("SetAccessLog() error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store, tiers
+}
+
+func assertTier(t *testing.T, tiers *TieredBackend, key, want string) {
+	t.Helper()
+
+	got, err := tiers.Tier(key)
+	if err != nil {
+		t.Fatalf("Tier(%s) error: %v", key[:8], err)
+	}
+	if got != want {
+		t.Errorf("Tier(%s) = %s, want %s", key[:8], got, want)
+	}
+}
+
+func TestStore_DemotesIdleAndPromotesOnRead(t *testing.T) {
+	store, tiers := newTieredStore(t)
+
+	idle := writeSized(t, store, "a", 100)
+	recent := writeSized(t, store, "b", 100)
+	pinned := writeSized(t, store, "c", 100)
+	backdate(t, store, idle, 48*time.Hour)
+	backdate(t, store, pinned, 48*time.Hour)
+	if err := store.Pin(pinned); err != nil {
+		t.Fatalf("Pin() error: %v", err)
+	}
+
+	result, err := store.DemoteIdle(24*time.Hour, true)
+	if err != nil {
+		t.Fatalf("DemoteIdle() error: %v", err)
+	}
+	if len(result.Demoted) != 1 || result.Demoted[0] != idle {
+		t.Fatalf("dry run demoted = %v, want only %s", result.Demoted, idle[:8])
+	}
+	assertTier(t, tiers, idle, TierHot)
+
+	result, err = store.DemoteIdle(24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("DemoteIdle() error: %v", err)
+	}
+	if result.Hot != 2 || result.Cold != 1 {
+		t.Errorf("DemoteIdle() = %+v, want 2 hot and 1 cold", result)
+	}
+	assertTier(t, tiers, idle, TierCold)
+	assertTier(t, tiers, recent, TierHot)
+	assertTier(t, tiers, pinned, TierHot)
+
+	info, err := store.Stat(idle)
+	if err != nil || info.Size != 100 {
+		t.Errorf("Stat() on cold blob = %+v, %v", info, err)
+	}
+
+	data, err := store.ReadBlob(idle)
+	if err != nil {
+		t.Fatalf("ReadBlob() error: %v", err)
+	}
+	if string(data) != strings.Repeat("a", 100) {
+		t.Error("ReadBlob() returned wrong content")
+	}
+	assertTier(t, tiers, idle, TierHot)
+}
+
+func TestStore_DemotesPackedObjects(t *testing.T) {
+	store, tiers := newTieredStore(t)
+
+	hash := writeSized(t, store, "p", 50)
+	if _, err := store.Repack(1024); err != nil {
+		t.Fatalf("Repack() error: %v", err)
+	}
+	backdate(t, store, hash, 48*time.Hour)
+
+	if _, err := store.DemoteIdle(24*time.Hour, false); err != nil {
+		t.Fatalf("DemoteIdle() error: %v", err)
+	}
+	assertTier(t, tiers, hash, TierCold)
+
+	count := 0
+	store.Walk(func(ObjectInfo) error { count++; return nil })
+	if count != 1 {
+		t.Errorf("Walk() visited %d objects, want 1", count)
+	}
+}
+
+func TestTieredBackend_DeleteRemovesBothCopies(t *testing.T) {
+	tiers := NewTieredBackend(NewMemoryBackend(), NewMemoryBackend())
+
+	for _, backend := range []Backend{tiers.Hot(), tiers.Cold()} {
+		writer, _ := backend.Create()
+		writer.Write([]byte("dup"))
+		if err := writer.Commit("key"); err != nil {
+			t.Fatalf("Commit() error: %v", err)
+		}
+	}
+
+	if err := tiers.Delete("key"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := tiers.Stat("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() after delete error = %v, want ErrNotFound", err)
+	}
+	if err := tiers.Delete("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStore_DemoteIdleWithoutTiers(t *testing.T) {
+	store := newCacheStore(t, 1000)
+
+	if _, err := store.DemoteIdle(time.Hour, false); !errors.Is(err, ErrNoTiering) {
+		t.Errorf("DemoteIdle() error = %v, want ErrNoTiering", err)
+	}
+}